@@ -1,18 +1,328 @@
 package main
 
-// This file contains helpers to initialize application code that is specific to this service
+// This file contains the application lifecycle and helpers to initialize application code that is specific to this service
 import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
 	"github.com/caring/ford-mustang/internal/db"
+	"github.com/caring/ford-mustang/internal/db/memory"
+	"github.com/caring/ford-mustang/internal/handlers"
+	"github.com/caring/ford-mustang/pb"
 	"github.com/caring/go-packages/pkg/logging"
 	"github.com/getsentry/sentry-go"
+	"github.com/soheilhy/cmux"
+	"google.golang.org/grpc"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 )
 
+// App is the ford-mustang server, gRPC and HTTP/1 multiplexed on a single listener with the
+// background work that goes with them. Its dependencies are given to NewApp rather than read
+// from env, so a full server can be run in process
+type App struct {
+	logger *logging.Logger
+	server *grpc.Server
+	// backend stores mustangs, it is the SQL store or the in memory store
+	backend db.Backend
+	// store backs categories, products and history, it is nil when running in memory
+	store *db.Store
+	// changes broadcasts the mustang changes committed by the backend
+	changes *db.Broadcaster
+	// publisher receives the outbox events, the relay only runs when there is one
+	publisher db.Publisher
+
+	// purgerCfg is nil when the purger does not run
+	purgerCfg   *purgerConfig
+	relayCfg    relayConfig
+	healthCfg   healthConfig
+	shutdownCfg shutdownConfig
+
+	// set by Start
+	lis         net.Listener
+	mux         cmux.CMux
+	httpServer  *http.Server
+	checker     *handlers.HealthChecker
+	errs        chan error
+	stopWork    context.CancelFunc
+	stopGateway context.CancelFunc
+	work        sync.WaitGroup
+}
+
+// AppOption configures an App
+type AppOption func(*App)
+
+// WithSQLStore serves the RPCs that need a SQL store from store, it is closed when the app stops
+func WithSQLStore(store *db.Store) AppOption {
+	return func(a *App) {
+		a.store = store
+	}
+}
+
+// WithPurger permanently deletes soft deleted rows from the SQL store as cfg sets out
+func WithPurger(cfg purgerConfig) AppOption {
+	return func(a *App) {
+		a.purgerCfg = &cfg
+	}
+}
+
+// WithOutboxRelay delivers the change events in the SQL store's outbox to publisher as cfg sets out
+func WithOutboxRelay(publisher db.Publisher, cfg relayConfig) AppOption {
+	return func(a *App) {
+		a.publisher = publisher
+		a.relayCfg = cfg
+	}
+}
+
+// WithHealthConfig sets how dependencies are health checked
+func WithHealthConfig(cfg healthConfig) AppOption {
+	return func(a *App) {
+		a.healthCfg = cfg
+	}
+}
+
+// WithShutdownConfig sets how long the app takes to stop
+func WithShutdownConfig(cfg shutdownConfig) AppOption {
+	return func(a *App) {
+		a.shutdownCfg = cfg
+	}
+}
+
+// NewApp gives an app that serves backend over server, committed changes are watched through changes.
+// Without options it runs no background work and uses the default health and shutdown config
+func NewApp(logger *logging.Logger, server *grpc.Server, backend db.Backend, changes *db.Broadcaster, opts ...AppOption) *App {
+	a := &App{
+		logger:      logger,
+		server:      server,
+		backend:     backend,
+		changes:     changes,
+		healthCfg:   healthConfig{interval: 5 * time.Second, timeout: time.Second},
+		shutdownCfg: shutdownConfig{drainDelay: 5 * time.Second, timeout: 20 * time.Second},
+	}
+	for _, opt := range opts {
+		opt(a)
+	}
+	return a
+}
+
+// Start listens on addr and serves until Stop, it returns once the app is serving
+func (a *App) Start(addr string) error {
+	if a.publisher != nil && a.relayCfg.interval <= 0 {
+		return errors.New("the outbox relay interval must be positive")
+	}
+	if a.purgerCfg != nil && (a.purgerCfg.retention <= 0 || a.purgerCfg.interval <= 0) {
+		return errors.New("the purger retention and interval must be positive")
+	}
+
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	_, port, err := net.SplitHostPort(lis.Addr().String())
+	if err != nil {
+		lis.Close()
+		return err
+	}
+
+	// register the server with gRPC
+	pb.RegisterFordMustangServiceServer(a.server, &service{logger: a.logger, backend: a.backend, store: a.store, changes: a.changes})
+
+	// report the health of the registered services from periodic dependency checks over the standard
+	// gRPC health protocol, and over HTTP for kubernetes probes
+	a.checker = initHealthChecker(a.logger, a.server, a.backend, a.healthCfg)
+	healthpb.RegisterHealthServer(a.server, a.checker.Server())
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/livez", a.checker.Livez)
+	mux.HandleFunc("/readyz", a.checker.Readyz)
+
+	// Add a health check endpoint for automated container monitoring
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	// serve the REST gateway beside the health check, it calls back into the gRPC server
+	// and is closed once the app has stopped
+	gatewayCtx, stopGateway := context.WithCancel(context.Background())
+	gateway, err := newGateway(gatewayCtx, a.logger, "localhost:"+port)
+	if err != nil {
+		stopGateway()
+		lis.Close()
+		return err
+	}
+	mux.Handle("/v1/", gateway)
+	if err = registerDocs(a.logger, mux); err != nil {
+		stopGateway()
+		lis.Close()
+		return err
+	}
+
+	a.lis = lis
+	a.stopGateway = stopGateway
+	a.httpServer = &http.Server{Handler: mux}
+
+	// background work is stopped when the shutdown begins and waited on before the store is closed
+	workCtx, stopWork := context.WithCancel(context.Background())
+	a.stopWork = stopWork
+	a.goWork(func() { a.checker.Run(workCtx, a.healthCfg.interval) })
+
+	// permanently delete soft deleted rows once they age out of retention
+	// and deliver change events written to the outbox
+	if a.store != nil && a.purgerCfg != nil {
+		a.goWork(func() { runPurger(workCtx, a.logger, a.store, *a.purgerCfg) })
+	}
+	if a.store != nil && a.publisher != nil {
+		a.goWork(func() { runOutboxRelay(workCtx, a.logger, a.store, a.publisher, a.relayCfg) })
+	}
+
+	// create a cmux
+	a.mux = cmux.New(lis)
+	// match connections in order:
+	// first grpc, then http.
+	grpcL := a.mux.Match(cmux.HTTP2())
+	httpL := a.mux.Match(cmux.HTTP1Fast())
+
+	// make an error channel to collect the exits of each protocol's Serve(),
+	// it is buffered so they can still exit once nothing is reading it
+	a.errs = make(chan error, 3)
+
+	// start listeners for each protocol
+	go func() { a.errs <- a.server.Serve(grpcL) }()
+	go func() { a.errs <- a.httpServer.Serve(httpL) }()
+
+	// serve it up
+	go func() { a.errs <- a.mux.Serve() }()
+
+	// all systems are a go
+	a.logger.Info("server started: multiplexed http/1, http/2",
+		logging.String("port", port),
+		logging.String("multiplexed", "true"),
+	)
+	return nil
+}
+
+// Addr is the address the app is listening on once started
+func (a *App) Addr() net.Addr {
+	return a.lis.Addr()
+}
+
+// Errors delivers the error each protocol's Serve exits with
+func (a *App) Errors() <-chan error {
+	return a.errs
+}
+
+// Stop shuts the app down gracefully. Readiness fails first and load balancers are given the drain
+// delay to notice, then in flight requests are drained for up to the shutdown timeout before the
+// listener and the store are closed. The first error met is returned, the rest are logged
+func (a *App) Stop() error {
+	a.logger.Info("Shutting down", logging.String("drain_delay", a.shutdownCfg.drainDelay.String()))
+
+	// an app that never started, or failed to, has only what NewApp was given to release
+	started := a.httpServer != nil
+
+	// fail readiness first and give load balancers time to notice before draining
+	if a.checker != nil {
+		a.checker.Shutdown()
+	}
+	if a.stopWork != nil {
+		a.stopWork()
+	}
+	if started {
+		time.Sleep(a.shutdownCfg.drainDelay)
+	}
+
+	drainCtx, cancel := context.WithTimeout(context.Background(), a.shutdownCfg.timeout)
+	defer cancel()
+
+	var first error
+	fail := func(msg string, err error) {
+		sentry.CaptureException(err)
+		a.logger.Error(msg + err.Error())
+		if first == nil {
+			first = err
+		}
+	}
+
+	// REST requests are drained before gRPC, the gateway calls into the gRPC server
+	if started {
+		if err := a.httpServer.Shutdown(drainCtx); err != nil {
+			fail("Error draining HTTP requests:", err)
+		}
+	}
+
+	// watches never finish on their own, they are ended so watchers reconnect elsewhere
+	a.changes.Close()
+	stopGRPC(drainCtx, a.logger, a.server)
+	if started {
+		// cmux leaves the listener it was given open
+		a.mux.Close()
+		a.lis.Close()
+		a.stopGateway()
+	}
+
+	a.work.Wait()
+	if a.store != nil {
+		if err := a.store.Close(); err != nil {
+			fail("Error closing store:", err)
+		}
+	}
+
+	a.logger.Info("Shutdown complete")
+	return first
+}
+
+// goWork runs fn in the background as work Stop waits on
+func (a *App) goWork(fn func()) {
+	a.work.Add(1)
+	go func() {
+		defer a.work.Done()
+		fn()
+	}()
+}
+
+// stopGRPC waits for in flight RPCs to finish, the RPCs still running once ctx is done are cancelled
+func stopGRPC(ctx context.Context, logger *logging.Logger, server *grpc.Server) {
+	stopped := make(chan struct{})
+	go func() {
+		server.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		logger.Warn("Timed out draining gRPC requests, cancelling the rest")
+		server.Stop()
+		<-stopped
+	}
+}
 
-// initialize the store service
-func initStore(logger *logging.Logger, connectionString string) *db.Store {
+// initialize the store service, reads are spread across any replicas given
+// and committed mustang changes are published to changes
+func initStore(logger *logging.Logger, connectionString string, replicas []string, changes *db.Broadcaster) *db.Store {
 	logger.Debug("Initializing Store")
+	maxRetries, err := strconv.Atoi(envDefault("DB_TX_MAX_RETRIES", "3"))
+	if err != nil || maxRetries < 0 {
+		logger.Fatal("Error parsing DB_TX_MAX_RETRIES variable, expected a non negative integer")
+	}
 	// establish a store and connection to the db
-	store, err := db.NewStore(connectionString)
+	store, err := db.NewStore(connectionString,
+		db.WithReplicas(replicas...),
+		db.WithBroadcaster(changes),
+		db.WithIdempotencyTTL(setIdempotencyTTL(logger)),
+		db.WithTxRetries(maxRetries, 10*time.Millisecond),
+		// contention shows up in the logs so it can be alerted on
+		db.WithTxRetryHook(func(attempt int, err error) {
+			logger.Warn("Retrying transaction after lock contention",
+				logging.String("attempt", strconv.Itoa(attempt)),
+				logging.String("error", err.Error()),
+			)
+		}),
+	)
 	if err != nil {
 		sentry.CaptureException(err)
 		logger.Fatal("Failed to initialize store:" + err.Error())
@@ -21,3 +331,269 @@ func initStore(logger *logging.Logger, connectionString string) *db.Store {
 	return store
 }
 
+// initialize an in memory store for running without a database, data is lost on exit
+func initMemoryStore(logger *logging.Logger, changes *db.Broadcaster) db.Backend {
+	logger.Warn("Using the in memory storage backend, data will not persist and only mustangs are supported")
+	return memory.NewStore(memory.WithBroadcaster(changes), memory.WithIdempotencyTTL(setIdempotencyTTL(logger)))
+}
+
+// read how long idempotency keys are kept from env, either backend keeps them for IDEMPOTENCY_KEY_TTL
+func setIdempotencyTTL(logger *logging.Logger) time.Duration {
+	ttl, err := time.ParseDuration(envDefault("IDEMPOTENCY_KEY_TTL", "24h"))
+	if err != nil || ttl <= 0 {
+		logger.Fatal("Error parsing IDEMPOTENCY_KEY_TTL variable, expected a positive duration")
+	}
+	return ttl
+}
+
+// initialize the broadcaster that feeds mustang changes to watchers. WATCH_HISTORY_SIZE changes
+// are kept for watchers resuming after a disconnect and a watcher more than WATCH_BUFFER_SIZE
+// changes behind is dropped
+func initBroadcaster(logger *logging.Logger) *db.Broadcaster {
+	logger.Debug("Initializing change broadcaster")
+	history, err := strconv.Atoi(envDefault("WATCH_HISTORY_SIZE", "1000"))
+	if err != nil || history < 0 {
+		logger.Fatal("Error parsing WATCH_HISTORY_SIZE variable, expected a non negative integer")
+	}
+	buffer, err := strconv.Atoi(envDefault("WATCH_BUFFER_SIZE", "100"))
+	if err != nil || buffer <= 0 {
+		logger.Fatal("Error parsing WATCH_BUFFER_SIZE variable, expected a positive integer")
+	}
+	logger.Debug("Done")
+	return db.NewBroadcaster(history, buffer)
+}
+
+// healthConfig controls how often and for how long the health checker checks dependencies
+type healthConfig struct {
+	interval time.Duration
+	timeout  time.Duration
+}
+
+// load the health checker config from env
+func setHealthConfig(logger *logging.Logger) healthConfig {
+	logger.Debug("Loading health check config")
+	interval, err := time.ParseDuration(envDefault("HEALTH_CHECK_INTERVAL", "5s"))
+	if err != nil || interval <= 0 {
+		logger.Fatal("Error parsing HEALTH_CHECK_INTERVAL variable, expected a positive duration")
+	}
+	timeout, err := time.ParseDuration(envDefault("HEALTH_CHECK_TIMEOUT", "1s"))
+	if err != nil || timeout <= 0 {
+		logger.Fatal("Error parsing HEALTH_CHECK_TIMEOUT variable, expected a positive duration")
+	}
+	logger.Debug("Done")
+	return healthConfig{
+		interval: interval,
+		timeout:  timeout,
+	}
+}
+
+// shutdownConfig controls how long a graceful shutdown takes
+type shutdownConfig struct {
+	// drainDelay is how long readiness fails before requests are drained,
+	// giving load balancers time to stop routing to the instance
+	drainDelay time.Duration
+	// timeout is how long in flight requests are given to finish
+	timeout time.Duration
+}
+
+// load the shutdown config from env, the defaults fit within the 30 second grace
+// period kubernetes gives a pod before killing it
+func setShutdownConfig(logger *logging.Logger) shutdownConfig {
+	logger.Debug("Loading shutdown config")
+	drainDelay, err := time.ParseDuration(envDefault("SHUTDOWN_DRAIN_DELAY", "5s"))
+	if err != nil || drainDelay < 0 {
+		logger.Fatal("Error parsing SHUTDOWN_DRAIN_DELAY variable, expected a non negative duration")
+	}
+	timeout, err := time.ParseDuration(envDefault("SHUTDOWN_TIMEOUT", "20s"))
+	if err != nil || timeout <= 0 {
+		logger.Fatal("Error parsing SHUTDOWN_TIMEOUT variable, expected a positive duration")
+	}
+	logger.Debug("Done")
+	return shutdownConfig{
+		drainDelay: drainDelay,
+		timeout:    timeout,
+	}
+}
+
+// initialize the health checker for the services registered on server, the backend is
+// checked as the database dependency
+func initHealthChecker(logger *logging.Logger, server *grpc.Server, backend db.Backend, cfg healthConfig) *handlers.HealthChecker {
+	logger.Debug("Initializing health checker")
+	var services []string
+	for name := range server.GetServiceInfo() {
+		services = append(services, name)
+	}
+	checker := handlers.NewHealthChecker(logger, cfg.timeout, services, map[string]handlers.Check{
+		"database": backend.Ping,
+	})
+	logger.Debug("Done")
+	return checker
+}
+
+// purgerConfig controls how often and how much the purger permanently deletes
+type purgerConfig struct {
+	retention time.Duration
+	interval  time.Duration
+	batchSize int
+}
+
+// load the purger config from env, retention defaults to the 30 days compliance requires. a retention
+// of 0 or less would purge rows as soon as they are deleted and is rejected, as is an interval the
+// purger can't run on
+func setPurgerConfig(logger *logging.Logger) (purgerConfig, error) {
+	logger.Debug("Loading purger config")
+	retention, err := time.ParseDuration(envDefault("PURGE_RETENTION", "720h"))
+	if err != nil || retention <= 0 {
+		return purgerConfig{}, errors.New("Error parsing PURGE_RETENTION variable, expected a positive duration")
+	}
+	interval, err := time.ParseDuration(envDefault("PURGE_INTERVAL", "1h"))
+	if err != nil || interval <= 0 {
+		return purgerConfig{}, errors.New("Error parsing PURGE_INTERVAL variable, expected a positive duration")
+	}
+	batchSize, err := strconv.Atoi(envDefault("PURGE_BATCH_SIZE", "500"))
+	if err != nil || batchSize <= 0 {
+		return purgerConfig{}, errors.New("Error parsing PURGE_BATCH_SIZE variable, expected a positive integer")
+	}
+	logger.Debug("Done")
+	return purgerConfig{
+		retention: retention,
+		interval:  interval,
+		batchSize: batchSize,
+	}, nil
+}
+
+// runPurger permanently deletes soft deleted rows older than the retention window
+// on every interval until ctx is done
+func runPurger(ctx context.Context, logger *logging.Logger, store *db.Store, cfg purgerConfig) {
+	logger.Info("Purger started",
+		logging.String("retention", cfg.retention.String()),
+		logging.String("interval", cfg.interval.String()),
+	)
+
+	ticker := time.NewTicker(cfg.interval)
+	defer ticker.Stop()
+
+	for {
+		purge(ctx, logger, store, cfg)
+
+		select {
+		case <-ctx.Done():
+			logger.Info("Purger stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// purge runs a single pass of the purger and logs the rows deleted from each table
+func purge(ctx context.Context, logger *logging.Logger, store *db.Store, cfg purgerConfig) {
+	before := time.Now().UTC().Add(-cfg.retention)
+
+	counts, err := store.Purge(ctx, before, cfg.batchSize)
+	for _, c := range counts {
+		logger.Info("Purged soft deleted rows",
+			logging.String("table", c.Table),
+			logging.String("count", strconv.FormatInt(c.Count, 10)),
+			logging.String("deleted_before", before.Format(time.RFC3339)),
+		)
+	}
+	if err != nil {
+		sentry.CaptureException(err)
+		logger.Error("Error purging soft deleted rows:" + err.Error())
+	}
+}
+
+// relayConfig controls how often and how much the outbox relay publishes
+type relayConfig struct {
+	interval  time.Duration
+	batchSize int
+	// publishTimeout bounds each publish, the batch stays locked while it is published
+	publishTimeout time.Duration
+}
+
+// load the outbox relay config from env
+func setRelayConfig(logger *logging.Logger) (relayConfig, error) {
+	logger.Debug("Loading outbox relay config")
+	interval, err := time.ParseDuration(envDefault("OUTBOX_RELAY_INTERVAL", "1s"))
+	if err != nil || interval <= 0 {
+		return relayConfig{}, errors.New("Error parsing OUTBOX_RELAY_INTERVAL variable, expected a positive duration")
+	}
+	batchSize, err := strconv.Atoi(envDefault("OUTBOX_RELAY_BATCH_SIZE", "100"))
+	if err != nil || batchSize <= 0 {
+		return relayConfig{}, errors.New("Error parsing OUTBOX_RELAY_BATCH_SIZE variable, expected a positive integer")
+	}
+	publishTimeout, err := time.ParseDuration(envDefault("OUTBOX_RELAY_PUBLISH_TIMEOUT", "5s"))
+	if err != nil || publishTimeout <= 0 {
+		return relayConfig{}, errors.New("Error parsing OUTBOX_RELAY_PUBLISH_TIMEOUT variable, expected a positive duration")
+	}
+	logger.Debug("Done")
+	return relayConfig{
+		interval:       interval,
+		batchSize:      batchSize,
+		publishTimeout: publishTimeout,
+	}, nil
+}
+
+// initialize the publisher the outbox relay delivers change events to
+func initPublisher(logger *logging.Logger) db.Publisher {
+	switch name := envDefault("OUTBOX_PUBLISHER", "log"); name {
+	case "log":
+		return &logPublisher{logger: logger}
+	default:
+		logger.Fatal("Error parsing OUTBOX_PUBLISHER variable, unknown publisher " + name)
+		return nil
+	}
+}
+
+// logPublisher publishes change events to the service log, for local development
+// and until a message broker is wired in
+type logPublisher struct {
+	logger *logging.Logger
+}
+
+// Publish logs the event
+func (p *logPublisher) Publish(ctx context.Context, event *db.OutboxEvent) error {
+	p.logger.Info("Published change event",
+		logging.String("event_id", strconv.FormatInt(event.ID, 10)),
+		logging.String("event_type", event.EventType),
+		logging.String("aggregate_id", event.AggregateID.String()),
+		logging.String("payload", string(event.Payload)),
+	)
+	return nil
+}
+
+// runOutboxRelay delivers pending outbox events to the publisher until ctx is done. full batches
+// are relayed back to back so a backlog drains quickly, otherwise the relay waits for the interval
+func runOutboxRelay(ctx context.Context, logger *logging.Logger, store *db.Store, publisher db.Publisher, cfg relayConfig) {
+	logger.Info("Outbox relay started",
+		logging.String("interval", cfg.interval.String()),
+		logging.String("batch_size", strconv.Itoa(cfg.batchSize)),
+		logging.String("publish_timeout", cfg.publishTimeout.String()),
+	)
+
+	ticker := time.NewTicker(cfg.interval)
+	defer ticker.Stop()
+
+	for {
+		sent, err := store.RelayOutbox(ctx, publisher, cfg.batchSize, cfg.publishTimeout)
+		if err != nil {
+			sentry.CaptureException(err)
+			logger.Error("Error relaying outbox events:" + err.Error())
+		}
+
+		if sent == cfg.batchSize && err == nil {
+			if ctx.Err() != nil {
+				logger.Info("Outbox relay stopped")
+				return
+			}
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			logger.Info("Outbox relay stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/caring/go-packages/pkg/logging"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+
+	"github.com/caring/ford-mustang/internal/db"
+	"github.com/caring/ford-mustang/internal/db/memory"
+	"github.com/caring/ford-mustang/internal/handlers"
+	"github.com/caring/ford-mustang/pb"
+)
+
+// newTestLogger gives a logger for the code under test
+func newTestLogger(t *testing.T) *logging.Logger {
+	logger, err := logging.NewLogger(&logging.Config{})
+	if ok := assert.NoError(t, err, "Expected no error"); !ok {
+		assert.FailNow(t, "test setup failed")
+	}
+	return logger
+}
+
+// setenv sets an env variable for the rest of the test
+func setenv(t *testing.T, key, value string) {
+	prev, ok := os.LookupEnv(key)
+	os.Setenv(key, value)
+	t.Cleanup(func() {
+		if ok {
+			os.Setenv(key, prev)
+		} else {
+			os.Unsetenv(key)
+		}
+	})
+}
+
+// newTestApp starts an app on a free port backed by the in memory store
+func newTestApp(t *testing.T) *App {
+	logger := newTestLogger(t)
+
+	changes := db.NewBroadcaster(10, 10)
+	server := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(handlers.UnaryErrorInterceptor(logger), handlers.UnaryActorInterceptor, handlers.UnaryReadPrimaryInterceptor),
+		grpc.ChainStreamInterceptor(handlers.StreamErrorInterceptor(logger), handlers.StreamActorInterceptor, handlers.StreamReadPrimaryInterceptor),
+	)
+	app := NewApp(logger, server, memory.NewStore(memory.WithBroadcaster(changes)), changes,
+		WithHealthConfig(healthConfig{interval: time.Hour, timeout: time.Second}),
+		WithShutdownConfig(shutdownConfig{timeout: time.Second}),
+	)
+
+	if ok := assert.NoError(t, app.Start("127.0.0.1:0"), "Expected the app to start"); !ok {
+		assert.FailNow(t, "test setup failed")
+	}
+	return app
+}
+
+func TestApp(t *testing.T) {
+	ctx := context.Background()
+	app := newTestApp(t)
+	addr := app.Addr().String()
+
+	conn, err := grpc.Dial(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if ok := assert.NoError(t, err, "Expected no dial error"); !ok {
+		assert.FailNow(t, "test setup failed")
+	}
+	defer conn.Close()
+
+	// ensures the app serves the mustang RPCs over gRPC
+	var created *pb.MustangResponse
+	t.Run("gRPC", func(t *testing.T) {
+		client := pb.NewFordMustangServiceClient(conn)
+
+		created, err = client.CreateMustang(ctx, &pb.CreateMustangRequest{Name: "Foobar"})
+		if ok := assert.NoError(t, err, "Expecting no create error"); !ok {
+			return
+		}
+
+		r, err := client.GetMustang(ctx, &pb.ByIDRequest{Id: created.Id})
+		assert.NoError(t, err, "Expecting no get error")
+		assert.Equal(t, "Foobar", r.GetName(), "Expected the created mustang")
+	})
+
+	// ensures the REST gateway shares the listener and reaches the same backend
+	t.Run("REST", func(t *testing.T) {
+		if created == nil {
+			t.Skip("no mustang was created")
+		}
+
+		resp, err := http.Get("http://" + addr + "/v1/mustangs/" + created.Id)
+		if ok := assert.NoError(t, err, "Expecting no get error"); !ok {
+			return
+		}
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode, "Expected the created mustang")
+	})
+
+	// ensures the health checks run once the app has started
+	t.Run("Health", func(t *testing.T) {
+		client := healthpb.NewHealthClient(conn)
+		assert.Eventually(t, func() bool {
+			resp, err := client.Check(ctx, &healthpb.HealthCheckRequest{})
+			return err == nil && resp.Status == healthpb.HealthCheckResponse_SERVING
+		}, time.Second, 10*time.Millisecond, "Expected the server to be serving")
+
+		resp, err := http.Get("http://" + addr + "/readyz")
+		if ok := assert.NoError(t, err, "Expecting no readyz error"); !ok {
+			return
+		}
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode, "Expected the app to be ready")
+	})
+
+	// ensures the app drains and stops listening
+	t.Run("Stop", func(t *testing.T) {
+		assert.NoError(t, app.Stop(), "Expecting no stop error")
+
+		_, err := http.Get("http://" + addr + "/livez")
+		assert.Error(t, err, "Expected the listener to be closed")
+	})
+}
+
+// ensures an app that was never started can still be stopped
+func TestApp_StopWithoutStart(t *testing.T) {
+	logger := newTestLogger(t)
+
+	changes := db.NewBroadcaster(10, 10)
+	app := NewApp(logger, grpc.NewServer(), memory.NewStore(memory.WithBroadcaster(changes)), changes,
+		WithShutdownConfig(shutdownConfig{timeout: time.Second}),
+	)
+
+	assert.NoError(t, app.Stop(), "Expecting no stop error")
+}
+
+func TestSetRelayConfig(t *testing.T) {
+	logger := newTestLogger(t)
+
+	// ensures the defaults are used when nothing is set
+	t.Run("Defaults", func(t *testing.T) {
+		setenv(t, "OUTBOX_RELAY_INTERVAL", "")
+		setenv(t, "OUTBOX_RELAY_BATCH_SIZE", "")
+		setenv(t, "OUTBOX_RELAY_PUBLISH_TIMEOUT", "")
+
+		cfg, err := setRelayConfig(logger)
+		assert.NoError(t, err, "Expecting no config error")
+		assert.Equal(t, relayConfig{interval: time.Second, batchSize: 100, publishTimeout: 5 * time.Second}, cfg, "Expected the default config")
+	})
+
+	// ensures intervals the relay's ticker can't run with are rejected
+	for _, interval := range []string{"0s", "-1s", "soon"} {
+		t.Run("Interval "+interval, func(t *testing.T) {
+			setenv(t, "OUTBOX_RELAY_INTERVAL", interval)
+
+			_, err := setRelayConfig(logger)
+			assert.EqualError(t, err, "Error parsing OUTBOX_RELAY_INTERVAL variable, expected a positive duration", "Expecting a config error")
+		})
+	}
+
+	// ensures a batch size that would never relay anything is rejected
+	t.Run("Batch size 0", func(t *testing.T) {
+		setenv(t, "OUTBOX_RELAY_BATCH_SIZE", "0")
+
+		_, err := setRelayConfig(logger)
+		assert.EqualError(t, err, "Error parsing OUTBOX_RELAY_BATCH_SIZE variable, expected a positive integer", "Expecting a config error")
+	})
+
+	// ensures a publish is always bounded
+	t.Run("Publish timeout 0s", func(t *testing.T) {
+		setenv(t, "OUTBOX_RELAY_PUBLISH_TIMEOUT", "0s")
+
+		_, err := setRelayConfig(logger)
+		assert.EqualError(t, err, "Error parsing OUTBOX_RELAY_PUBLISH_TIMEOUT variable, expected a positive duration", "Expecting a config error")
+	})
+}
+
+func TestSetPurgerConfig(t *testing.T) {
+	logger := newTestLogger(t)
+
+	// ensures the defaults keep deleted rows for the 30 days compliance requires
+	t.Run("Defaults", func(t *testing.T) {
+		setenv(t, "PURGE_RETENTION", "")
+		setenv(t, "PURGE_INTERVAL", "")
+		setenv(t, "PURGE_BATCH_SIZE", "")
+
+		cfg, err := setPurgerConfig(logger)
+		assert.NoError(t, err, "Expecting no config error")
+		assert.Equal(t, purgerConfig{retention: 720 * time.Hour, interval: time.Hour, batchSize: 500}, cfg, "Expected the default config")
+	})
+
+	// ensures a retention that would purge rows as soon as they are deleted is rejected
+	for _, retention := range []string{"0s", "-24h"} {
+		t.Run("Retention "+retention, func(t *testing.T) {
+			setenv(t, "PURGE_RETENTION", retention)
+
+			_, err := setPurgerConfig(logger)
+			assert.EqualError(t, err, "Error parsing PURGE_RETENTION variable, expected a positive duration", "Expecting a config error")
+		})
+	}
+
+	// ensures an interval the purger can't run on is rejected rather than disabling it
+	t.Run("Interval 0s", func(t *testing.T) {
+		setenv(t, "PURGE_INTERVAL", "0s")
+
+		_, err := setPurgerConfig(logger)
+		assert.EqualError(t, err, "Error parsing PURGE_INTERVAL variable, expected a positive duration", "Expecting a config error")
+	})
+}
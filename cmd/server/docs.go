@@ -0,0 +1,39 @@
+package main
+
+// This file serves the OpenAPI document for the REST gateway and the explorer built on it
+import (
+	// embed is imported for the go:embed directive below
+	_ "embed"
+	"net/http"
+
+	"github.com/caring/ford-mustang/pb"
+	"github.com/caring/go-packages/pkg/logging"
+	"sigs.k8s.io/yaml"
+)
+
+// docsPage is the API explorer, it is self contained so the docs work without reaching a CDN
+//
+//go:embed docs/index.html
+var docsPage []byte
+
+// registerDocs serves the OpenAPI document at /openapi.json and the explorer at /docs on mux.
+// the document is converted from the generated YAML once, up front
+func registerDocs(logger *logging.Logger, mux *http.ServeMux) error {
+	logger.Debug("Initializing API docs")
+	spec, err := yaml.YAMLToJSON(pb.OpenAPI)
+	if err != nil {
+		return err
+	}
+
+	mux.HandleFunc("/openapi.json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Write(spec)
+	})
+	mux.HandleFunc("/docs", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(docsPage)
+	})
+	logger.Debug("Done")
+	return nil
+}
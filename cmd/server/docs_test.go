@@ -0,0 +1,48 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegisterDocs(t *testing.T) {
+	mux := http.NewServeMux()
+	if ok := assert.NoError(t, registerDocs(newTestLogger(t), mux), "Expected no error"); !ok {
+		assert.FailNow(t, "test setup failed")
+	}
+
+	// ensures the embedded spec is served as JSON describing the gateway routes
+	t.Run("OpenAPI document", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+
+		assert.Equal(t, http.StatusOK, w.Code, "Expected 200 OK")
+		assert.Equal(t, "application/json", w.Header().Get("Content-Type"), "Expected a JSON document")
+		assert.Equal(t, "*", w.Header().Get("Access-Control-Allow-Origin"), "Expected the document to be readable cross origin")
+
+		var spec struct {
+			OpenAPI string                 `json:"openapi"`
+			Paths   map[string]interface{} `json:"paths"`
+		}
+		if ok := assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &spec), "Expected valid JSON"); !ok {
+			return
+		}
+		assert.Equal(t, "3.0.3", spec.OpenAPI, "Expected an OpenAPI v3 document")
+		assert.Contains(t, spec.Paths, "/v1/mustangs", "Expected the mustang routes")
+		assert.Contains(t, spec.Paths, "/v1/mustangs/{id}", "Expected the mustang routes")
+	})
+
+	// ensures the explorer page is served
+	t.Run("Explorer", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/docs", nil))
+
+		assert.Equal(t, http.StatusOK, w.Code, "Expected 200 OK")
+		assert.Equal(t, "text/html; charset=utf-8", w.Header().Get("Content-Type"), "Expected an HTML page")
+		assert.Contains(t, w.Body.String(), "/openapi.json", "Expected the page to load the document")
+	})
+}
@@ -0,0 +1,45 @@
+package main
+
+// This file contains the REST gateway that transcodes JSON over HTTP/1 into calls on the gRPC service
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/caring/ford-mustang/internal/handlers"
+	"github.com/caring/ford-mustang/pb"
+	"github.com/caring/go-packages/pkg/logging"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// newGateway builds the REST gateway. Calls are made over a connection to the gRPC server at
+// grpcAddr, so REST requests pass through the same interceptors as gRPC ones and their status
+// codes map onto HTTP statuses. Successful calls answer 200 OK as the OpenAPI document describes
+func newGateway(ctx context.Context, logger *logging.Logger, grpcAddr string) (http.Handler, error) {
+	logger.Debug("Initializing REST gateway")
+	mux := runtime.NewServeMux(
+		runtime.WithIncomingHeaderMatcher(gatewayHeaderMatcher),
+	)
+
+	err := pb.RegisterFordMustangServiceHandlerFromEndpoint(ctx, mux, grpcAddr, []grpc.DialOption{
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	})
+	if err != nil {
+		return nil, err
+	}
+	logger.Debug("Done")
+	return mux, nil
+}
+
+// gatewayHeaderMatcher forwards headers the way the gateway does by default except for the actor.
+// REST callers are not authenticated so they can't name who is making a request, their changes are
+// recorded as db.UnknownActor. Idempotency keys are sent in the request body
+func gatewayHeaderMatcher(header string) (string, bool) {
+	key, ok := runtime.DefaultHeaderMatcher(header)
+	if ok && strings.EqualFold(key, handlers.ActorMetadataKey) {
+		return "", false
+	}
+	return key, ok
+}
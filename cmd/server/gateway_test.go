@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/caring/ford-mustang/internal/db"
+	"github.com/caring/ford-mustang/internal/db/memory"
+	"github.com/caring/ford-mustang/internal/handlers"
+	"github.com/caring/ford-mustang/pb"
+)
+
+// newTestGateway serves the service on the in memory store over gRPC and gives a gateway in front of
+// it. The metadata of the last gRPC call the gateway made is stored in md
+func newTestGateway(t *testing.T, md *metadata.MD) http.Handler {
+	logger := newTestLogger(t)
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if ok := assert.NoError(t, err, "Expected no error"); !ok {
+		assert.FailNow(t, "test setup failed")
+	}
+
+	recordMetadata := func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		*md, _ = metadata.FromIncomingContext(ctx)
+		return handler(ctx, req)
+	}
+	changes := db.NewBroadcaster(10, 10)
+	server := grpc.NewServer(grpc.ChainUnaryInterceptor(recordMetadata, handlers.UnaryErrorInterceptor(logger), handlers.UnaryActorInterceptor, handlers.UnaryReadPrimaryInterceptor))
+	pb.RegisterFordMustangServiceServer(server, &service{
+		logger:  logger,
+		backend: memory.NewStore(memory.WithBroadcaster(changes)),
+		changes: changes,
+	})
+	go server.Serve(lis)
+	t.Cleanup(server.Stop)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	gateway, err := newGateway(ctx, logger, lis.Addr().String())
+	if ok := assert.NoError(t, err, "Expected no error"); !ok {
+		assert.FailNow(t, "test setup failed")
+	}
+	return gateway
+}
+
+// serve sends a request through the gateway and returns the recorded response
+func serve(gateway http.Handler, method, target, body string, header http.Header) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	w := httptest.NewRecorder()
+	gateway.ServeHTTP(w, req)
+	return w
+}
+
+func TestGateway(t *testing.T) {
+	var md metadata.MD
+	gateway := newTestGateway(t, &md)
+
+	// ensures creates answer 200 OK with the new mustang, as the OpenAPI document describes
+	var created pb.MustangResponse
+	t.Run("Create", func(t *testing.T) {
+		w := serve(gateway, http.MethodPost, "/v1/mustangs", `{"name": "Foobar"}`, nil)
+		assert.Equal(t, http.StatusOK, w.Code, "Expected 200 OK")
+
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &created), "Expected a JSON mustang")
+		assert.Equal(t, "Foobar", created.Name, "Expected the created mustang")
+	})
+
+	// ensures the created mustang can be read back
+	t.Run("Get", func(t *testing.T) {
+		w := serve(gateway, http.MethodGet, "/v1/mustangs/"+created.Id, "", nil)
+		assert.Equal(t, http.StatusOK, w.Code, "Expected 200 OK")
+	})
+
+	// ensures the gRPC codes the store errors map to become the matching HTTP statuses
+	t.Run("Error statuses", func(t *testing.T) {
+		cases := []struct {
+			name   string
+			method string
+			target string
+			body   string
+			status int
+		}{
+			{"Not found", http.MethodGet, "/v1/mustangs/" + uuid.New().String(), "", http.StatusNotFound},
+			{"Version mismatch", http.MethodDelete, "/v1/mustangs/" + created.Id + "?version=5", "", http.StatusConflict},
+			{"Invalid ID", http.MethodGet, "/v1/mustangs/not-a-uuid", "", http.StatusBadRequest},
+			{"Empty name", http.MethodPost, "/v1/mustangs", `{"name": ""}`, http.StatusBadRequest},
+			{"Name too long", http.MethodPost, "/v1/mustangs", `{"name": "` + strings.Repeat("F", db.MaxNameLength+1) + `"}`, http.StatusBadRequest},
+		}
+
+		for _, c := range cases {
+			c := c
+			t.Run(c.name, func(t *testing.T) {
+				w := serve(gateway, c.method, c.target, c.body, nil)
+				assert.Equal(t, c.status, w.Code, "Expected the mapped status")
+			})
+		}
+	})
+
+	// ensures a retry with the idempotency key in the body returns the first mustang
+	t.Run("Idempotency key", func(t *testing.T) {
+		var first, retried pb.MustangResponse
+		w := serve(gateway, http.MethodPost, "/v1/mustangs", `{"name": "Bazqux", "idempotencyKey": "retry-1"}`, nil)
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &first), "Expected a JSON mustang")
+		w = serve(gateway, http.MethodPost, "/v1/mustangs", `{"name": "Bazqux", "idempotencyKey": "retry-1"}`, nil)
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &retried), "Expected a JSON mustang")
+
+		assert.Equal(t, first.Id, retried.Id, "Expected the retry to return the first mustang")
+	})
+
+	// ensures REST callers can't name the actor recorded in the audit history
+	t.Run("Actor header", func(t *testing.T) {
+		header := http.Header{}
+		header.Set("X-Actor", "support@caring.com")
+		header.Set("Grpc-Metadata-X-Actor", "support@caring.com")
+
+		w := serve(gateway, http.MethodPost, "/v1/mustangs", `{"name": "Bazqux"}`, header)
+		assert.Equal(t, http.StatusOK, w.Code, "Expected 200 OK")
+
+		assert.Empty(t, md.Get(handlers.ActorMetadataKey), "Expected the actor to be dropped")
+	})
+
+	// ensures REST callers can ask for their reads to go to the primary
+	t.Run("Read primary header", func(t *testing.T) {
+		header := http.Header{}
+		header.Set("Grpc-Metadata-X-Read-Primary", "true")
+
+		w := serve(gateway, http.MethodGet, "/v1/mustangs/"+created.Id, "", header)
+		assert.Equal(t, http.StatusOK, w.Code, "Expected 200 OK")
+
+		assert.Equal(t, []string{"true"}, md.Get(handlers.ReadPrimaryMetadataKey), "Expected the header to be forwarded")
+	})
+}
@@ -1,29 +1,547 @@
 package main
 
 import (
-  _ "context"
+	"context"
+	"time"
 
-  _ "github.com/caring/ford-mustang/internal/db"
-
-  _ "github.com/caring/ford-mustang/internal/handlers"
-  "github.com/caring/ford-mustang/pb"
-  _ "github.com/caring/go-packages/pkg/errors"
-  _ "google.golang.org/grpc/codes"
+	"github.com/caring/ford-mustang/internal/db"
+	"github.com/caring/ford-mustang/pb"
+	"github.com/caring/go-packages/pkg/logging"
+	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 )
 
 type service struct {
+	logger *logging.Logger
+	// backend stores mustangs, it is the SQL store or the in memory store
+	backend db.Backend
+	// store backs categories and products, it is nil when running in memory
+	store *db.Store
+	// changes broadcasts the mustang changes committed by the backend
+	changes *db.Broadcaster
 }
 
+// errNoSQLStore is returned by the RPCs the in memory backend does not implement
+var errNoSQLStore = status.Error(codes.Unimplemented, "categories, products and mustang history require a SQL storage backend")
+
 func (s *service) Ping(ctx context.Context, in *pb.PingRequest) (*pb.PingResponse, error) {
-    l.Printf("Received: %v", in.Data)
-    resp := "Data: " + in.Data
-    
-    ctx, cancel := context.WithTimeout(ctx, 1*time.Second)
-    defer cancel()
-    status := "up"
-    if err := store.Ping(ctx); err != nil {
-      status = "down"
-    }
-    return &pb.PingResponse{Data: resp + "; Database: " + status}, nil
-    
-}
\ No newline at end of file
+	s.logger.Printf("Received: %v", in.Data)
+	resp := "Data: " + in.Data
+
+	ctx, cancel := context.WithTimeout(ctx, 1*time.Second)
+	defer cancel()
+	dbStatus := "up"
+	if err := s.backend.Ping(ctx); err != nil {
+		dbStatus = "down"
+	}
+	return &pb.PingResponse{Data: resp + "; Database: " + dbStatus}, nil
+
+}
+
+// parseID parses the ID of the record a request names. db.ParseUUID reads an empty ID as the nil
+// UUID, a request naming no record is invalid rather than not found
+func parseID(ID string) (uuid.UUID, error) {
+	if ID == "" {
+		return uuid.Nil, db.ErrInvalidID
+	}
+	return db.ParseUUID(ID)
+}
+
+// contains reports whether paths holds path
+func contains(paths []string, path string) bool {
+	for _, p := range paths {
+		if p == path {
+			return true
+		}
+	}
+	return false
+}
+
+// idempotencyKeyMetadata is the request metadata key an idempotency key may be sent in
+const idempotencyKeyMetadata = "idempotency-key"
+
+// CreateMustang creates a new mustang with a generated ID. When the request carries an idempotency
+// key a retry by the same actor returns the mustang created the first time
+func (s *service) CreateMustang(ctx context.Context, in *pb.CreateMustangRequest) (*pb.MustangResponse, error) {
+	if err := db.ValidateName(in.GetName()); err != nil {
+		return nil, err
+	}
+
+	m, err := db.NewMustang(uuid.New().String(), in)
+	if err != nil {
+		return nil, err
+	}
+
+	if key := idempotencyKey(ctx, in); key != "" {
+		if m, err = s.backend.Mustangs().CreateIdempotent(ctx, key, m); err != nil {
+			return nil, err
+		}
+		return m.ToProto(), nil
+	}
+
+	if err = s.backend.Mustangs().Create(ctx, m); err != nil {
+		return nil, err
+	}
+
+	return m.ToProto(), nil
+}
+
+// idempotencyKey returns the idempotency key from the request, or from the metadata when the request has none
+func idempotencyKey(ctx context.Context, in *pb.CreateMustangRequest) string {
+	if key := in.GetIdempotencyKey(); key != "" {
+		return key
+	}
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+	if keys := md.Get(idempotencyKeyMetadata); len(keys) > 0 {
+		return keys[0]
+	}
+	return ""
+}
+
+// UpdateMustang updates the fields of an existing mustang named by the update mask, or every field
+// when no mask is given, and returns it with its new version
+func (s *service) UpdateMustang(ctx context.Context, in *pb.UpdateMustangRequest) (*pb.MustangResponse, error) {
+	if _, err := parseID(in.GetId()); err != nil {
+		return nil, err
+	}
+	if paths := in.GetUpdateMask().GetPaths(); len(paths) == 0 || contains(paths, "name") {
+		if err := db.ValidateName(in.GetName()); err != nil {
+			return nil, err
+		}
+	}
+
+	input, err := db.NewMustang(in.GetId(), in)
+	if err != nil {
+		return nil, err
+	}
+
+	var m *db.Mustang
+	err = s.backend.WithTx(ctx, nil, func(ctx context.Context) error {
+		if err := s.backend.Mustangs().Patch(ctx, input, in.GetUpdateMask().GetPaths()); err != nil {
+			return err
+		}
+
+		m, err = s.backend.Mustangs().Get(ctx, input.ID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return m.ToProto(), nil
+}
+
+// DeleteMustang soft deletes a mustang by ID and returns the deleted record
+func (s *service) DeleteMustang(ctx context.Context, in *pb.DeleteMustangRequest) (*pb.MustangResponse, error) {
+	ID, err := parseID(in.GetId())
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := s.backend.Mustangs().Delete(ctx, ID, in.GetVersion())
+	if err != nil {
+		return nil, err
+	}
+
+	return m.ToProto(), nil
+}
+
+// GetMustang fetches a single mustang by ID
+func (s *service) GetMustang(ctx context.Context, in *pb.ByIDRequest) (*pb.MustangResponse, error) {
+	ID, err := parseID(in.GetId())
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := s.backend.Mustangs().Get(ctx, ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return m.ToProto(), nil
+}
+
+// RestoreMustang restores a soft deleted mustang by ID and returns the restored record
+func (s *service) RestoreMustang(ctx context.Context, in *pb.ByIDRequest) (*pb.MustangResponse, error) {
+	ID, err := parseID(in.GetId())
+	if err != nil {
+		return nil, err
+	}
+
+	var m *db.Mustang
+	err = s.backend.WithTx(ctx, nil, func(ctx context.Context) error {
+		if err := s.backend.Mustangs().Restore(ctx, ID); err != nil {
+			return err
+		}
+
+		m, err = s.backend.Mustangs().Get(ctx, ID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return m.ToProto(), nil
+}
+
+// ListMustangs fetches a page of mustangs, the next page token is empty when there are no more pages
+func (s *service) ListMustangs(ctx context.Context, in *pb.ListMustangsRequest) (*pb.ListMustangsResponse, error) {
+	after, err := db.DecodeCursor(in.GetPageToken())
+	if err != nil {
+		return nil, err
+	}
+
+	mustangs, next, err := s.backend.Mustangs().List(ctx, db.PageSize(in.GetPageSize()), after)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &pb.ListMustangsResponse{
+		Mustangs:      make([]*pb.MustangResponse, 0, len(mustangs)),
+		NextPageToken: next.Encode(),
+	}
+	for _, m := range mustangs {
+		resp.Mustangs = append(resp.Mustangs, m.ToProto())
+	}
+
+	return resp, nil
+}
+
+// BatchGetMustangs fetches the mustangs for a set of keys in a single query. Found mustangs
+// are returned in the order of their keys and keys without a mustang are returned as missing
+func (s *service) BatchGetMustangs(ctx context.Context, in *pb.LoadKeyRequest) (*pb.BatchMustangsResponse, error) {
+	IDs := make([]uuid.UUID, 0, len(in.GetKeys()))
+	keys := make(map[uuid.UUID]string, len(in.GetKeys()))
+	for _, key := range in.GetKeys() {
+		ID, err := parseID(key)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := keys[ID]; !ok {
+			keys[ID] = key
+			IDs = append(IDs, ID)
+		}
+	}
+
+	mustangs, err := s.backend.Mustangs().BatchGet(ctx, IDs)
+	if err != nil {
+		return nil, err
+	}
+
+	found := make(map[uuid.UUID]*db.Mustang, len(mustangs))
+	for _, m := range mustangs {
+		found[m.ID] = m
+	}
+
+	resp := &pb.BatchMustangsResponse{
+		Mustangs:    make([]*pb.MustangResponse, 0, len(mustangs)),
+		MissingKeys: []string{},
+	}
+	for _, ID := range IDs {
+		if m, ok := found[ID]; ok {
+			resp.Mustangs = append(resp.Mustangs, m.ToProto())
+		} else {
+			resp.MissingKeys = append(resp.MissingKeys, keys[ID])
+		}
+	}
+
+	return resp, nil
+}
+
+// WatchMustangs streams mustang changes as they are committed until the client goes away. A resume
+// token from a previous response sends the changes made since first, so a client that reconnects
+// with the last token it received misses nothing
+func (s *service) WatchMustangs(in *pb.WatchMustangsRequest, stream pb.FordMustangService_WatchMustangsServer) error {
+	sub, err := s.changes.Subscribe(in.GetResumeToken())
+	if err != nil {
+		return err
+	}
+	defer sub.Close()
+
+	ctx := stream.Context()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case c, ok := <-sub.Changes():
+			if !ok {
+				return sub.Err()
+			}
+			if err := stream.Send(c.ToProto()); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// GetMustangHistory fetches a page of the audit history of a mustang oldest first, the next page
+// token is empty when there are no more pages. The history of a purged mustang is purged with it
+func (s *service) GetMustangHistory(ctx context.Context, in *pb.GetMustangHistoryRequest) (*pb.MustangHistoryResponse, error) {
+	if s.store == nil {
+		return nil, errNoSQLStore
+	}
+
+	ID, err := parseID(in.GetId())
+	if err != nil {
+		return nil, err
+	}
+
+	after, err := db.DecodeHistoryToken(in.GetPageToken())
+	if err != nil {
+		return nil, err
+	}
+
+	entries, next, err := s.store.Mustang.History(ctx, ID, db.PageSize(in.GetPageSize()), after)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &pb.MustangHistoryResponse{
+		Entries:       make([]*pb.MustangHistoryEntry, 0, len(entries)),
+		NextPageToken: db.EncodeHistoryToken(next),
+	}
+	for _, h := range entries {
+		resp.Entries = append(resp.Entries, h.ToProto())
+	}
+
+	return resp, nil
+}
+
+// CreateCategory creates a new category with a generated ID
+func (s *service) CreateCategory(ctx context.Context, in *pb.CreateCategoryRequest) (*pb.CategoryResponse, error) {
+	if s.store == nil {
+		return nil, errNoSQLStore
+	}
+
+	if err := db.ValidateName(in.GetName()); err != nil {
+		return nil, err
+	}
+
+	c, err := db.NewCategory(uuid.New().String(), in)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = s.store.Category.Create(ctx, c); err != nil {
+		return nil, err
+	}
+
+	return c.ToProto(), nil
+}
+
+// UpdateCategory updates an existing category by ID and returns it as stored
+func (s *service) UpdateCategory(ctx context.Context, in *pb.UpdateCategoryRequest) (*pb.CategoryResponse, error) {
+	if s.store == nil {
+		return nil, errNoSQLStore
+	}
+
+	if _, err := parseID(in.GetId()); err != nil {
+		return nil, err
+	}
+	if err := db.ValidateName(in.GetName()); err != nil {
+		return nil, err
+	}
+
+	input, err := db.NewCategory(in.GetId(), in)
+	if err != nil {
+		return nil, err
+	}
+
+	var c *db.Category
+	err = s.store.WithTx(ctx, nil, func(ctx context.Context) error {
+		if err := s.store.Category.Update(ctx, input); err != nil {
+			return err
+		}
+
+		c, err = s.store.Category.Get(ctx, input.ID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return c.ToProto(), nil
+}
+
+// DeleteCategory soft deletes a category by ID and returns the deleted record
+func (s *service) DeleteCategory(ctx context.Context, in *pb.ByIDRequest) (*pb.CategoryResponse, error) {
+	if s.store == nil {
+		return nil, errNoSQLStore
+	}
+
+	ID, err := parseID(in.GetId())
+	if err != nil {
+		return nil, err
+	}
+
+	var c *db.Category
+	err = s.store.WithTx(ctx, nil, func(ctx context.Context) error {
+		c, err = s.store.Category.Delete(ctx, ID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return c.ToProto(), nil
+}
+
+// GetCategory fetches a single category by ID
+func (s *service) GetCategory(ctx context.Context, in *pb.ByIDRequest) (*pb.CategoryResponse, error) {
+	if s.store == nil {
+		return nil, errNoSQLStore
+	}
+
+	ID, err := parseID(in.GetId())
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := s.store.Category.Get(ctx, ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.ToProto(), nil
+}
+
+// CreateProduct creates a new product with a generated ID
+func (s *service) CreateProduct(ctx context.Context, in *pb.CreateProductRequest) (*pb.ProductResponse, error) {
+	if s.store == nil {
+		return nil, errNoSQLStore
+	}
+
+	if _, err := parseID(in.GetCategoryId()); err != nil {
+		return nil, err
+	}
+	if err := db.ValidateName(in.GetName()); err != nil {
+		return nil, err
+	}
+
+	p, err := db.NewProduct(uuid.New().String(), in)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = s.store.Product.Create(ctx, p); err != nil {
+		return nil, err
+	}
+
+	return p.ToProto(), nil
+}
+
+// UpdateProduct updates an existing product by ID and returns it as stored
+func (s *service) UpdateProduct(ctx context.Context, in *pb.UpdateProductRequest) (*pb.ProductResponse, error) {
+	if s.store == nil {
+		return nil, errNoSQLStore
+	}
+
+	if _, err := parseID(in.GetId()); err != nil {
+		return nil, err
+	}
+	if _, err := parseID(in.GetCategoryId()); err != nil {
+		return nil, err
+	}
+	if err := db.ValidateName(in.GetName()); err != nil {
+		return nil, err
+	}
+
+	input, err := db.NewProduct(in.GetId(), in)
+	if err != nil {
+		return nil, err
+	}
+
+	var p *db.Product
+	err = s.store.WithTx(ctx, nil, func(ctx context.Context) error {
+		if err := s.store.Product.Update(ctx, input); err != nil {
+			return err
+		}
+
+		p, err = s.store.Product.Get(ctx, input.ID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return p.ToProto(), nil
+}
+
+// DeleteProduct soft deletes a product by ID and returns the deleted record
+func (s *service) DeleteProduct(ctx context.Context, in *pb.ByIDRequest) (*pb.ProductResponse, error) {
+	if s.store == nil {
+		return nil, errNoSQLStore
+	}
+
+	ID, err := parseID(in.GetId())
+	if err != nil {
+		return nil, err
+	}
+
+	var p *db.Product
+	err = s.store.WithTx(ctx, nil, func(ctx context.Context) error {
+		p, err = s.store.Product.Delete(ctx, ID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return p.ToProto(), nil
+}
+
+// GetProduct fetches a single product by ID
+func (s *service) GetProduct(ctx context.Context, in *pb.ByIDRequest) (*pb.ProductResponse, error) {
+	if s.store == nil {
+		return nil, errNoSQLStore
+	}
+
+	ID, err := parseID(in.GetId())
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := s.store.Product.Get(ctx, ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return p.ToProto(), nil
+}
+
+// ListProductsByCategory fetches a page of the products in a category
+func (s *service) ListProductsByCategory(ctx context.Context, in *pb.ListProductsByCategoryRequest) (*pb.ListProductsResponse, error) {
+	if s.store == nil {
+		return nil, errNoSQLStore
+	}
+
+	categoryID, err := parseID(in.GetCategoryId())
+	if err != nil {
+		return nil, err
+	}
+
+	after, err := db.DecodeCursor(in.GetPageToken())
+	if err != nil {
+		return nil, err
+	}
+
+	products, next, err := s.store.Product.ListByCategory(ctx, categoryID, db.PageSize(in.GetPageSize()), after)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &pb.ListProductsResponse{
+		Products:      make([]*pb.ProductResponse, 0, len(products)),
+		NextPageToken: next.Encode(),
+	}
+	for _, p := range products {
+		resp.Products = append(resp.Products, p.ToProto())
+	}
+
+	return resp, nil
+}
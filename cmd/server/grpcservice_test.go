@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/caring/ford-mustang/internal/db"
+	"github.com/caring/ford-mustang/internal/db/memory"
+	"github.com/caring/ford-mustang/pb"
+)
+
+func TestService_validation(t *testing.T) {
+	ctx := context.Background()
+	s := &service{logger: newTestLogger(t), backend: memory.NewStore()}
+
+	created, err := s.CreateMustang(ctx, &pb.CreateMustangRequest{Name: "Foobar"})
+	if ok := assert.NoError(t, err, "Expected no error"); !ok {
+		assert.FailNow(t, "test setup failed")
+	}
+
+	// ensures a request naming no mustang is invalid rather than not found
+	t.Run("Empty ID", func(t *testing.T) {
+		_, err := s.GetMustang(ctx, &pb.ByIDRequest{})
+		assert.ErrorIs(t, err, db.ErrInvalidID, "Expected an invalid id error")
+
+		_, err = s.UpdateMustang(ctx, &pb.UpdateMustangRequest{Name: "Bazqux"})
+		assert.ErrorIs(t, err, db.ErrInvalidID, "Expected an invalid id error")
+	})
+
+	// ensures names the store can't hold are rejected before they reach it
+	t.Run("Invalid name", func(t *testing.T) {
+		for _, name := range []string{"", strings.Repeat("F", db.MaxNameLength+1)} {
+			_, err := s.CreateMustang(ctx, &pb.CreateMustangRequest{Name: name})
+			assert.ErrorIs(t, err, db.ErrInvalidName, "Expected an invalid name error")
+
+			_, err = s.UpdateMustang(ctx, &pb.UpdateMustangRequest{Id: created.Id, Name: name})
+			assert.ErrorIs(t, err, db.ErrInvalidName, "Expected an invalid name error")
+		}
+	})
+}
@@ -1,104 +1,105 @@
 package main
 
 import (
+	"context"
 	"errors"
-	"net"
-	"net/http"
+	"log"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
-	"log"
-
 
 	"github.com/caring/ford-mustang/internal/db"
 
-	"github.com/caring/ford-mustang/pb"
 	"github.com/caring/go-packages/pkg/logging"
-	"github.com/caring/go-packages/pkg/tracing"
 	"github.com/getsentry/sentry-go"
-
-	"github.com/soheilhy/cmux"
-	"google.golang.org/grpc"
-)
-
-
-var (
-	store        *db.Store
-	dbConnection string
-)
-
-
-var (
-	l *logging.Logger
-	t *tracing.Tracer
-)
-
-var (
-	g *grpc.Server
 )
 
-func init() {
-	l = initLogger()
+func main() {
+	l := initLogger()
 	initSentry(l)
+	changes := initBroadcaster(l)
 
-	dbConnection = setDBConnectionString(l)
-	migrateDatabase(l, dbConnection)
-	store = initStore(l, dbConnection)
+	var (
+		backend db.Backend
+		opts    []AppOption
+	)
+	switch envDefault("STORAGE_BACKEND", "mysql") {
+	case "memory":
+		backend = initMemoryStore(l, changes)
+	case "mysql":
+		dbConnection := setDBConnectionString(l)
+		dbReplicas := setDBReplicaConnectionStrings(l)
+		migrateMySQL(l, dbConnection)
+		store := initStore(l, dbConnection, dbReplicas, changes)
+		backend = store
+		opts = append(opts, sqlStoreOptions(l, store)...)
+	case "sqlite":
+		dbConnection := setSQLiteConnectionString(l)
+		migrateSQLite(l, dbConnection)
+		store := initStore(l, dbConnection, nil, changes)
+		backend = store
+		opts = append(opts, sqlStoreOptions(l, store)...)
+	default:
+		l.Fatal("Error parsing STORAGE_BACKEND variable, expected mysql, sqlite or memory")
+	}
+	opts = append(opts,
+		WithHealthConfig(setHealthConfig(l)),
+		WithShutdownConfig(setShutdownConfig(l)),
+	)
 
-	t = initTracing(l)
-	g = createGRPCServer(l, t)
-}
+	t := initTracing(l)
 
-func main() {
-	defer sentry.Flush(5 * time.Second)
-	defer t.Close()
-	defer l.Sync()
+	// deferred calls run last to first, telemetry is flushed once everything else has stopped
 	defer l.Close()
+	defer l.Sync()
+	defer t.Close()
+	defer sentry.Flush(5 * time.Second)
 
-	// main listener
-	lis, err := net.Listen("tcp", ":"+envMust("PORT"))
-	if err != nil {
+	// a SIGTERM or an interrupt begins a graceful shutdown
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
+	app := NewApp(l, createGRPCServer(l, t), backend, changes, opts...)
+	if err := app.Start(":" + envMust("PORT")); err != nil {
 		sentry.CaptureException(err)
-		l.Fatal("Failed to initialize net listener:" + err.Error())
+		l.Fatal("Failed to start server:" + err.Error())
 	}
 
-	// create a cmux
-	m := cmux.New(lis)
-	// match connections in order:
-	// first grpc, then http.
-	grpcL := m.Match(cmux.HTTP2())
-	httpL := m.Match(cmux.HTTP1Fast())
-
-	// register the server with gRPC
-	pb.RegisterFordMustangServer(g, &service{})
-
-	// Add a health check endpoint for automated container monitoring
-	http.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-	})
-
-	// make an error channel to collect the exits of each protocol's Serve()
-	eChan := make(chan error)
-
-	// start listeners for each protocol
-	go func() { eChan <- g.Serve(grpcL) }()
-	go func() { eChan <- http.Serve(httpL, nil) }()
-
-	// all systems are a go
-	l.Info("server started: multiplexed http/1, http/2",
-		logging.String("port", envMust("PORT")),
-		logging.String("multiplexed", "true"),
-	)
-
-	// serve it up
-	go func() { eChan <- m.Serve() }()
-
-	for err := range eChan {
-		if err != nil {
-			sentry.CaptureException(err)
-			l.Error("Error from one of the HTTP protocols:" + err.Error())
+	for serving := true; serving; {
+		select {
+		case <-ctx.Done():
+			serving = false
+		case err := <-app.Errors():
+			if err != nil {
+				sentry.CaptureException(err)
+				l.Error("Error from one of the HTTP protocols:" + err.Error())
+			}
 		}
 	}
 
+	// a second signal kills the process rather than waiting on the shutdown,
+	// errors stopping are logged by Stop
+	stop()
+	app.Stop()
+}
+
+// the options running the app on a SQL store, the store backs categories and products
+// and is purged and relayed from in the background
+func sqlStoreOptions(l *logging.Logger, store *db.Store) []AppOption {
+	purgerCfg, err := setPurgerConfig(l)
+	if err != nil {
+		l.Fatal(err.Error())
+	}
+	relayCfg, err := setRelayConfig(l)
+	if err != nil {
+		l.Fatal(err.Error())
+	}
+	return []AppOption{
+		WithSQLStore(store),
+		WithPurger(purgerCfg),
+		WithOutboxRelay(initPublisher(l), relayCfg),
+	}
 }
 
 // fetches and returns the given env variable, fatals and
@@ -108,11 +109,16 @@ func envMust(varName string) string {
 	if value == "" {
 		e := errors.New("environment variable missing - " + varName)
 		sentry.CaptureException(e)
-		if l != nil {
-			l.Fatal(e.Error())
-		} else {
-			log.Fatalln(e.Error())
-		}
+		log.Fatalln(e.Error())
 	}
 	return value
 }
+
+// fetches and returns the given env variable, falling back
+// to the default value if the variable is an empty string
+func envDefault(varName, defaultValue string) string {
+	if value := os.Getenv(varName); value != "" {
+		return value
+	}
+	return defaultValue
+}
@@ -5,7 +5,9 @@ import (
 	"fmt"
 	"log"
 	"strconv"
+	"strings"
 
+	"github.com/caring/ford-mustang/internal/handlers"
 	"github.com/caring/go-packages/pkg/grpc_middleware"
 	"github.com/caring/go-packages/pkg/logging"
 	"github.com/caring/go-packages/pkg/tracing"
@@ -14,6 +16,7 @@ import (
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/golang-migrate/migrate/v4"
 	_ "github.com/golang-migrate/migrate/v4/database/mysql"
+	_ "github.com/golang-migrate/migrate/v4/database/sqlite"
 	_ "github.com/golang-migrate/migrate/v4/source/file"
 	_ "github.com/golang-migrate/migrate/v4/source/github"
 
@@ -72,7 +75,8 @@ func initTracing(logger *logging.Logger) *tracing.Tracer {
 	return tracer
 }
 
-// create protocol server with chained interceptors
+// create protocol server with chained interceptors, store errors are translated
+// to grpc status codes innermost so that logging and tracing see the final code
 func createGRPCServer(logger *logging.Logger, tracer *tracing.Tracer) *grpc.Server {
 	return grpc.NewServer(
 		grpc_middleware.NewGRPCChainedUnaryInterceptor(grpc_middleware.UnaryOptions{
@@ -83,11 +87,13 @@ func createGRPCServer(logger *logging.Logger, tracer *tracing.Tracer) *grpc.Serv
 			Logger: logger,
 			Tracer: tracer,
 		}),
+		grpc.ChainUnaryInterceptor(handlers.UnaryErrorInterceptor(logger), handlers.UnaryActorInterceptor, handlers.UnaryReadPrimaryInterceptor),
+		grpc.ChainStreamInterceptor(handlers.StreamErrorInterceptor(logger), handlers.StreamActorInterceptor, handlers.StreamReadPrimaryInterceptor),
 	)
 }
 
 
-// create the db connection string from env
+// create the db connection string from env, parseTime lets DATETIME columns scan into time.Time
 func setDBConnectionString(logger *logging.Logger) string {
 	logger.Debug("Creating DB connection string")
 	user := envMust("DB_USER")
@@ -96,17 +102,60 @@ func setDBConnectionString(logger *logging.Logger) string {
 	port := envMust("DB_PORT")
 	schema := envMust("DB_SCHEMA")
 	logger.Debug("Done")
-	return user + ":" + pwd + "@tcp(" + host + ":" + port + ")/" + schema
+	return user + ":" + pwd + "@tcp(" + host + ":" + port + ")/" + schema + "?parseTime=true"
 }
 
-// perform the database migration from env config
-func migrateDatabase(logger *logging.Logger, connectionString string) {
+// create a connection string per read replica from env, replicas share the primary's credentials and schema.
+// DB_REPLICA_HOSTS is an optional comma separated list of host:port pairs
+func setDBReplicaConnectionStrings(logger *logging.Logger) []string {
+	logger.Debug("Creating DB replica connection strings")
+	hosts := envDefault("DB_REPLICA_HOSTS", "")
+	if hosts == "" {
+		logger.Debug("No replicas configured")
+		return nil
+	}
+	user := envMust("DB_USER")
+	pwd := envMust("DB_PWD")
+	schema := envMust("DB_SCHEMA")
+
+	var replicas []string
+	for _, host := range strings.Split(hosts, ",") {
+		host = strings.TrimSpace(host)
+		if host == "" {
+			continue
+		}
+		replicas = append(replicas, user+":"+pwd+"@tcp("+host+")/"+schema+"?parseTime=true")
+	}
+	logger.Debug("Done")
+	return replicas
+}
+
+// create the SQLite connection string from env, DB_SQLITE_PATH is the database file and
+// is created when it does not exist
+func setSQLiteConnectionString(logger *logging.Logger) string {
+	logger.Debug("Creating SQLite connection string")
+	path := envDefault("DB_SQLITE_PATH", "ford-mustang.db")
+	logger.Debug("Done")
+	return "sqlite://" + path
+}
+
+// perform the MySQL database migration from env config
+func migrateMySQL(logger *logging.Logger, connectionString string) {
+	migrateDatabase(logger, envMust("DB_MIGRATIONS_SRC"), "mysql://"+connectionString)
+}
+
+// perform the SQLite database migration from env config. SQLite has its own migrations, the MySQL ones
+// use syntax it doesn't support. the default source is where the Docker image copies them, set
+// DB_SQLITE_MIGRATIONS_SRC=file://internal/db/migrations/sqlite to run from a checkout
+func migrateSQLite(logger *logging.Logger, connectionString string) {
+	migrateDatabase(logger, envDefault("DB_SQLITE_MIGRATIONS_SRC", "file://migrations/sqlite"), connectionString)
+}
+
+// perform the database migration from source against the database at databaseURL
+func migrateDatabase(logger *logging.Logger, source, databaseURL string) {
 	logger.Info("Connecting to DB")
 
-	m, err := migrate.New(
-		envMust("DB_MIGRATIONS_SRC"),
-		"mysql://"+connectionString,
-	)
+	m, err := migrate.New(source, databaseURL)
 	if err != nil {
 		sentry.CaptureException(err)
 		logger.Fatal("Failure running migrations to update database:" + err.Error())
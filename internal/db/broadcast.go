@@ -0,0 +1,265 @@
+package db
+
+import (
+	"context"
+	"encoding/base64"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/caring/go-packages/pkg/errors"
+	"github.com/google/uuid"
+
+	"github.com/caring/ford-mustang/pb"
+)
+
+// Change is a committed change to a mustang as delivered to watchers
+type Change struct {
+	// EventType is one of the mustang event types written to the outbox
+	EventType string
+	// Mustang is the mustang as the change left it
+	Mustang Mustang
+	// ResumeToken resumes a watch from just after this change, it is set when the change is published
+	ResumeToken string
+
+	seq uint64
+}
+
+// changeTypes maps event types to the change types sent to watchers
+var changeTypes = map[string]pb.WatchMustangsResponse_ChangeType{
+	EventMustangCreated:  pb.WatchMustangsResponse_CREATED,
+	EventMustangUpdated:  pb.WatchMustangsResponse_UPDATED,
+	EventMustangDeleted:  pb.WatchMustangsResponse_DELETED,
+	EventMustangRestored: pb.WatchMustangsResponse_RESTORED,
+}
+
+// ToProto casts a change into a proto watch response
+func (c *Change) ToProto() *pb.WatchMustangsResponse {
+	return &pb.WatchMustangsResponse{
+		Type:        changeTypes[c.EventType],
+		Mustang:     c.Mustang.ToProto(),
+		ResumeToken: c.ResumeToken,
+	}
+}
+
+// Broadcaster fans committed mustang changes out to every watcher in the process. The most recent
+// changes are kept so a watcher that reconnects with a resume token is sent the changes it missed.
+// Resume tokens are only valid for the broadcaster that issued them, a token from before a restart
+// or from another instance is expired
+type Broadcaster struct {
+	mu sync.Mutex
+	// epoch identifies this broadcaster in its resume tokens
+	epoch string
+	// seq is the sequence number of the last change published
+	seq uint64
+	// history is a ring of the last len(history) changes, the change with seq n is at n % len(history)
+	history []Change
+	// buffer is how many changes a watcher may fall behind before it is dropped
+	buffer int
+	subs   map[*Subscription]struct{}
+	closed bool
+}
+
+// NewBroadcaster gives a broadcaster that keeps the last history changes for resuming watchers
+// and drops watchers that fall more than buffer changes behind
+func NewBroadcaster(history, buffer int) *Broadcaster {
+	return &Broadcaster{
+		epoch:   uuid.New().String(),
+		history: make([]Change, history),
+		buffer:  buffer,
+		subs:    map[*Subscription]struct{}{},
+	}
+}
+
+// Publish numbers the changes and sends them to every watcher, a watcher that can't keep up is
+// dropped rather than block the publisher. Publishing to a nil broadcaster is a no op
+func (b *Broadcaster) Publish(changes ...Change) {
+	if b == nil || len(changes) == 0 {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, c := range changes {
+		b.seq++
+		c.seq = b.seq
+		c.ResumeToken = b.resumeToken(c.seq)
+		if len(b.history) > 0 {
+			b.history[c.seq%uint64(len(b.history))] = c
+		}
+
+		for sub := range b.subs {
+			select {
+			case sub.changes <- c:
+			default:
+				b.drop(sub, ErrWatchTooSlow)
+			}
+		}
+	}
+}
+
+// Subscribe starts a watch. An empty resume token watches for changes published from now on,
+// otherwise the retained changes published after the token are sent first.
+// ErrResumeTokenExpired is returned when changes after the token are no longer retained
+func (b *Broadcaster) Subscribe(resumeToken string) (*Subscription, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return nil, ErrWatchClosed
+	}
+
+	var missed []Change
+	if resumeToken != "" {
+		after, err := b.parseResumeToken(resumeToken)
+		if err != nil {
+			return nil, err
+		}
+		for seq := after + 1; seq <= b.seq; seq++ {
+			missed = append(missed, b.history[seq%uint64(len(b.history))])
+		}
+	}
+
+	sub := &Subscription{
+		b:       b,
+		changes: make(chan Change, b.buffer+len(missed)),
+	}
+	for _, c := range missed {
+		sub.changes <- c
+	}
+	b.subs[sub] = struct{}{}
+
+	return sub, nil
+}
+
+// Close ends every watch with ErrWatchClosed and refuses new ones, so watchers can reconnect to
+// another instance while this one shuts down. Changes published after Close reach no one
+func (b *Broadcaster) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	for sub := range b.subs {
+		b.drop(sub, ErrWatchClosed)
+	}
+}
+
+// resumeToken encodes the position of the change with seq into an opaque token
+func (b *Broadcaster) resumeToken(seq uint64) string {
+	raw := b.epoch + "|" + strconv.FormatUint(seq, 10)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// parseResumeToken returns the seq a resume token points at, it must be held by a retained
+// change or be the last change published
+func (b *Broadcaster) parseResumeToken(token string) (uint64, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return 0, errors.Wrap(ErrInvalidResumeToken, err.Error())
+	}
+
+	parts := strings.SplitN(string(raw), "|", 2)
+	if len(parts) != 2 {
+		return 0, errors.Wrap(ErrInvalidResumeToken, "malformed resume token")
+	}
+
+	seq, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil {
+		return 0, errors.Wrap(ErrInvalidResumeToken, err.Error())
+	}
+
+	if parts[0] != b.epoch {
+		return 0, errors.Wrap(ErrResumeTokenExpired, "issued by another broadcaster")
+	}
+	if seq > b.seq {
+		return 0, errors.Wrap(ErrInvalidResumeToken, "resume token is ahead of the last change")
+	}
+	// every change after seq must still be in the ring
+	if b.seq-seq > uint64(len(b.history)) {
+		return 0, errors.Wrap(ErrResumeTokenExpired, "changes after the resume token are no longer retained")
+	}
+
+	return seq, nil
+}
+
+// drop ends sub with err, the caller must hold b.mu
+func (b *Broadcaster) drop(sub *Subscription, err error) {
+	if _, ok := b.subs[sub]; !ok {
+		return
+	}
+	delete(b.subs, sub)
+	sub.err = err
+	close(sub.changes)
+}
+
+// Subscription is a single watch on a broadcaster
+type Subscription struct {
+	b       *Broadcaster
+	changes chan Change
+	err     error
+}
+
+// Changes delivers the watched changes in the order they were published. It is closed when the
+// subscription ends, Err then reports why
+func (sub *Subscription) Changes() <-chan Change {
+	return sub.changes
+}
+
+// Err is ErrWatchTooSlow when the watcher fell too far behind and was dropped, ErrWatchClosed when the
+// broadcaster was closed, or nil when the subscription was closed. It is only meaningful once Changes is closed
+func (sub *Subscription) Err() error {
+	return sub.err
+}
+
+// Close ends the subscription
+func (sub *Subscription) Close() {
+	sub.b.mu.Lock()
+	defer sub.b.mu.Unlock()
+
+	sub.b.drop(sub, nil)
+}
+
+type changesCtxKey struct{}
+
+// pendingChanges are the changes made by a transaction, they are published once it commits
+type pendingChanges struct {
+	changes []Change
+}
+
+// withPendingChanges gives a ctx that collects the changes made by the transaction it carries
+func withPendingChanges(ctx context.Context) (context.Context, *pendingChanges) {
+	pending := &pendingChanges{}
+	return context.WithValue(ctx, changesCtxKey{}, pending), pending
+}
+
+// queueChange adds a change to the transaction in ctx to be published when it commits. changes made
+// in a tx that was not started by WithTx are never published, the store doesn't know when it commits
+func queueChange(ctx context.Context, c Change) {
+	if pending, ok := ctx.Value(changesCtxKey{}).(*pendingChanges); ok {
+		pending.changes = append(pending.changes, c)
+	}
+}
+
+// changesMark is how many changes the transaction in ctx has queued, truncateChanges
+// drops the changes queued after it when a savepoint rolls back
+func changesMark(ctx context.Context) int {
+	if pending, ok := ctx.Value(changesCtxKey{}).(*pendingChanges); ok {
+		return len(pending.changes)
+	}
+	return 0
+}
+
+// truncateChanges drops the changes queued after mark by the transaction in ctx
+func truncateChanges(ctx context.Context, mark int) {
+	if pending, ok := ctx.Value(changesCtxKey{}).(*pendingChanges); ok {
+		pending.changes = pending.changes[:mark]
+	}
+}
+
+// WithBroadcaster publishes every committed mustang change to b
+func WithBroadcaster(b *Broadcaster) Option {
+	return func(s *Store) {
+		s.changes = b
+	}
+}
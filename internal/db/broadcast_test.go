@@ -0,0 +1,182 @@
+package db
+
+import (
+	"context"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+// receive takes the changes waiting on sub without blocking
+func receive(sub *Subscription) []Change {
+	var changes []Change
+	for {
+		select {
+		case c, ok := <-sub.Changes():
+			if !ok {
+				return changes
+			}
+			changes = append(changes, c)
+		default:
+			return changes
+		}
+	}
+}
+
+func TestBroadcaster_Subscribe(t *testing.T) {
+	mustangID := uuid.MustParse("72bc87f3-4a9f-4d05-93fe-844d3cd94c65")
+	change := func(version int64) Change {
+		return Change{EventType: EventMustangUpdated, Mustang: Mustang{ID: mustangID, Name: "Foobar", Version: version}}
+	}
+
+	// ensures a new watch only sees changes published after it started
+	t.Run("Watches from now", func(t *testing.T) {
+		b := NewBroadcaster(10, 10)
+		b.Publish(change(1))
+
+		sub, err := b.Subscribe("")
+		assert.NoError(t, err, "Expecting no subscribe error")
+		b.Publish(change(2), change(3))
+
+		changes := receive(sub)
+		if assert.Len(t, changes, 2, "Expected the changes published after subscribing") {
+			assert.Equal(t, int64(2), changes[0].Mustang.Version, "Expected changes in publish order")
+			assert.Equal(t, int64(3), changes[1].Mustang.Version, "Expected changes in publish order")
+		}
+	})
+
+	// ensures a watch resumed from a token is sent the changes it missed first
+	t.Run("Resumes from token", func(t *testing.T) {
+		b := NewBroadcaster(10, 10)
+		sub, _ := b.Subscribe("")
+		b.Publish(change(1))
+		token := receive(sub)[0].ResumeToken
+		sub.Close()
+
+		b.Publish(change(2), change(3))
+
+		sub, err := b.Subscribe(token)
+		assert.NoError(t, err, "Expecting no subscribe error")
+		b.Publish(change(4))
+
+		var versions []int64
+		for _, c := range receive(sub) {
+			versions = append(versions, c.Mustang.Version)
+		}
+		assert.Equal(t, []int64{2, 3, 4}, versions, "Expected the missed changes then the live ones")
+	})
+
+	// ensures a token is rejected once the changes after it are no longer retained
+	t.Run("Expired token", func(t *testing.T) {
+		b := NewBroadcaster(2, 10)
+		sub, _ := b.Subscribe("")
+		b.Publish(change(1))
+		token := receive(sub)[0].ResumeToken
+
+		b.Publish(change(2), change(3), change(4))
+
+		_, err := b.Subscribe(token)
+		assert.ErrorIs(t, err, ErrResumeTokenExpired, "Expected the token to have expired")
+
+		_, err = NewBroadcaster(2, 10).Subscribe(token)
+		assert.ErrorIs(t, err, ErrResumeTokenExpired, "Expected a token from another broadcaster to be expired")
+	})
+
+	// ensures a malformed token is rejected
+	t.Run("Invalid token", func(t *testing.T) {
+		_, err := NewBroadcaster(2, 10).Subscribe("not a token")
+		assert.ErrorIs(t, err, ErrInvalidResumeToken, "Expected the token to be invalid")
+	})
+
+	// ensures a watcher that falls behind is dropped rather than block publishing
+	t.Run("Drops slow watchers", func(t *testing.T) {
+		b := NewBroadcaster(10, 1)
+		sub, _ := b.Subscribe("")
+		b.Publish(change(1), change(2))
+
+		changes := receive(sub)
+		assert.Len(t, changes, 1, "Expected the buffered change")
+		assert.ErrorIs(t, sub.Err(), ErrWatchTooSlow, "Expected the watch to be dropped")
+	})
+
+	// ensures closing the broadcaster ends every watch and refuses new ones
+	t.Run("Closed", func(t *testing.T) {
+		b := NewBroadcaster(10, 10)
+		sub, _ := b.Subscribe("")
+		b.Close()
+
+		_, ok := <-sub.Changes()
+		assert.False(t, ok, "Expected the watch to end")
+		assert.ErrorIs(t, sub.Err(), ErrWatchClosed, "Expected the watch to be closed")
+
+		_, err := b.Subscribe("")
+		assert.ErrorIs(t, err, ErrWatchClosed, "Expected new watches to be refused")
+	})
+}
+
+func TestStore_WithTx_broadcast(t *testing.T) {
+	mustangID := uuid.MustParse("72bc87f3-4a9f-4d05-93fe-844d3cd94c65")
+	stmt := withOutbox(map[string]string{
+		"delete-mustang": "UPDATE mustangs",
+	})
+
+	// ensures changes are published once the tx commits
+	t.Run("Publishes on commit", func(t *testing.T) {
+		b := NewBroadcaster(10, 10)
+		store, mock, err := NewTestDB(stmt, WithBroadcaster(b))
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "test setup failed")
+		}
+		sub, _ := b.Subscribe("")
+
+		mock.ExpectBegin()
+		expectMustangSnapshot(mock, Mustang{ID: mustangID, Name: "Foobar", Version: 1}, false)
+		mock.ExpectExec("UPDATE mustangs").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		expectMustangEvent(mock, EventMustangDeleted, Mustang{ID: mustangID, Name: "Foobar", Version: 2}, true)
+		mock.ExpectCommit()
+
+		_, err = store.Mustang.Delete(context.Background(), mustangID, 0)
+		assert.NoError(t, err, "Expecting no error")
+
+		changes := receive(sub)
+		if assert.Len(t, changes, 1, "Expected the committed change") {
+			assert.Equal(t, EventMustangDeleted, changes[0].EventType, "Expected a delete")
+			assert.Equal(t, int64(2), changes[0].Mustang.Version, "Expected the mustang as it was committed")
+		}
+
+		err = mock.ExpectationsWereMet()
+		assert.NoError(t, err, "Expecting all mock conditions to be met")
+	})
+
+	// ensures nothing is published when the tx rolls back
+	t.Run("Drops on rollback", func(t *testing.T) {
+		b := NewBroadcaster(10, 10)
+		store, mock, err := NewTestDB(stmt, WithBroadcaster(b))
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "test setup failed")
+		}
+		sub, _ := b.Subscribe("")
+
+		mock.ExpectBegin()
+		expectMustangSnapshot(mock, Mustang{ID: mustangID, Name: "Foobar", Version: 1}, false)
+		mock.ExpectExec("UPDATE mustangs").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		expectMustangEvent(mock, EventMustangDeleted, Mustang{ID: mustangID, Name: "Foobar", Version: 2}, true)
+		mock.ExpectRollback()
+
+		err = store.WithTx(context.Background(), nil, func(ctx context.Context) error {
+			if _, err := store.Mustang.Delete(ctx, mustangID, 0); err != nil {
+				return err
+			}
+			return assert.AnError
+		})
+		assert.ErrorIs(t, err, assert.AnError, "Expecting the error from fn")
+		assert.Empty(t, receive(sub), "Expected no changes to be published")
+
+		err = mock.ExpectationsWereMet()
+		assert.NoError(t, err, "Expecting all mock conditions to be met")
+	})
+}
@@ -0,0 +1,216 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/caring/go-packages/pkg/errors"
+	"github.com/google/uuid"
+
+	"github.com/caring/ford-mustang/pb"
+)
+
+// categoryService provides an API for interacting with the categories table
+type categoryService struct {
+	db       *sql.DB
+	stmts    map[string]*sql.Stmt
+	replicas *replicaSet
+}
+
+// Category is a struct representation of a row in the categories table
+type Category struct {
+	ID   uuid.UUID
+	Name string
+}
+
+// protoCategory is an interface that most proto category objects will satisfy
+type protoCategory interface {
+	GetName() string
+}
+
+// NewCategory is a convenience helper cast a proto category to it's DB layer struct
+func NewCategory(ID string, proto protoCategory) (*Category, error) {
+	cID, err := ParseUUID(ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Category{
+		ID:   cID,
+		Name: proto.GetName(),
+	}, nil
+}
+
+// ToProto casts a db category into a proto response object
+func (c *Category) ToProto() *pb.CategoryResponse {
+	return &pb.CategoryResponse{
+		Id:   c.ID.String(),
+		Name: c.Name,
+	}
+}
+
+// Get fetches a single category from the db
+func (svc *categoryService) Get(ctx context.Context, ID uuid.UUID) (*Category, error) {
+	return svc.get(ctx, false, ID)
+}
+
+// GetTx fetches a single category from the db inside of a tx from ctx
+func (svc *categoryService) GetTx(ctx context.Context, ID uuid.UUID) (*Category, error) {
+	return svc.get(ctx, true, ID)
+}
+
+// get fetches a single category from the db
+func (svc *categoryService) get(ctx context.Context, useTx bool, ID uuid.UUID) (*Category, error) {
+	errMsg := func() string { return "Error executing get category - " + fmt.Sprint(ID) }
+
+	stmt, err := svc.replicas.readStmt(ctx, useTx, "get-category", svc.stmts)
+	if err != nil {
+		return nil, err
+	}
+
+	c := Category{}
+
+	err = stmt.QueryRowContext(ctx, ID).
+		Scan(&c.ID, &c.Name)
+	if err != nil {
+
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, errors.Wrap(ErrNotFound, errMsg())
+		}
+
+		return nil, errors.Wrap(err, errMsg())
+	}
+
+	return &c, nil
+}
+
+// Create a new category
+func (svc *categoryService) Create(ctx context.Context, input *Category) error {
+	return svc.create(ctx, false, input)
+}
+
+// CreateTx creates a new category withing a tx from ctx
+func (svc *categoryService) CreateTx(ctx context.Context, input *Category) error {
+	return svc.create(ctx, true, input)
+}
+
+// create a new category. if useTx = true then it will attempt to create the category within a transaction
+// from context.
+func (svc *categoryService) create(ctx context.Context, useTx bool, input *Category) error {
+	errMsg := func() string { return "Error executing create category - " + fmt.Sprint(input) }
+
+	stmt, err := txStmt(ctx, useTx, svc.stmts["create-category"])
+	if err != nil {
+		return err
+	}
+
+	result, err := stmt.ExecContext(ctx, input.ID, input.Name)
+	if err != nil {
+		return errors.Wrap(translateDriverError(err), errMsg())
+	}
+
+	rowCount, err := result.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, errMsg())
+	}
+
+	if rowCount == 0 {
+		return errors.Wrap(ErrNotCreated, errMsg())
+	}
+
+	return nil
+}
+
+// Update updates a single category row in the DB
+func (svc *categoryService) Update(ctx context.Context, input *Category) error {
+	return svc.update(ctx, false, input)
+}
+
+// UpdateTx updates a single category row in the DB within a tx from ctx
+func (svc *categoryService) UpdateTx(ctx context.Context, input *Category) error {
+	return svc.update(ctx, true, input)
+}
+
+// update a category. if useTx = true then it will attempt to update the category within a transaction
+// from context. a missing or deleted category is ErrNotFound
+func (svc *categoryService) update(ctx context.Context, useTx bool, input *Category) error {
+	errMsg := func() string { return "Error executing update category - " + fmt.Sprint(input) }
+
+	stmt, err := txStmt(ctx, useTx, svc.stmts["update-category"])
+	if err != nil {
+		return err
+	}
+	getStmt, err := txStmt(ctx, useTx, svc.stmts["get-category"])
+	if err != nil {
+		return err
+	}
+
+	result, err := stmt.ExecContext(ctx, input.Name, input.ID)
+	if err != nil {
+		return errors.Wrap(translateDriverError(err), errMsg())
+	}
+
+	rowCount, err := result.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, errMsg())
+	}
+
+	if rowCount == 0 {
+		// MySQL counts the rows changed rather than matched, so an update that left the row as it
+		// was affects none and the row is looked up to tell that apart from a missing one
+		if err = rowExists(ctx, getStmt, input.ID); err != nil {
+			return errors.Wrap(err, errMsg())
+		}
+	}
+
+	return nil
+}
+
+// Delete sets deleted_at for a single categories row and returns the row as deleted
+func (svc *categoryService) Delete(ctx context.Context, ID uuid.UUID) (*Category, error) {
+	return svc.delete(ctx, false, ID)
+}
+
+// DeleteTx sets deleted_at for a single categories row within a tx from ctx and returns the row as deleted
+func (svc *categoryService) DeleteTx(ctx context.Context, ID uuid.UUID) (*Category, error) {
+	return svc.delete(ctx, true, ID)
+}
+
+// delete a category by setting deleted at. if useTx = true then it will attempt to delete the category within a transaction
+// from context. the row is read back after the write, run it in a tx for the read to see the same write
+func (svc *categoryService) delete(ctx context.Context, useTx bool, ID uuid.UUID) (*Category, error) {
+	errMsg := func() string { return "Error executing delete category - " + ID.String() }
+
+	stmt, err := txStmt(ctx, useTx, svc.stmts["delete-category"])
+	if err != nil {
+		return nil, err
+	}
+	getStmt, err := txStmt(ctx, useTx, svc.stmts["get-deleted-category"])
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := stmt.ExecContext(ctx, ID)
+	if err != nil {
+		return nil, errors.Wrap(err, errMsg())
+	}
+
+	rowCount, err := result.RowsAffected()
+	if err != nil {
+		return nil, errors.Wrap(err, errMsg())
+	}
+
+	if rowCount == 0 {
+		return nil, errors.Wrap(ErrNotFound, errMsg())
+	}
+
+	c := Category{}
+	err = getStmt.QueryRowContext(ctx, ID).
+		Scan(&c.ID, &c.Name)
+	if err != nil {
+		return nil, errors.Wrap(err, errMsg())
+	}
+
+	return &c, nil
+}
@@ -0,0 +1,280 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-sql-driver/mysql"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/caring/ford-mustang/pb"
+)
+
+// ensures that casting from proto to store structs occurs correctly
+func TestNewCategory(t *testing.T) {
+	categoryID := uuid.MustParse("72bc87f3-4a9f-4d05-93fe-844d3cd94c65")
+	proto := pb.CreateCategoryRequest{
+		Name: "Foobar",
+	}
+
+	r, err := NewCategory(categoryID.String(), &proto)
+
+	assert.NoError(t, err, "Expected NewCategory not to error")
+	assert.Equal(t, categoryID, r.ID, "Expected UUIDs to match")
+	assert.Equal(t, proto.Name, r.Name, "Expected name to be correctly assigned")
+}
+
+// ensures that casting from store to proto response occurs correctly
+func TestCategory_ToProto(t *testing.T) {
+	categoryID := uuid.MustParse("72bc87f3-4a9f-4d05-93fe-844d3cd94c65")
+
+	category := &Category{
+		ID:   categoryID,
+		Name: "foobar",
+	}
+
+	r := category.ToProto()
+
+	assert.Equal(t, categoryID.String(), r.Id, "Expected field to be mapped back to proto object correctly")
+	assert.Equal(t, "foobar", r.Name, "Expected field to be mapped back to proto object correctly")
+}
+
+func TestCategoryService_get(t *testing.T) {
+	categoryID := uuid.MustParse("72bc87f3-4a9f-4d05-93fe-844d3cd94c65")
+	stmt := map[string]string{
+		"get-category": "SELECT categories",
+	}
+	args := []driver.Value{
+		"72bc87f3-4a9f-4d05-93fe-844d3cd94c65",
+	}
+
+	// ensures that execution outside of transaction occurs without error and the correct result is returned
+	t.Run("Without a provided transaction", func(t *testing.T) {
+		store, mock, err := NewTestDB(stmt)
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "test setup failed")
+		}
+
+		mock.ExpectQuery("SELECT categories").
+			WithArgs(args...).
+			WillReturnRows(
+				sqlmock.NewRows([]string{"category_id", "name"}).
+					AddRow(categoryID[:], "Foobar"),
+			)
+
+		r, err := store.Category.Get(context.Background(), categoryID)
+		assert.NoError(t, err, "Expecting no query error")
+
+		assert.Equal(t, categoryID, r.ID, "Expected correct category ID to be returned")
+		assert.Equal(t, "Foobar", r.Name, "Expected correct name to be returned")
+
+		err = mock.ExpectationsWereMet()
+		assert.NoError(t, err, "Expecting all mock conditions to be met")
+	})
+
+	// ensures a record not found is handled correctly
+	t.Run("No rows returned", func(t *testing.T) {
+		store, mock, err := NewTestDB(stmt)
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "test setup failed")
+		}
+
+		mock.ExpectQuery("SELECT categories").
+			WithArgs(args...).WillReturnError(sql.ErrNoRows)
+
+		_, err = store.Category.Get(context.Background(), categoryID)
+		assert.EqualError(t, err, "Error executing get category - 72bc87f3-4a9f-4d05-93fe-844d3cd94c65: the record you are attempting to find or update is not found", "Expecting not found error")
+
+		err = mock.ExpectationsWereMet()
+		assert.NoError(t, err, "Expecting all mock conditions to be met")
+	})
+}
+
+func TestCategoryService_create(t *testing.T) {
+	categoryID := uuid.MustParse("72bc87f3-4a9f-4d05-93fe-844d3cd94c65")
+	stmt := map[string]string{
+		"create-category": "INSERT categories",
+	}
+	input := &Category{
+		ID:   categoryID,
+		Name: "Foobar",
+	}
+	args := []driver.Value{
+		"72bc87f3-4a9f-4d05-93fe-844d3cd94c65",
+		"Foobar",
+	}
+
+	// ensures that execution within a transaction occurs without error
+	t.Run("With a provided transaction", func(t *testing.T) {
+		store, mock, err := NewTestDB(stmt)
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "test setup failed")
+		}
+
+		mock.ExpectBegin()
+		mock.ExpectExec("INSERT categories").
+			WithArgs(args...).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		tx, err := store.GetTx()
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "transaction setup failed")
+		}
+
+		err = store.Category.CreateTx(ToCtx(context.Background(), tx), input)
+		assert.NoError(t, err, "Expecting no query error")
+
+		err = mock.ExpectationsWereMet()
+		assert.NoError(t, err, "Expecting all mock conditions to be met")
+	})
+
+	// ensures that a duplicate name is reported as a duplicate
+	t.Run("Duplicate name", func(t *testing.T) {
+		store, mock, err := NewTestDB(stmt)
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "test setup failed")
+		}
+
+		mock.ExpectExec("INSERT categories").
+			WithArgs(args...).
+			WillReturnError(&mysql.MySQLError{Number: mysqlErrDuplicateEntry, Message: "Duplicate entry"})
+
+		err = store.Category.Create(context.Background(), input)
+		assert.ErrorIs(t, err, ErrDuplicate, "Expecting a duplicate error")
+
+		err = mock.ExpectationsWereMet()
+		assert.NoError(t, err, "Expecting all mock conditions to be met")
+	})
+}
+
+func TestCategoryService_update(t *testing.T) {
+	categoryID := uuid.MustParse("72bc87f3-4a9f-4d05-93fe-844d3cd94c65")
+	stmt := map[string]string{
+		"update-category": "UPDATE categories",
+		"get-category":    "SELECT categories",
+	}
+	input := &Category{ID: categoryID, Name: "Foobar"}
+	args := []driver.Value{
+		"Foobar",
+		"72bc87f3-4a9f-4d05-93fe-844d3cd94c65",
+	}
+
+	// ensures that execution outside of a transaction occurs without error
+	t.Run("Without a provided transaction", func(t *testing.T) {
+		store, mock, err := NewTestDB(stmt)
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "test setup failed")
+		}
+
+		mock.ExpectExec("UPDATE categories").
+			WithArgs(args...).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		err = store.Category.Update(context.Background(), input)
+		assert.NoError(t, err, "Expecting no query error")
+
+		err = mock.ExpectationsWereMet()
+		assert.NoError(t, err, "Expecting all mock conditions to be met")
+	})
+
+	// ensures an update that changed nothing on an existing record succeeds
+	t.Run("Nothing changed", func(t *testing.T) {
+		store, mock, err := NewTestDB(stmt)
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "test setup failed")
+		}
+
+		mock.ExpectExec("UPDATE categories").
+			WithArgs(args...).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery("SELECT categories").
+			WithArgs("72bc87f3-4a9f-4d05-93fe-844d3cd94c65").
+			WillReturnRows(sqlmock.NewRows([]string{"category_id", "name"}).AddRow(categoryID[:], "Foobar"))
+
+		err = store.Category.Update(context.Background(), input)
+		assert.NoError(t, err, "Expecting no query error")
+
+		err = mock.ExpectationsWereMet()
+		assert.NoError(t, err, "Expecting all mock conditions to be met")
+	})
+
+	// ensures that updating a missing or deleted record is not found
+	t.Run("Updating a non existent record", func(t *testing.T) {
+		store, mock, err := NewTestDB(stmt)
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "test setup failed")
+		}
+
+		mock.ExpectExec("UPDATE categories").
+			WithArgs(args...).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery("SELECT categories").
+			WithArgs("72bc87f3-4a9f-4d05-93fe-844d3cd94c65").
+			WillReturnRows(sqlmock.NewRows([]string{"category_id", "name"}))
+
+		err = store.Category.Update(context.Background(), input)
+		assert.EqualError(t, err, "Error executing update category - &{72bc87f3-4a9f-4d05-93fe-844d3cd94c65 Foobar}: the record you are attempting to find or update is not found", "Expecting not found error")
+
+		err = mock.ExpectationsWereMet()
+		assert.NoError(t, err, "Expecting all mock conditions to be met")
+	})
+}
+
+func TestCategoryService_delete(t *testing.T) {
+	categoryID := uuid.MustParse("72bc87f3-4a9f-4d05-93fe-844d3cd94c65")
+	stmt := map[string]string{
+		"delete-category":      "UPDATE categories",
+		"get-deleted-category": "SELECT deleted categories",
+	}
+	args := []driver.Value{
+		"72bc87f3-4a9f-4d05-93fe-844d3cd94c65",
+	}
+
+	// ensures that execution outside of a transaction occurs without error and the deleted row is returned
+	t.Run("Without a provided transaction", func(t *testing.T) {
+		store, mock, err := NewTestDB(stmt)
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "test setup failed")
+		}
+
+		mock.ExpectExec("UPDATE categories").
+			WithArgs(args...).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery("SELECT deleted categories").
+			WithArgs(args...).
+			WillReturnRows(
+				sqlmock.NewRows([]string{"category_id", "name"}).
+					AddRow(categoryID[:], "Foobar"),
+			)
+
+		c, err := store.Category.Delete(context.Background(), categoryID)
+		assert.NoError(t, err, "Expecting no query error")
+		assert.Equal(t, categoryID, c.ID, "Expected the deleted category to be returned")
+		assert.Equal(t, "Foobar", c.Name, "Expected the deleted category to be returned")
+
+		err = mock.ExpectationsWereMet()
+		assert.NoError(t, err, "Expecting all mock conditions to be met")
+	})
+
+	// ensures that deleting a non existent record is handled correctly
+	t.Run("Deleting a non existent record", func(t *testing.T) {
+		store, mock, err := NewTestDB(stmt)
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "test setup failed")
+		}
+
+		mock.ExpectExec("UPDATE categories").
+			WithArgs(args...).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+
+		_, err = store.Category.Delete(context.Background(), categoryID)
+		assert.EqualError(t, err, "Error executing delete category - 72bc87f3-4a9f-4d05-93fe-844d3cd94c65: the record you are attempting to find or update is not found", "Expecting not found error")
+
+		err = mock.ExpectationsWereMet()
+		assert.NoError(t, err, "Expecting all mock conditions to be met")
+	})
+}
@@ -1,7 +1,15 @@
 package db
 
 import (
+	"database/sql"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
 )
 
 // NewTestDB creates a testable store instance with a mocked sql driver
@@ -10,25 +18,137 @@ import (
 //
 // queries executed against the test DB do not interact in any way with a real DB
 // see https://github.com/DATA-DOG/go-sqlmock
-func NewTestDB(stmts map[string]string) (*Store, sqlmock.Sqlmock, error) {
+func NewTestDB(stmts map[string]string, opts ...Option) (*Store, sqlmock.Sqlmock, error) {
 	db, mock, err := sqlmock.New()
 	if err != nil {
 		return nil, nil, err
 	}
 
-	for _, s := range stmts {
+	// sqlmock matches prepares in order, so each statement is prepared right after it is expected
+	prepared := map[string]*sql.Stmt{}
+	for name, s := range stmts {
 		mock.ExpectPrepare(s)
+		stmt, err := db.Prepare(s)
+		if err != nil {
+			return nil, nil, err
+		}
+		prepared[name] = stmt
 	}
 
-	prepared, err := prepareStmts(db, stmts)
+	return newStore(db, mysqlDialect, prepared, opts...), mock, nil
+}
+
+// NewTestDBWithReplica creates a testable store instance like NewTestDB with a single mocked
+// read replica, the second mock makes assertions against the queries routed to the replica
+func NewTestDBWithReplica(stmts map[string]string, opts ...Option) (*Store, sqlmock.Sqlmock, sqlmock.Sqlmock, error) {
+	store, mock, err := NewTestDB(stmts, opts...)
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, nil, err
+	}
+
+	db, replicaMock, err := sqlmock.New()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	prepared := map[string]*sql.Stmt{}
+	for _, name := range readStatements {
+		if s, ok := stmts[name]; ok {
+			replicaMock.ExpectPrepare(s)
+			stmt, err := db.Prepare(s)
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			prepared[name] = stmt
+		}
 	}
 
-	s := Store{
-		db:    db,
-		stmts: prepared,
+	store.replicas.add(db, prepared)
+
+	return store, mock, replicaMock, nil
+}
+
+// NewSQLiteTestDB creates a store backed by a SQLite database file in a temporary directory
+// with the SQLite migrations applied. unlike NewTestDB the queries run against real SQL
+func NewSQLiteTestDB(t *testing.T, opts ...Option) (*Store, error) {
+	path := filepath.Join(t.TempDir(), "ford-mustang.db")
+
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	migrations, err := filepath.Glob(filepath.Join("migrations", "sqlite", "*.up.sql"))
+	if err != nil {
+		return nil, err
 	}
+	sort.Strings(migrations)
+
+	for _, m := range migrations {
+		up, err := os.ReadFile(m)
+		if err != nil {
+			return nil, err
+		}
+		if _, err = db.Exec(string(up)); err != nil {
+			return nil, err
+		}
+	}
+
+	store, err := NewStore("sqlite://"+path, opts...)
+	if err != nil {
+		return nil, err
+	}
+	t.Cleanup(func() { store.Close() })
+
+	return store, nil
+}
+
+// withOutbox adds the statements a mustang mutation uses to write its change event and history to stmts
+func withOutbox(stmts map[string]string) map[string]string {
+	all := map[string]string{
+		"get-mustang-event":      "SELECT mustang event",
+		"create-outbox-event":    "INSERT outbox",
+		"create-mustang-history": "INSERT history",
+	}
+	for k, v := range stmts {
+		all[k] = v
+	}
+	return all
+}
+
+// expectMustangSnapshot expects the mustang to be read ahead of a write for its audit history
+func expectMustangSnapshot(mock sqlmock.Sqlmock, m Mustang, deleted bool) {
+	mock.ExpectQuery("SELECT mustang event").
+		WithArgs(m.ID.String()).
+		WillReturnRows(
+			sqlmock.NewRows([]string{"mustang_id", "name", "version", "deleted"}).
+				AddRow(m.ID[:], m.Name, m.Version, deleted),
+		)
+}
+
+// expectNoMustangSnapshot expects a mustang that does not exist to be read ahead of a write
+func expectNoMustangSnapshot(mock sqlmock.Sqlmock, ID uuid.UUID) {
+	mock.ExpectQuery("SELECT mustang event").
+		WithArgs(ID.String()).
+		WillReturnRows(sqlmock.NewRows([]string{"mustang_id", "name", "version", "deleted"}))
+}
+
+// expectMustangEvent expects the mustang to be read back, its change event written to the outbox
+// and the change appended to its history by an unknown actor
+func expectMustangEvent(mock sqlmock.Sqlmock, eventType string, m Mustang, deleted bool) {
+	payload, _ := json.Marshal(MustangEvent{
+		ID:      m.ID.String(),
+		Name:    m.Name,
+		Version: m.Version,
+		Deleted: deleted,
+	})
 
-	return &s, mock, nil
+	expectMustangSnapshot(mock, m, deleted)
+	mock.ExpectExec("INSERT outbox").
+		WithArgs(eventType, m.ID.String(), string(payload)).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT history").
+		WithArgs(m.ID.String(), operations[eventType], UnknownActor, sqlmock.AnyArg(), string(payload)).
+		WillReturnResult(sqlmock.NewResult(1, 1))
 }
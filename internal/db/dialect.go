@@ -0,0 +1,64 @@
+package db
+
+import (
+	"strings"
+)
+
+// dialect is the flavour of SQL spoken by a backing database
+type dialect struct {
+	// driverName is the database/sql driver used to connect
+	driverName string
+	// statements are prepared when the store is created
+	statements map[string]string
+	// batchGetMustangsQuery and patchMustangQuery are built when they are run
+	batchGetMustangsQuery string
+	patchMustangQuery     string
+	// uuidParam is the placeholder for a UUID argument in a built query
+	uuidParam string
+	// maxOpenConns limits the connection pool, 0 is unlimited
+	maxOpenConns int
+}
+
+// mysqlDialect stores UUIDs as BINARY(16)
+var mysqlDialect = &dialect{
+	driverName:            "mysql",
+	statements:            statements,
+	batchGetMustangsQuery: batchGetMustangsQuery,
+	patchMustangQuery:     patchMustangQuery,
+	uuidParam:             "UUID_TO_BIN(?)",
+}
+
+// sqliteDialect stores UUIDs as TEXT. SQLite allows a single writer so the pool is kept
+// to one connection rather than have concurrent transactions fail with SQLITE_BUSY
+var sqliteDialect = &dialect{
+	driverName:            "sqlite",
+	statements:            sqliteStatements,
+	batchGetMustangsQuery: sqliteBatchGetMustangsQuery,
+	patchMustangQuery:     sqlitePatchMustangQuery,
+	uuidParam:             "?",
+	maxOpenConns:          1,
+}
+
+// sqlitePragmas are applied to every SQLite connection. foreign keys are off by default in SQLite
+var sqlitePragmas = []string{
+	"_pragma=foreign_keys(1)",
+	"_pragma=busy_timeout(5000)",
+}
+
+// parseDSN picks the dialect named by the scheme of dataSourceName and returns the data source name
+// its driver expects. sqlite://path opens a SQLite database file, mysql:// or no scheme opens MySQL
+func parseDSN(dataSourceName string) (*dialect, string) {
+	switch {
+	case strings.HasPrefix(dataSourceName, "sqlite://"):
+		dsn := strings.TrimPrefix(dataSourceName, "sqlite://")
+		sep := "?"
+		if strings.Contains(dsn, "?") {
+			sep = "&"
+		}
+		return sqliteDialect, dsn + sep + strings.Join(sqlitePragmas, "&")
+	case strings.HasPrefix(dataSourceName, "mysql://"):
+		return mysqlDialect, strings.TrimPrefix(dataSourceName, "mysql://")
+	default:
+		return mysqlDialect, dataSourceName
+	}
+}
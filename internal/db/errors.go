@@ -1,6 +1,12 @@
 package db
 
-import "errors"
+import (
+	"errors"
+
+	"github.com/go-sql-driver/mysql"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
 
 var (
 	// ErrNoRows occurs when no records were found
@@ -8,5 +14,72 @@ var (
 	// ErrNoRowsAffected occurs when no rows were updated
 	ErrNoRowsAffected = errors.New("no rows affected")
 	// ErrNotFound when a specific reqcord was not found
-	ErrNotFound = errors.New("the record you are attempting to update is not found")
+	ErrNotFound = errors.New("the record you are attempting to find or update is not found")
+	// ErrNotCreated occurs when an insert did not create any rows
+	ErrNotCreated = errors.New("no new rows were created")
+	// ErrInvalidID occurs when an ID is missing or cannot be parsed to a UUID
+	ErrInvalidID = errors.New("invalid id")
+	// ErrInvalidName occurs when a name is empty or longer than MaxNameLength
+	ErrInvalidName = errors.New("invalid name")
+	// ErrInvalidPageToken occurs when a page token cannot be decoded to a cursor
+	ErrInvalidPageToken = errors.New("invalid page token")
+	// ErrBatchTooLarge occurs when a batch request asks for more keys than allowed
+	ErrBatchTooLarge = errors.New("batch exceeds the maximum number of keys")
+	// ErrNotDeleted occurs when restoring a record that was never deleted
+	ErrNotDeleted = errors.New("the record you are attempting to restore is not deleted")
+	// ErrVersionMismatch occurs when a write expects a different version than the record has
+	ErrVersionMismatch = errors.New("the record has been modified since the version you provided")
+	// ErrInvalidField occurs when a field mask names a field that cannot be written
+	ErrInvalidField = errors.New("invalid field in update mask")
+	// ErrDuplicate occurs when a write violates a unique key
+	ErrDuplicate = errors.New("a record with the same unique value already exists")
+	// ErrInvalidReference occurs when a write references a parent record that does not exist
+	ErrInvalidReference = errors.New("a referenced record does not exist")
+	// ErrInvalidResumeToken occurs when a resume token cannot be decoded
+	ErrInvalidResumeToken = errors.New("invalid resume token")
+	// ErrResumeTokenExpired occurs when the changes after a resume token are no longer retained
+	ErrResumeTokenExpired = errors.New("the changes since the resume token are no longer available")
+	// ErrWatchTooSlow occurs when a watcher falls too far behind the changes being published
+	ErrWatchTooSlow = errors.New("the watch fell too far behind and was dropped")
+	// ErrWatchClosed occurs when the server ends a watch because it is shutting down
+	ErrWatchClosed = errors.New("the watch was closed by the server")
+	// ErrInvalidIdempotencyKey occurs when an idempotency key is empty or too long
+	ErrInvalidIdempotencyKey = errors.New("invalid idempotency key")
+	// ErrIdempotencyKeyReused occurs when an idempotency key is sent again with a different request
+	ErrIdempotencyKeyReused = errors.New("the idempotency key was already used for a different request")
+	// ErrIdempotencyConflict occurs when another request with the same idempotency key is in flight
+	ErrIdempotencyConflict = errors.New("a request with the same idempotency key is in progress")
+)
+
+// MySQL error numbers that are translated into store errors
+const (
+	mysqlErrDuplicateEntry  = 1062
+	mysqlErrNoReferencedRow = 1452
 )
+
+// translateDriverError swaps constraint violations reported by the driver for
+// the matching store error so callers don't need to know about driver internals
+func translateDriverError(err error) error {
+	var mErr *mysql.MySQLError
+	if errors.As(err, &mErr) {
+		switch mErr.Number {
+		case mysqlErrDuplicateEntry:
+			return ErrDuplicate
+		case mysqlErrNoReferencedRow:
+			return ErrInvalidReference
+		}
+		return err
+	}
+
+	var sErr *sqlite.Error
+	if errors.As(err, &sErr) {
+		switch sErr.Code() {
+		case sqlite3.SQLITE_CONSTRAINT_UNIQUE, sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY:
+			return ErrDuplicate
+		case sqlite3.SQLITE_CONSTRAINT_FOREIGNKEY:
+			return ErrInvalidReference
+		}
+	}
+
+	return err
+}
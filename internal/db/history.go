@@ -0,0 +1,200 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/caring/go-packages/pkg/errors"
+	"github.com/google/uuid"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/caring/ford-mustang/pb"
+)
+
+// Operations recorded in the audit history of a mustang
+const (
+	OperationCreate  = "create"
+	OperationUpdate  = "update"
+	OperationDelete  = "delete"
+	OperationRestore = "restore"
+)
+
+// operations maps the event type of a change to the operation recorded in the audit history
+var operations = map[string]string{
+	EventMustangCreated:  OperationCreate,
+	EventMustangUpdated:  OperationUpdate,
+	EventMustangDeleted:  OperationDelete,
+	EventMustangRestored: OperationRestore,
+}
+
+// UnknownActor is recorded as the actor of changes made without one in ctx
+const UnknownActor = "unknown"
+
+type actorCtxKey struct{}
+
+// WithActor stores the actor making changes within a context, it is recorded
+// in the audit history of every mustang changed with the returned context
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorCtxKey{}, actor)
+}
+
+// ActorFromCtx returns the actor stored by WithActor, or UnknownActor when there is none
+func ActorFromCtx(ctx context.Context) string {
+	if actor, ok := ctx.Value(actorCtxKey{}).(string); ok && actor != "" {
+		return actor
+	}
+	return UnknownActor
+}
+
+// MustangHistory is a struct representation of a row in the mustang_history table, an immutable
+// record of a single change to a mustang
+type MustangHistory struct {
+	ID        int64
+	MustangID uuid.UUID
+	Operation string
+	Actor     string
+	// Before is the mustang ahead of the change, nil for a create
+	Before *MustangEvent
+	// After is the mustang as the change left it
+	After     *MustangEvent
+	CreatedAt time.Time
+}
+
+// ToProto casts a db history entry into a proto history entry
+func (h *MustangHistory) ToProto() *pb.MustangHistoryEntry {
+	return &pb.MustangHistoryEntry{
+		Operation: h.Operation,
+		Actor:     h.Actor,
+		Before:    h.Before.toProto(),
+		After:     h.After.toProto(),
+		CreatedAt: timestamppb.New(h.CreatedAt),
+	}
+}
+
+// toProto casts a mustang snapshot into a proto snapshot, nil stays nil
+func (e *MustangEvent) toProto() *pb.MustangSnapshot {
+	if e == nil {
+		return nil
+	}
+	return &pb.MustangSnapshot{
+		Id:      e.ID,
+		Name:    e.Name,
+		Version: e.Version,
+		Deleted: e.Deleted,
+	}
+}
+
+// recordHistory appends a row to the audit history of the mustang within the tx from ctx,
+// the actor is taken from ctx
+func (svc *mustangService) recordHistory(ctx context.Context, eventType string, ID uuid.UUID, before, after *MustangEvent) error {
+	errMsg := func() string { return "Error executing record mustang history - " + eventType + " " + ID.String() }
+
+	stmt, err := txStmt(ctx, true, svc.stmts["create-mustang-history"])
+	if err != nil {
+		return err
+	}
+
+	var beforeState interface{}
+	if before != nil {
+		if beforeState, err = jsonArg(before); err != nil {
+			return errors.Wrap(err, errMsg())
+		}
+	}
+	afterState, err := jsonArg(after)
+	if err != nil {
+		return errors.Wrap(err, errMsg())
+	}
+
+	_, err = stmt.ExecContext(ctx, ID, operations[eventType], ActorFromCtx(ctx), beforeState, afterState)
+	if err != nil {
+		return errors.Wrap(err, errMsg())
+	}
+
+	return nil
+}
+
+// History fetches a page of the audit history of a mustang oldest first, following the entry with
+// the ID after. an after of 0 fetches the first page. The returned ID is the last entry of the page
+// and is 0 when there are no more pages. History outlives a soft delete but is purged with the mustang
+func (svc *mustangService) History(ctx context.Context, ID uuid.UUID, limit int, after int64) ([]*MustangHistory, int64, error) {
+	return svc.history(ctx, false, ID, limit, after)
+}
+
+// HistoryTx fetches a page of the audit history of a mustang inside of a tx from ctx
+func (svc *mustangService) HistoryTx(ctx context.Context, ID uuid.UUID, limit int, after int64) ([]*MustangHistory, int64, error) {
+	return svc.history(ctx, true, ID, limit, after)
+}
+
+// history fetches a page of the audit history of a mustang using keyset pagination over history_id
+func (svc *mustangService) history(ctx context.Context, useTx bool, ID uuid.UUID, limit int, after int64) ([]*MustangHistory, int64, error) {
+	errMsg := func() string {
+		return "Error executing get mustang history - " + ID.String() + " " + strconv.FormatInt(after, 10)
+	}
+
+	stmt, err := svc.replicas.readStmt(ctx, useTx, "list-mustang-history", svc.stmts)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := stmt.QueryContext(ctx, ID, after, pageFetchLimit(limit))
+	if err != nil {
+		return nil, 0, errors.Wrap(err, errMsg())
+	}
+	defer rows.Close()
+
+	var (
+		entries []*MustangHistory
+		next    int64
+		more    bool
+	)
+
+	for rows.Next() {
+		h := MustangHistory{MustangID: ID}
+		var beforeState, afterState sql.NullString
+
+		if err = rows.Scan(&h.ID, &h.Operation, &h.Actor, &beforeState, &afterState, &h.CreatedAt); err != nil {
+			return nil, 0, errors.Wrap(err, errMsg())
+		}
+
+		if len(entries) == limit {
+			more = true
+			break
+		}
+
+		if h.Before, err = parseSnapshot(beforeState); err != nil {
+			return nil, 0, errors.Wrap(err, errMsg())
+		}
+		if h.After, err = parseSnapshot(afterState); err != nil {
+			return nil, 0, errors.Wrap(err, errMsg())
+		}
+
+		entries = append(entries, &h)
+		next = h.ID
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, 0, errors.Wrap(err, errMsg())
+	}
+
+	if !more {
+		next = 0
+	}
+
+	return entries, next, nil
+}
+
+// parseSnapshot decodes a mustang state stored as JSON, NULL is nil
+func parseSnapshot(state sql.NullString) (*MustangEvent, error) {
+	if !state.Valid {
+		return nil, nil
+	}
+
+	var e MustangEvent
+	if err := json.Unmarshal([]byte(state.String), &e); err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
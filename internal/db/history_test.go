@@ -0,0 +1,112 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMustangService_recordHistory(t *testing.T) {
+	mustangID := uuid.MustParse("72bc87f3-4a9f-4d05-93fe-844d3cd94c65")
+	stmt := withOutbox(map[string]string{
+		"update-mustang":      "UPDATE mustangs",
+		"get-mustang-version": "SELECT version",
+	})
+
+	// ensures the actor from ctx and the mustang before and after the change are recorded
+	t.Run("Records actor and states", func(t *testing.T) {
+		store, mock, err := NewTestDB(stmt)
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "test setup failed")
+		}
+
+		mock.ExpectBegin()
+		expectMustangSnapshot(mock, Mustang{ID: mustangID, Name: "Foobar", Version: 1}, false)
+		mock.ExpectExec("UPDATE mustangs").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		expectMustangSnapshot(mock, Mustang{ID: mustangID, Name: "Bazqux", Version: 2}, false)
+		mock.ExpectExec("INSERT outbox").
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec("INSERT history").
+			WithArgs(
+				mustangID.String(),
+				OperationUpdate,
+				"support@caring.com",
+				`{"id":"72bc87f3-4a9f-4d05-93fe-844d3cd94c65","name":"Foobar","version":1,"deleted":false}`,
+				`{"id":"72bc87f3-4a9f-4d05-93fe-844d3cd94c65","name":"Bazqux","version":2,"deleted":false}`,
+			).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+
+		ctx := WithActor(context.Background(), "support@caring.com")
+		err = store.Mustang.Update(ctx, &Mustang{ID: mustangID, Name: "Bazqux"})
+		assert.NoError(t, err, "Expecting no update error")
+
+		err = mock.ExpectationsWereMet()
+		assert.NoError(t, err, "Expecting all mock conditions to be met")
+	})
+}
+
+func TestMustangService_history(t *testing.T) {
+	mustangID := uuid.MustParse("72bc87f3-4a9f-4d05-93fe-844d3cd94c65")
+	createdAt := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	stmt := map[string]string{
+		"list-mustang-history": "SELECT history",
+	}
+	rows := func() *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"history_id", "operation", "actor", "before_state", "after_state", "created_at"}).
+			AddRow(4, OperationCreate, "support@caring.com", nil, []byte(`{"id":"72bc87f3-4a9f-4d05-93fe-844d3cd94c65","name":"Foobar","version":1}`), createdAt).
+			AddRow(7, OperationDelete, UnknownActor, []byte(`{"id":"72bc87f3-4a9f-4d05-93fe-844d3cd94c65","name":"Foobar","version":1}`), []byte(`{"id":"72bc87f3-4a9f-4d05-93fe-844d3cd94c65","name":"Foobar","version":2,"deleted":true}`), createdAt)
+	}
+
+	// ensures a full page returns the last entry to continue from
+	t.Run("First page with more results", func(t *testing.T) {
+		store, mock, err := NewTestDB(stmt)
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "test setup failed")
+		}
+
+		mock.ExpectQuery("SELECT history").
+			WithArgs(mustangID.String(), 0, 2).
+			WillReturnRows(rows())
+
+		entries, next, err := store.Mustang.History(context.Background(), mustangID, 1, 0)
+		assert.NoError(t, err, "Expecting no query error")
+		if assert.Len(t, entries, 1, "Expected a single entry") {
+			assert.Equal(t, OperationCreate, entries[0].Operation, "Expected the create")
+			assert.Nil(t, entries[0].Before, "Expected no state before a create")
+			assert.Equal(t, "Foobar", entries[0].After.Name, "Expected the state after the create")
+		}
+		assert.Equal(t, int64(4), next, "Expected the last entry of the page")
+
+		err = mock.ExpectationsWereMet()
+		assert.NoError(t, err, "Expecting all mock conditions to be met")
+	})
+
+	// ensures the last page has nothing to continue from
+	t.Run("Last page after an entry", func(t *testing.T) {
+		store, mock, err := NewTestDB(stmt)
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "test setup failed")
+		}
+
+		mock.ExpectQuery("SELECT history").
+			WithArgs(mustangID.String(), 3, 11).
+			WillReturnRows(rows())
+
+		entries, next, err := store.Mustang.History(context.Background(), mustangID, 10, 3)
+		assert.NoError(t, err, "Expecting no query error")
+		if assert.Len(t, entries, 2, "Expected both entries") {
+			assert.True(t, entries[1].After.Deleted, "Expected the state after the delete")
+			assert.Equal(t, int64(1), entries[1].Before.Version, "Expected the state before the delete")
+		}
+		assert.Equal(t, int64(0), next, "Expected no more pages")
+
+		err = mock.ExpectationsWereMet()
+		assert.NoError(t, err, "Expecting all mock conditions to be met")
+	})
+}
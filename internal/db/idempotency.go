@@ -0,0 +1,147 @@
+package db
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"github.com/caring/go-packages/pkg/errors"
+	"github.com/google/uuid"
+)
+
+const (
+	// DefaultIdempotencyTTL is how long an idempotency key is kept when no TTL is configured
+	DefaultIdempotencyTTL = 24 * time.Hour
+	// MaxIdempotencyKeyLength is the longest idempotency key that can be stored
+	MaxIdempotencyKeyLength = 255
+)
+
+// WithIdempotencyTTL sets how long an idempotency key and the result of the request
+// that first used it are kept, a retry made after the TTL is treated as a new request
+func WithIdempotencyTTL(ttl time.Duration) Option {
+	return func(s *Store) {
+		s.Mustang.idempotencyTTL = ttl
+	}
+}
+
+// CreateIdempotent creates a new mustang once per idempotency key. Retrying with a key that was
+// already used returns the mustang as the first create returned it rather than creating another,
+// for as long as the key is kept. Reusing a key for a different mustang returns ErrIdempotencyKeyReused.
+// Keys are scoped to the actor in ctx, the same key sent by different actors creates a mustang for each
+func (svc *mustangService) CreateIdempotent(ctx context.Context, key string, input *Mustang) (*Mustang, error) {
+	return svc.createIdempotent(ctx, false, key, input)
+}
+
+// CreateIdempotentTx creates a new mustang once per idempotency key within a tx from ctx
+func (svc *mustangService) CreateIdempotentTx(ctx context.Context, key string, input *Mustang) (*Mustang, error) {
+	return svc.createIdempotent(ctx, true, key, input)
+}
+
+// createIdempotent looks the actor's key up and either replays the stored result or creates the mustang and
+// stores the result under the key, in one transaction. concurrent creates with the same key contend
+// on the key's row and the loser is retried by WithTx, or fails with ErrIdempotencyConflict
+func (svc *mustangService) createIdempotent(ctx context.Context, useTx bool, key string, input *Mustang) (*Mustang, error) {
+	actor := ActorFromCtx(ctx)
+	errMsg := func() string { return "Error executing create mustang idempotently - " + actor + "/" + key }
+
+	if key == "" || len(key) > MaxIdempotencyKeyLength {
+		return nil, errors.Wrap(ErrInvalidIdempotencyKey, errMsg())
+	}
+	hash := requestHash(input)
+
+	var result *Mustang
+	err := svc.inTx(ctx, useTx, func(ctx context.Context) error {
+		get, err := txStmt(ctx, true, svc.stmts["get-idempotency-key"])
+		if err != nil {
+			return err
+		}
+		del, err := txStmt(ctx, true, svc.stmts["delete-idempotency-key"])
+		if err != nil {
+			return err
+		}
+		create, err := txStmt(ctx, true, svc.stmts["create-idempotency-key"])
+		if err != nil {
+			return err
+		}
+
+		var (
+			storedHash string
+			response   string
+			expiresAt  time.Time
+		)
+		now := time.Now().UTC()
+
+		err = get.QueryRowContext(ctx, actor, key).Scan(&storedHash, &response, &expiresAt)
+		switch {
+		case err == nil && expiresAt.After(now):
+			if storedHash != hash {
+				return errors.Wrap(ErrIdempotencyKeyReused, errMsg())
+			}
+			result, err = parseIdempotentResponse(response)
+			if err != nil {
+				return errors.Wrap(err, errMsg())
+			}
+			return nil
+		case err == nil:
+			// the key expired but has not been purged yet, it is free to use again
+			if _, err = del.ExecContext(ctx, actor, key); err != nil {
+				return errors.Wrap(err, errMsg())
+			}
+		case !errors.Is(err, sql.ErrNoRows):
+			return errors.Wrap(err, errMsg())
+		}
+
+		if err = svc.create(ctx, true, input); err != nil {
+			return err
+		}
+
+		stored, err := jsonArg(MustangEvent{
+			ID:      input.ID.String(),
+			Name:    input.Name,
+			Version: input.Version,
+		})
+		if err != nil {
+			return errors.Wrap(err, errMsg())
+		}
+
+		_, err = create.ExecContext(ctx, actor, key, hash, stored, now.Add(svc.idempotencyTTL))
+		if err != nil {
+			if err = translateDriverError(err); errors.Is(err, ErrDuplicate) {
+				return errors.Wrap(ErrIdempotencyConflict, errMsg())
+			}
+			return errors.Wrap(err, errMsg())
+		}
+
+		result = input
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// requestHash fingerprints the fields a create writes so a key reused for a different mustang is caught
+func requestHash(input *Mustang) string {
+	sum := sha256.Sum256([]byte(input.Name))
+	return hex.EncodeToString(sum[:])
+}
+
+// parseIdempotentResponse decodes the mustang stored with an idempotency key
+func parseIdempotentResponse(response string) (*Mustang, error) {
+	var e MustangEvent
+	if err := json.Unmarshal([]byte(response), &e); err != nil {
+		return nil, err
+	}
+
+	ID, err := uuid.Parse(e.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Mustang{ID: ID, Name: e.Name, Version: e.Version}, nil
+}
@@ -0,0 +1,176 @@
+package db
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMustangService_createIdempotent(t *testing.T) {
+	mustangID := uuid.MustParse("72bc87f3-4a9f-4d05-93fe-844d3cd94c65")
+	originalID := uuid.MustParse("94cc5321-ec44-464f-9008-3d81f5e2c18f")
+	stmt := withOutbox(map[string]string{
+		"create-mustang":         "INSERT mustangs",
+		"get-idempotency-key":    "SELECT idempotency",
+		"delete-idempotency-key": "DELETE idempotency",
+		"create-idempotency-key": "INSERT idempotency",
+	})
+	keyColumns := []string{"request_hash", "response", "expires_at"}
+	original := `{"id":"94cc5321-ec44-464f-9008-3d81f5e2c18f","name":"Foobar","version":1,"deleted":false}`
+
+	// ensures a new key creates the mustang and stores the result
+	t.Run("New key", func(t *testing.T) {
+		store, mock, err := NewTestDB(stmt)
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "test setup failed")
+		}
+
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT idempotency").
+			WithArgs(UnknownActor, "retry-1").
+			WillReturnRows(sqlmock.NewRows(keyColumns))
+		mock.ExpectExec("INSERT mustangs").
+			WithArgs(mustangID.String(), "Foobar").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		expectMustangEvent(mock, EventMustangCreated, Mustang{ID: mustangID, Name: "Foobar", Version: 1}, false)
+		mock.ExpectExec("INSERT idempotency").
+			WithArgs(UnknownActor, "retry-1", requestHash(&Mustang{Name: "Foobar"}), `{"id":"72bc87f3-4a9f-4d05-93fe-844d3cd94c65","name":"Foobar","version":1,"deleted":false}`, sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		m, err := store.Mustang.CreateIdempotent(context.Background(), "retry-1", &Mustang{ID: mustangID, Name: "Foobar"})
+		assert.NoError(t, err, "Expecting no create error")
+		assert.Equal(t, mustangID, m.ID, "Expected the created mustang")
+
+		err = mock.ExpectationsWereMet()
+		assert.NoError(t, err, "Expecting all mock conditions to be met")
+	})
+
+	// ensures a replayed key returns the original mustang without creating another
+	t.Run("Replayed key", func(t *testing.T) {
+		store, mock, err := NewTestDB(stmt)
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "test setup failed")
+		}
+
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT idempotency").
+			WithArgs(UnknownActor, "retry-1").
+			WillReturnRows(sqlmock.NewRows(keyColumns).
+				AddRow(requestHash(&Mustang{Name: "Foobar"}), original, time.Now().Add(time.Hour)))
+		mock.ExpectCommit()
+
+		m, err := store.Mustang.CreateIdempotent(context.Background(), "retry-1", &Mustang{ID: mustangID, Name: "Foobar"})
+		assert.NoError(t, err, "Expecting no create error")
+		assert.Equal(t, &Mustang{ID: originalID, Name: "Foobar", Version: 1}, m, "Expected the original mustang")
+
+		err = mock.ExpectationsWereMet()
+		assert.NoError(t, err, "Expecting all mock conditions to be met")
+	})
+
+	// ensures a key sent with a different request is rejected
+	t.Run("Reused key", func(t *testing.T) {
+		store, mock, err := NewTestDB(stmt)
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "test setup failed")
+		}
+
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT idempotency").
+			WithArgs(UnknownActor, "retry-1").
+			WillReturnRows(sqlmock.NewRows(keyColumns).
+				AddRow(requestHash(&Mustang{Name: "Foobar"}), original, time.Now().Add(time.Hour)))
+		mock.ExpectRollback()
+
+		_, err = store.Mustang.CreateIdempotent(context.Background(), "retry-1", &Mustang{ID: mustangID, Name: "Bazqux"})
+		assert.ErrorIs(t, err, ErrIdempotencyKeyReused, "Expecting the key to be rejected")
+
+		err = mock.ExpectationsWereMet()
+		assert.NoError(t, err, "Expecting all mock conditions to be met")
+	})
+
+	// ensures an expired key is freed and the mustang created again
+	t.Run("Expired key", func(t *testing.T) {
+		store, mock, err := NewTestDB(stmt)
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "test setup failed")
+		}
+
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT idempotency").
+			WithArgs(UnknownActor, "retry-1").
+			WillReturnRows(sqlmock.NewRows(keyColumns).
+				AddRow(requestHash(&Mustang{Name: "Foobar"}), original, time.Now().Add(-time.Hour)))
+		mock.ExpectExec("DELETE idempotency").
+			WithArgs(UnknownActor, "retry-1").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("INSERT mustangs").
+			WithArgs(mustangID.String(), "Foobar").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		expectMustangEvent(mock, EventMustangCreated, Mustang{ID: mustangID, Name: "Foobar", Version: 1}, false)
+		mock.ExpectExec("INSERT idempotency").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		m, err := store.Mustang.CreateIdempotent(context.Background(), "retry-1", &Mustang{ID: mustangID, Name: "Foobar"})
+		assert.NoError(t, err, "Expecting no create error")
+		assert.Equal(t, mustangID, m.ID, "Expected a new mustang")
+
+		err = mock.ExpectationsWereMet()
+		assert.NoError(t, err, "Expecting all mock conditions to be met")
+	})
+
+	// ensures the same key sent by another actor is looked up separately
+	t.Run("Other actor", func(t *testing.T) {
+		store, mock, err := NewTestDB(stmt)
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "test setup failed")
+		}
+
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT idempotency").
+			WithArgs("support@caring.com", "retry-1").
+			WillReturnRows(sqlmock.NewRows(keyColumns))
+		mock.ExpectExec("INSERT mustangs").
+			WithArgs(mustangID.String(), "Foobar").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		// the history records the same actor the key is scoped to
+		expectMustangSnapshot(mock, Mustang{ID: mustangID, Name: "Foobar", Version: 1}, false)
+		mock.ExpectExec("INSERT outbox").
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec("INSERT history").
+			WithArgs(mustangID.String(), OperationCreate, "support@caring.com", sqlmock.AnyArg(), sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec("INSERT idempotency").
+			WithArgs("support@caring.com", "retry-1", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		ctx := WithActor(context.Background(), "support@caring.com")
+		m, err := store.Mustang.CreateIdempotent(ctx, "retry-1", &Mustang{ID: mustangID, Name: "Foobar"})
+		assert.NoError(t, err, "Expecting no create error")
+		assert.Equal(t, mustangID, m.ID, "Expected the created mustang")
+
+		err = mock.ExpectationsWereMet()
+		assert.NoError(t, err, "Expecting all mock conditions to be met")
+	})
+
+	// ensures keys that can't be stored are rejected before touching the db
+	t.Run("Invalid key", func(t *testing.T) {
+		store, mock, err := NewTestDB(stmt)
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "test setup failed")
+		}
+
+		_, err = store.Mustang.CreateIdempotent(context.Background(), strings.Repeat("k", MaxIdempotencyKeyLength+1), &Mustang{ID: mustangID, Name: "Foobar"})
+		assert.ErrorIs(t, err, ErrInvalidIdempotencyKey, "Expecting the key to be rejected")
+
+		err = mock.ExpectationsWereMet()
+		assert.NoError(t, err, "Expecting all mock conditions to be met")
+	})
+}
@@ -0,0 +1,85 @@
+package memory
+
+import (
+	"context"
+	"time"
+
+	"github.com/caring/go-packages/pkg/errors"
+
+	"github.com/caring/ford-mustang/internal/db"
+)
+
+// idempotencyKey is an idempotency key scoped to the actor that sent it
+type idempotencyKey struct {
+	actor string
+	key   string
+}
+
+// idempotencyRecord is the result stored with an idempotency key
+type idempotencyRecord struct {
+	// name is the name the first request was sent with, a retry must send the same one
+	name      string
+	mustang   db.Mustang
+	expiresAt time.Time
+}
+
+// CreateIdempotent creates a new mustang once per idempotency key the same way the MySQL store does.
+// Expired keys are only dropped when they are used again, nothing purges the in memory store
+func (repo *mustangRepository) CreateIdempotent(ctx context.Context, key string, input *db.Mustang) (*db.Mustang, error) {
+	return repo.createIdempotent(ctx, false, key, input)
+}
+
+// CreateIdempotentTx creates a new mustang once per idempotency key within a tx from ctx
+func (repo *mustangRepository) CreateIdempotentTx(ctx context.Context, key string, input *db.Mustang) (*db.Mustang, error) {
+	return repo.createIdempotent(ctx, true, key, input)
+}
+
+// createIdempotent replays the result stored with the actor's key or creates the mustang and stores
+// the result under the key, in one tx so a failed create leaves the key free
+func (repo *mustangRepository) createIdempotent(ctx context.Context, useTx bool, key string, input *db.Mustang) (*db.Mustang, error) {
+	k := idempotencyKey{actor: db.ActorFromCtx(ctx), key: key}
+	errMsg := func() string { return "Error executing create mustang idempotently - " + k.actor + "/" + key }
+
+	if key == "" || len(key) > db.MaxIdempotencyKeyLength {
+		return nil, errors.Wrap(db.ErrInvalidIdempotencyKey, errMsg())
+	}
+
+	var result db.Mustang
+	create := func(ctx context.Context) error {
+		return repo.store.run(ctx, true, func(d *data) error {
+			now := repo.store.now()
+			if stored, ok := d.idempotencyKeys[k]; ok && stored.expiresAt.After(now) {
+				if stored.name != input.Name {
+					return errors.Wrap(db.ErrIdempotencyKeyReused, errMsg())
+				}
+				result = stored.mustang
+				return nil
+			}
+
+			if err := repo.create(ctx, true, input); err != nil {
+				return err
+			}
+
+			d.saveIdempotencyKey(k)
+			d.idempotencyKeys[k] = &idempotencyRecord{
+				name:      input.Name,
+				mustang:   *input,
+				expiresAt: now.Add(repo.store.idempotencyTTL),
+			}
+			result = *input
+			return nil
+		})
+	}
+
+	var err error
+	if useTx {
+		err = create(ctx)
+	} else {
+		err = repo.store.WithTx(ctx, nil, create)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
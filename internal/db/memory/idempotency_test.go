@@ -0,0 +1,52 @@
+package memory
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/caring/ford-mustang/internal/db"
+)
+
+func TestMustangRepository_createIdempotent(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	store := NewStore(WithIdempotencyTTL(time.Hour))
+	store.now = func() time.Time { return now }
+
+	first, err := store.Mustang.CreateIdempotent(ctx, "retry-1", &db.Mustang{ID: uuid.New(), Name: "Foobar"})
+	assert.NoError(t, err, "Expecting no create error")
+
+	// ensures a retry returns the first mustang rather than creating another
+	replayed, err := store.Mustang.CreateIdempotent(ctx, "retry-1", &db.Mustang{ID: uuid.New(), Name: "Foobar"})
+	assert.NoError(t, err, "Expecting no replay error")
+	assert.Equal(t, first, replayed, "Expected the replay to return the first mustang")
+
+	// ensures a key sent with a different request is rejected
+	_, err = store.Mustang.CreateIdempotent(ctx, "retry-1", &db.Mustang{ID: uuid.New(), Name: "Bazqux"})
+	assert.ErrorIs(t, err, db.ErrIdempotencyKeyReused, "Expected the key to be rejected")
+
+	// ensures the key is scoped to the actor that sent it
+	other, err := store.Mustang.CreateIdempotent(db.WithActor(ctx, "support@caring.com"), "retry-1", &db.Mustang{ID: uuid.New(), Name: "Foobar"})
+	assert.NoError(t, err, "Expecting no create error")
+	assert.NotEqual(t, first.ID, other.ID, "Expected another actor to create a new mustang")
+
+	// ensures an expired key creates the mustang again
+	now = now.Add(2 * time.Hour)
+	expired, err := store.Mustang.CreateIdempotent(ctx, "retry-1", &db.Mustang{ID: uuid.New(), Name: "Bazqux"})
+	assert.NoError(t, err, "Expecting no create error")
+	assert.NotEqual(t, first.ID, expired.ID, "Expected a new mustang once the key expired")
+
+	// ensures a failed create leaves the key free
+	_, err = store.Mustang.CreateIdempotent(ctx, "retry-2", &db.Mustang{ID: first.ID, Name: "Foobar"})
+	assert.ErrorIs(t, err, db.ErrDuplicate, "Expected the duplicate ID to be rejected")
+	_, err = store.Mustang.CreateIdempotent(ctx, "retry-2", &db.Mustang{ID: uuid.New(), Name: "Bazqux"})
+	assert.NoError(t, err, "Expected the key to be free after the failed create")
+
+	mustangs, _, err := store.Mustang.List(ctx, 10, nil)
+	assert.NoError(t, err, "Expecting no list error")
+	assert.Len(t, mustangs, 4, "Expected a mustang per successful create")
+}
@@ -0,0 +1,353 @@
+package memory
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/caring/go-packages/pkg/errors"
+	"github.com/google/uuid"
+
+	"github.com/caring/ford-mustang/internal/db"
+)
+
+// mustangRepository is an in memory db.MustangRepository
+type mustangRepository struct {
+	store *Store
+}
+
+var _ db.MustangRepository = (*mustangRepository)(nil)
+
+// mustangRecord is a stored mustang with the columns the MySQL table keeps alongside it
+type mustangRecord struct {
+	mustang   db.Mustang
+	createdAt time.Time
+	deleted   bool
+}
+
+// mustangPatchFields maps the field mask paths a patch may name to how they are applied
+var mustangPatchFields = map[string]func(dst, src *db.Mustang){
+	"name": func(dst, src *db.Mustang) { dst.Name = src.Name },
+}
+
+// live returns the record for ID when it exists and is not soft deleted
+func (d *data) live(ID uuid.UUID) (*mustangRecord, bool) {
+	r, ok := d.mustangs[ID]
+	if !ok || r.deleted {
+		return nil, false
+	}
+	return r, true
+}
+
+// Get fetches a single mustang
+func (repo *mustangRepository) Get(ctx context.Context, ID uuid.UUID) (*db.Mustang, error) {
+	return repo.get(ctx, false, ID)
+}
+
+// GetTx fetches a single mustang inside of a tx from ctx
+func (repo *mustangRepository) GetTx(ctx context.Context, ID uuid.UUID) (*db.Mustang, error) {
+	return repo.get(ctx, true, ID)
+}
+
+// get fetches a single mustang that is not soft deleted
+func (repo *mustangRepository) get(ctx context.Context, useTx bool, ID uuid.UUID) (*db.Mustang, error) {
+	errMsg := func() string { return "Error executing get mustang - " + fmt.Sprint(ID) }
+
+	var m db.Mustang
+	err := repo.store.run(ctx, useTx, func(d *data) error {
+		r, ok := d.live(ID)
+		if !ok {
+			return errors.Wrap(db.ErrNotFound, errMsg())
+		}
+		m = r.mustang
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &m, nil
+}
+
+// Create a new mustang
+func (repo *mustangRepository) Create(ctx context.Context, input *db.Mustang) error {
+	return repo.create(ctx, false, input)
+}
+
+// CreateTx creates a new mustang within a tx from ctx
+func (repo *mustangRepository) CreateTx(ctx context.Context, input *db.Mustang) error {
+	return repo.create(ctx, true, input)
+}
+
+// create a new mustang, an ID that is already stored, even soft deleted, is a duplicate
+func (repo *mustangRepository) create(ctx context.Context, useTx bool, input *db.Mustang) error {
+	errMsg := func() string { return "Error executing create mustang - " + fmt.Sprint(input) }
+
+	return repo.store.run(ctx, useTx, func(d *data) error {
+		if _, ok := d.mustangs[input.ID]; ok {
+			return errors.Wrap(db.ErrDuplicate, errMsg())
+		}
+
+		// new rows start at the column default
+		input.Version = 1
+
+		d.saveMustang(input.ID)
+		r := &mustangRecord{
+			mustang:   *input,
+			createdAt: repo.store.now(),
+		}
+		d.mustangs[input.ID] = r
+		d.record(db.EventMustangCreated, r)
+		return nil
+	})
+}
+
+// Update updates every field of a single mustang
+func (repo *mustangRepository) Update(ctx context.Context, input *db.Mustang) error {
+	return repo.update(ctx, false, input)
+}
+
+// UpdateTx updates every field of a single mustang within a tx from ctx
+func (repo *mustangRepository) UpdateTx(ctx context.Context, input *db.Mustang) error {
+	return repo.update(ctx, true, input)
+}
+
+// update a mustang. a non zero input.Version must match the stored version or ErrVersionMismatch is returned
+func (repo *mustangRepository) update(ctx context.Context, useTx bool, input *db.Mustang) error {
+	errMsg := func() string { return "Error executing update mustang - " + fmt.Sprint(input) }
+
+	return repo.store.run(ctx, useTx, func(d *data) error {
+		r, err := writable(d, input.ID, input.Version)
+		if err != nil {
+			return errors.Wrap(err, errMsg())
+		}
+
+		d.saveMustang(r.mustang.ID)
+		r.mustang.Name = input.Name
+		r.mustang.Version++
+		d.record(db.EventMustangUpdated, r)
+		return nil
+	})
+}
+
+// Patch updates only the fields named by the field mask paths on a single mustang,
+// an empty set of paths updates every field
+func (repo *mustangRepository) Patch(ctx context.Context, input *db.Mustang, paths []string) error {
+	return repo.patch(ctx, false, input, paths)
+}
+
+// PatchTx updates only the fields named by the field mask paths within a tx from ctx
+func (repo *mustangRepository) PatchTx(ctx context.Context, input *db.Mustang, paths []string) error {
+	return repo.patch(ctx, true, input, paths)
+}
+
+// patch a mustang. a non zero input.Version must match the stored version or ErrVersionMismatch is returned
+func (repo *mustangRepository) patch(ctx context.Context, useTx bool, input *db.Mustang, paths []string) error {
+	errMsg := func() string { return "Error executing patch mustang - " + fmt.Sprint(input) + " " + fmt.Sprint(paths) }
+
+	if len(paths) == 0 {
+		return repo.update(ctx, useTx, input)
+	}
+
+	for _, p := range paths {
+		if _, ok := mustangPatchFields[p]; !ok {
+			return errors.Wrap(db.ErrInvalidField, errMsg())
+		}
+	}
+
+	return repo.store.run(ctx, useTx, func(d *data) error {
+		r, err := writable(d, input.ID, input.Version)
+		if err != nil {
+			return errors.Wrap(err, errMsg())
+		}
+
+		d.saveMustang(r.mustang.ID)
+		for _, p := range paths {
+			mustangPatchFields[p](&r.mustang, input)
+		}
+		r.mustang.Version++
+		d.record(db.EventMustangUpdated, r)
+		return nil
+	})
+}
+
+// Delete soft deletes a single mustang and returns it with its new version, a non zero version must
+// match the stored version
+func (repo *mustangRepository) Delete(ctx context.Context, ID uuid.UUID, version int64) (*db.Mustang, error) {
+	return repo.delete(ctx, false, ID, version)
+}
+
+// DeleteTx soft deletes a single mustang within a tx from ctx and returns it with its new version
+func (repo *mustangRepository) DeleteTx(ctx context.Context, ID uuid.UUID, version int64) (*db.Mustang, error) {
+	return repo.delete(ctx, true, ID, version)
+}
+
+// delete a mustang by marking it deleted and return it with its new version. a non zero version must
+// match the stored version or ErrVersionMismatch is returned
+func (repo *mustangRepository) delete(ctx context.Context, useTx bool, ID uuid.UUID, version int64) (*db.Mustang, error) {
+	errMsg := func() string { return "Error executing delete mustang - " + ID.String() }
+
+	var deleted db.Mustang
+	err := repo.store.run(ctx, useTx, func(d *data) error {
+		r, err := writable(d, ID, version)
+		if err != nil {
+			return errors.Wrap(err, errMsg())
+		}
+
+		d.saveMustang(ID)
+		r.deleted = true
+		r.mustang.Version++
+		d.record(db.EventMustangDeleted, r)
+		deleted = r.mustang
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &deleted, nil
+}
+
+// writable returns the live record for ID when version is 0 or matches it. a live record with
+// another version is ErrVersionMismatch and a missing or deleted one is ErrNotFound
+func writable(d *data, ID uuid.UUID, version int64) (*mustangRecord, error) {
+	r, ok := d.live(ID)
+	if !ok {
+		return nil, db.ErrNotFound
+	}
+	if version != 0 && r.mustang.Version != version {
+		return nil, db.ErrVersionMismatch
+	}
+	return r, nil
+}
+
+// Restore undoes the soft delete of a single mustang
+func (repo *mustangRepository) Restore(ctx context.Context, ID uuid.UUID) error {
+	return repo.restore(ctx, false, ID)
+}
+
+// RestoreTx undoes the soft delete of a single mustang within a tx from ctx
+func (repo *mustangRepository) RestoreTx(ctx context.Context, ID uuid.UUID) error {
+	return repo.restore(ctx, true, ID)
+}
+
+// restore a soft deleted mustang. restoring a mustang that was never deleted returns ErrNotDeleted
+func (repo *mustangRepository) restore(ctx context.Context, useTx bool, ID uuid.UUID) error {
+	errMsg := func() string { return "Error executing restore mustang - " + ID.String() }
+
+	return repo.store.run(ctx, useTx, func(d *data) error {
+		r, ok := d.mustangs[ID]
+		if !ok {
+			return errors.Wrap(db.ErrNotFound, errMsg())
+		}
+		if !r.deleted {
+			return errors.Wrap(db.ErrNotDeleted, errMsg())
+		}
+
+		d.saveMustang(ID)
+		r.deleted = false
+		r.mustang.Version++
+		d.record(db.EventMustangRestored, r)
+		return nil
+	})
+}
+
+// List fetches a page of mustangs following the given cursor, a nil cursor fetches the first page.
+// The returned cursor points at the last mustang of the page and is nil when there are no more pages
+func (repo *mustangRepository) List(ctx context.Context, limit int, after *db.Cursor) ([]*db.Mustang, *db.Cursor, error) {
+	return repo.list(ctx, false, limit, after)
+}
+
+// ListTx fetches a page of mustangs inside of a tx from ctx
+func (repo *mustangRepository) ListTx(ctx context.Context, limit int, after *db.Cursor) ([]*db.Mustang, *db.Cursor, error) {
+	return repo.list(ctx, true, limit, after)
+}
+
+// list fetches a page of mustangs ordered by (created_at, mustang_id) like the MySQL store
+func (repo *mustangRepository) list(ctx context.Context, useTx bool, limit int, after *db.Cursor) ([]*db.Mustang, *db.Cursor, error) {
+	var records []mustangRecord
+	err := repo.store.run(ctx, useTx, func(d *data) error {
+		for _, r := range d.mustangs {
+			if !r.deleted && (after == nil || follows(r, after)) {
+				records = append(records, *r)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		return follows(&records[j], &db.Cursor{CreatedAt: records[i].createdAt, ID: records[i].mustang.ID})
+	})
+
+	var next *db.Cursor
+	if len(records) > limit {
+		records = records[:limit]
+		last := records[limit-1]
+		next = &db.Cursor{CreatedAt: last.createdAt, ID: last.mustang.ID}
+	}
+
+	mustangs := make([]*db.Mustang, 0, len(records))
+	for i := range records {
+		mustangs = append(mustangs, &records[i].mustang)
+	}
+
+	return mustangs, next, nil
+}
+
+// follows reports whether r sorts after the cursor, comparing IDs byte wise like BINARY(16)
+func follows(r *mustangRecord, c *db.Cursor) bool {
+	if !r.createdAt.Equal(c.CreatedAt) {
+		return r.createdAt.After(c.CreatedAt)
+	}
+	return bytes.Compare(r.mustang.ID[:], c.ID[:]) > 0
+}
+
+// BatchGet fetches all mustangs matching the given IDs, IDs that do not match
+// a mustang are left out of the result
+func (repo *mustangRepository) BatchGet(ctx context.Context, IDs []uuid.UUID) ([]*db.Mustang, error) {
+	return repo.batchGet(ctx, false, IDs)
+}
+
+// BatchGetTx fetches all mustangs matching the given IDs inside of a tx from ctx
+func (repo *mustangRepository) BatchGetTx(ctx context.Context, IDs []uuid.UUID) ([]*db.Mustang, error) {
+	return repo.batchGet(ctx, true, IDs)
+}
+
+// batchGet fetches all mustangs matching the given IDs
+func (repo *mustangRepository) batchGet(ctx context.Context, useTx bool, IDs []uuid.UUID) ([]*db.Mustang, error) {
+	errMsg := func() string { return "Error executing batch get mustangs - " + fmt.Sprint(IDs) }
+
+	if len(IDs) == 0 {
+		return []*db.Mustang{}, nil
+	}
+	if len(IDs) > db.MaxBatchSize {
+		return nil, errors.Wrap(db.ErrBatchTooLarge, errMsg())
+	}
+
+	mustangs := make([]*db.Mustang, 0, len(IDs))
+	err := repo.store.run(ctx, useTx, func(d *data) error {
+		seen := make(map[uuid.UUID]bool, len(IDs))
+		for _, ID := range IDs {
+			if seen[ID] {
+				continue
+			}
+			seen[ID] = true
+
+			if r, ok := d.live(ID); ok {
+				m := r.mustang
+				mustangs = append(mustangs, &m)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return mustangs, nil
+}
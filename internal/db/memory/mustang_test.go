@@ -0,0 +1,114 @@
+package memory
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/caring/ford-mustang/internal/db"
+)
+
+func TestMustangRepository_lifecycle(t *testing.T) {
+	ctx := context.Background()
+	mustangID := uuid.MustParse("72bc87f3-4a9f-4d05-93fe-844d3cd94c65")
+	store := NewStore()
+
+	m := &db.Mustang{ID: mustangID, Name: "Foobar"}
+	err := store.Mustang.Create(ctx, m)
+	assert.NoError(t, err, "Expecting no create error")
+	assert.Equal(t, int64(1), m.Version, "Expected new mustangs to start at version 1")
+
+	err = store.Mustang.Create(ctx, m)
+	assert.ErrorIs(t, err, db.ErrDuplicate, "Expected a duplicate ID to be rejected")
+
+	err = store.Mustang.Update(ctx, &db.Mustang{ID: mustangID, Name: "Bazqux", Version: 3})
+	assert.ErrorIs(t, err, db.ErrVersionMismatch, "Expected a stale version to be rejected")
+
+	err = store.Mustang.Patch(ctx, &db.Mustang{ID: mustangID, Name: "Bazqux", Version: 1}, []string{"name"})
+	assert.NoError(t, err, "Expecting no patch error")
+
+	err = store.Mustang.Patch(ctx, &db.Mustang{ID: mustangID}, []string{"color"})
+	assert.ErrorIs(t, err, db.ErrInvalidField, "Expected an unknown path to be rejected")
+
+	r, err := store.Mustang.Get(ctx, mustangID)
+	assert.NoError(t, err, "Expecting no get error")
+	assert.Equal(t, "Bazqux", r.Name, "Expected the patched name")
+	assert.Equal(t, int64(2), r.Version, "Expected the write to bump the version")
+
+	err = store.Mustang.Restore(ctx, mustangID)
+	assert.ErrorIs(t, err, db.ErrNotDeleted, "Expected restoring a live mustang to fail")
+
+	deleted, err := store.Mustang.Delete(ctx, mustangID, 2)
+	assert.NoError(t, err, "Expecting no delete error")
+	assert.Equal(t, int64(3), deleted.Version, "Expected the deleted mustang with its new version")
+
+	_, err = store.Mustang.Get(ctx, mustangID)
+	assert.ErrorIs(t, err, db.ErrNotFound, "Expected deleted mustangs to be hidden")
+
+	_, err = store.Mustang.Delete(ctx, mustangID, 0)
+	assert.ErrorIs(t, err, db.ErrNotFound, "Expected deleting twice to fail")
+
+	err = store.Mustang.Update(ctx, &db.Mustang{ID: mustangID, Name: "Foobar"})
+	assert.ErrorIs(t, err, db.ErrNotFound, "Expected updating a deleted mustang to fail")
+
+	err = store.Mustang.Restore(ctx, mustangID)
+	assert.NoError(t, err, "Expecting no restore error")
+
+	r, err = store.Mustang.Get(ctx, mustangID)
+	assert.NoError(t, err, "Expected the restored mustang to be found")
+	assert.Equal(t, int64(4), r.Version, "Expected delete and restore to bump the version")
+}
+
+func TestMustangRepository_list(t *testing.T) {
+	ctx := context.Background()
+	store := NewStore()
+	createdAt := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	store.now = func() time.Time { return createdAt }
+
+	IDs := []uuid.UUID{
+		uuid.MustParse("10000000-0000-0000-0000-000000000000"),
+		uuid.MustParse("20000000-0000-0000-0000-000000000000"),
+		uuid.MustParse("30000000-0000-0000-0000-000000000000"),
+	}
+	// created in reverse so the order comes from the ID tie break
+	for i := len(IDs) - 1; i >= 0; i-- {
+		assert.NoError(t, store.Mustang.Create(ctx, &db.Mustang{ID: IDs[i], Name: "Foobar"}), "Expecting no create error")
+	}
+	_, err := store.Mustang.Delete(ctx, IDs[1], 0)
+	assert.NoError(t, err, "Expecting no delete error")
+
+	page, next, err := store.Mustang.List(ctx, 1, nil)
+	assert.NoError(t, err, "Expecting no list error")
+	if assert.Len(t, page, 1, "Expected a full first page") {
+		assert.Equal(t, IDs[0], page[0].ID, "Expected the first mustang")
+	}
+	assert.NotNil(t, next, "Expected a cursor to the next page")
+
+	page, next, err = store.Mustang.List(ctx, 1, next)
+	assert.NoError(t, err, "Expecting no list error")
+	if assert.Len(t, page, 1, "Expected the last page") {
+		assert.Equal(t, IDs[2], page[0].ID, "Expected deleted mustangs to be skipped")
+	}
+	assert.Nil(t, next, "Expected no cursor on the last page")
+}
+
+func TestMustangRepository_batchGet(t *testing.T) {
+	ctx := context.Background()
+	store := NewStore()
+	found := uuid.MustParse("72bc87f3-4a9f-4d05-93fe-844d3cd94c65")
+	missing := uuid.MustParse("94cc5321-ec44-464f-9008-3d81f5e2c18f")
+
+	assert.NoError(t, store.Mustang.Create(ctx, &db.Mustang{ID: found, Name: "Foobar"}), "Expecting no create error")
+
+	r, err := store.Mustang.BatchGet(ctx, []uuid.UUID{found, missing, found})
+	assert.NoError(t, err, "Expecting no batch get error")
+	if assert.Len(t, r, 1, "Expected only the stored mustang once") {
+		assert.Equal(t, found, r[0].ID, "Expected the stored mustang")
+	}
+
+	_, err = store.Mustang.BatchGet(ctx, make([]uuid.UUID, db.MaxBatchSize+1))
+	assert.ErrorIs(t, err, db.ErrBatchTooLarge, "Expected an oversized batch to be rejected")
+}
@@ -0,0 +1,219 @@
+// Package memory is an in memory storage backend for local development and tests
+// that need a working store without a database
+package memory
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+
+	"github.com/caring/go-packages/pkg/errors"
+	"github.com/google/uuid"
+
+	"github.com/caring/ford-mustang/internal/db"
+)
+
+type txCtxKey struct{}
+
+// Store holds every record in memory and is safe for concurrent use
+type Store struct {
+	mu      sync.Mutex
+	data    *data
+	now     func() time.Time
+	changes *db.Broadcaster
+	// idempotencyTTL is how long an idempotency key is kept
+	idempotencyTTL time.Duration
+
+	Mustang *mustangRepository
+}
+
+var _ db.Backend = (*Store)(nil)
+
+// Option configures optional behaviour of a Store
+type Option func(*Store)
+
+// WithBroadcaster publishes every committed mustang change to b
+func WithBroadcaster(b *db.Broadcaster) Option {
+	return func(s *Store) {
+		s.changes = b
+	}
+}
+
+// WithIdempotencyTTL sets how long an idempotency key and the result of the request
+// that first used it are kept, like db.WithIdempotencyTTL does for the MySQL store
+func WithIdempotencyTTL(ttl time.Duration) Option {
+	return func(s *Store) {
+		s.idempotencyTTL = ttl
+	}
+}
+
+// data is the stored records
+type data struct {
+	mustangs        map[uuid.UUID]*mustangRecord
+	idempotencyKeys map[idempotencyKey]*idempotencyRecord
+	// changes are the mustang changes made that are yet to be published
+	changes []db.Change
+	// undo restores the records as they were before each change made, oldest first. it is
+	// kept for the length of a tx and cleared once the changes can no longer be undone
+	undo []func()
+}
+
+// saveMustang adds restoring the mustang stored under ID to the undo log, it is called
+// before the mustang is created or changed so only the records a tx touches are copied
+func (d *data) saveMustang(ID uuid.UUID) {
+	r, ok := d.mustangs[ID]
+	if !ok {
+		d.undo = append(d.undo, func() { delete(d.mustangs, ID) })
+		return
+	}
+	saved := *r
+	d.undo = append(d.undo, func() { d.mustangs[ID] = &saved })
+}
+
+// saveIdempotencyKey adds restoring the result stored with k to the undo log, it is called
+// before the key is stored or replaced
+func (d *data) saveIdempotencyKey(k idempotencyKey) {
+	r, ok := d.idempotencyKeys[k]
+	if !ok {
+		d.undo = append(d.undo, func() { delete(d.idempotencyKeys, k) })
+		return
+	}
+	saved := *r
+	d.undo = append(d.undo, func() { d.idempotencyKeys[k] = &saved })
+}
+
+// savepoint marks the undo log and change queue so the changes made after it can be rolled back
+type savepoint struct {
+	undo    int
+	changes int
+}
+
+// savepoint gives a savepoint at the changes made so far
+func (d *data) savepoint() savepoint {
+	return savepoint{undo: len(d.undo), changes: len(d.changes)}
+}
+
+// rollback undoes the changes made since sp, latest first, and drops their queued changes
+func (d *data) rollback(sp savepoint) {
+	for i := len(d.undo) - 1; i >= sp.undo; i-- {
+		d.undo[i]()
+	}
+	d.undo = d.undo[:sp.undo]
+	d.changes = d.changes[:sp.changes]
+}
+
+// record queues a change to the mustang in r to be published once d is committed
+func (d *data) record(eventType string, r *mustangRecord) {
+	d.changes = append(d.changes, db.Change{EventType: eventType, Mustang: r.mustang})
+}
+
+// publish sends the changes queued in d to the broadcaster and clears them, the caller
+// must hold the store's lock so changes are published in the order they were committed
+func (s *Store) publish(d *data) {
+	s.changes.Publish(d.changes...)
+	d.changes = nil
+}
+
+// tx is a transaction on the store's data, it holds the store's lock until it finishes
+type tx struct {
+	data *data
+}
+
+// NewStore gives an empty in memory store
+func NewStore(opts ...Option) *Store {
+	s := &Store{
+		data: &data{
+			mustangs:        map[uuid.UUID]*mustangRecord{},
+			idempotencyKeys: map[idempotencyKey]*idempotencyRecord{},
+		},
+		now:            func() time.Time { return time.Now().UTC() },
+		idempotencyTTL: db.DefaultIdempotencyTTL,
+	}
+	s.Mustang = &mustangRepository{store: s}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
+}
+
+// Mustangs returns the in memory mustang repository
+func (s *Store) Mustangs() db.MustangRepository {
+	return s.Mustang
+}
+
+// Ping always succeeds, there is nothing to connect to
+func (s *Store) Ping(ctx context.Context) error {
+	return nil
+}
+
+// Close is a no op, the data is dropped with the store
+func (s *Store) Close() error {
+	return nil
+}
+
+// WithTx runs fn inside of a transaction the same way db.Store.WithTx does. fn changes the
+// store's data in place and records how to undo each change, the changes are kept when fn
+// returns nil and undone when fn returns an error or panics. Transactions are serialized, calls
+// made from other goroutines wait until the transaction finishes.
+// When ctx already carries a tx only the work done by fn is undone on failure, like a savepoint.
+// The mustang changes made by fn are published to the store's broadcaster on commit. opts are ignored
+func (s *Store) WithTx(ctx context.Context, opts *sql.TxOptions, fn func(ctx context.Context) error) error {
+	if t, ok := ctx.Value(txCtxKey{}).(*tx); ok {
+		return t.run(ctx, fn)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t := &tx{data: s.data}
+	if err := t.run(context.WithValue(ctx, txCtxKey{}, t), fn); err != nil {
+		return err
+	}
+
+	s.data.undo = nil
+	s.publish(s.data)
+	return nil
+}
+
+// run calls fn inside of t, undoing the changes fn made on an error or panic
+func (t *tx) run(ctx context.Context, fn func(ctx context.Context) error) (err error) {
+	sp := t.data.savepoint()
+
+	defer func() {
+		if p := recover(); p != nil {
+			t.data.rollback(sp)
+			panic(p)
+		}
+	}()
+
+	if err = fn(ctx); err != nil {
+		t.data.rollback(sp)
+		return err
+	}
+
+	return nil
+}
+
+// run calls fn with the data of the tx in ctx, or with the store's data under its lock when
+// there is no tx. when useTx is set a tx is required and its absence is an error
+func (s *Store) run(ctx context.Context, useTx bool, fn func(d *data) error) error {
+	if t, ok := ctx.Value(txCtxKey{}).(*tx); ok {
+		return fn(t.data)
+	}
+	if useTx {
+		return errors.New("No memory tx present in context")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// writes outside of a tx apply straight to the store's data, fn only queues
+	// changes once it can no longer fail so they are published as soon as it returns
+	err := fn(s.data)
+	s.data.undo = nil
+	s.publish(s.data)
+	return err
+}
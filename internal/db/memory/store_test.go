@@ -0,0 +1,147 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/caring/ford-mustang/internal/db"
+)
+
+func TestStore_WithTx(t *testing.T) {
+	mustangID := uuid.MustParse("72bc87f3-4a9f-4d05-93fe-844d3cd94c65")
+	errFailed := errors.New("failed")
+
+	// ensures the work done in fn is kept when it succeeds
+	t.Run("Commits on success", func(t *testing.T) {
+		store := NewStore()
+
+		err := store.WithTx(context.Background(), nil, func(ctx context.Context) error {
+			return store.Mustang.CreateTx(ctx, &db.Mustang{ID: mustangID, Name: "Foobar"})
+		})
+		assert.NoError(t, err, "Expecting no error")
+
+		_, err = store.Mustang.Get(context.Background(), mustangID)
+		assert.NoError(t, err, "Expected the created mustang to be committed")
+	})
+
+	// ensures the work done in fn is thrown away when it fails
+	t.Run("Rolls back on error", func(t *testing.T) {
+		store := NewStore()
+
+		err := store.WithTx(context.Background(), nil, func(ctx context.Context) error {
+			if err := store.Mustang.Create(ctx, &db.Mustang{ID: mustangID, Name: "Foobar"}); err != nil {
+				return err
+			}
+			return errFailed
+		})
+		assert.ErrorIs(t, err, errFailed, "Expecting the error from fn")
+
+		_, err = store.Mustang.Get(context.Background(), mustangID)
+		assert.ErrorIs(t, err, db.ErrNotFound, "Expected the created mustang to be rolled back")
+	})
+
+	// ensures the work done in fn is thrown away when it panics
+	t.Run("Rolls back on panic", func(t *testing.T) {
+		store := NewStore()
+
+		assert.Panics(t, func() {
+			store.WithTx(context.Background(), nil, func(ctx context.Context) error {
+				store.Mustang.Create(ctx, &db.Mustang{ID: mustangID, Name: "Foobar"})
+				panic("boom")
+			})
+		}, "Expected the panic to be re-raised")
+
+		_, err := store.Mustang.Get(context.Background(), mustangID)
+		assert.ErrorIs(t, err, db.ErrNotFound, "Expected the created mustang to be rolled back")
+	})
+
+	// ensures changes to stored records are undone in reverse, leaving them as they were
+	t.Run("Rolls back changes", func(t *testing.T) {
+		store := NewStore()
+		assert.NoError(t, store.Mustang.Create(context.Background(), &db.Mustang{ID: mustangID, Name: "Foobar"}), "Expecting no create error")
+
+		err := store.WithTx(context.Background(), nil, func(ctx context.Context) error {
+			if err := store.Mustang.Update(ctx, &db.Mustang{ID: mustangID, Name: "Bazqux"}); err != nil {
+				return err
+			}
+			if _, err := store.Mustang.Delete(ctx, mustangID, 0); err != nil {
+				return err
+			}
+			return errFailed
+		})
+		assert.ErrorIs(t, err, errFailed, "Expecting the error from fn")
+
+		m, err := store.Mustang.Get(context.Background(), mustangID)
+		assert.NoError(t, err, "Expected the delete to be rolled back")
+		assert.Equal(t, &db.Mustang{ID: mustangID, Name: "Foobar", Version: 1}, m, "Expected the update to be rolled back")
+	})
+
+	// ensures a failed nested tx only undoes its own work
+	t.Run("Nested rollback", func(t *testing.T) {
+		store := NewStore()
+		otherID := uuid.MustParse("94cc5321-ec44-464f-9008-3d81f5e2c18f")
+
+		err := store.WithTx(context.Background(), nil, func(ctx context.Context) error {
+			if err := store.Mustang.Create(ctx, &db.Mustang{ID: mustangID, Name: "Foobar"}); err != nil {
+				return err
+			}
+
+			nestedErr := store.WithTx(ctx, nil, func(ctx context.Context) error {
+				if err := store.Mustang.Create(ctx, &db.Mustang{ID: otherID, Name: "Bazqux"}); err != nil {
+					return err
+				}
+				return errFailed
+			})
+			assert.ErrorIs(t, nestedErr, errFailed, "Expecting the error from the nested fn")
+
+			return nil
+		})
+		assert.NoError(t, err, "Expecting no error")
+
+		_, err = store.Mustang.Get(context.Background(), mustangID)
+		assert.NoError(t, err, "Expected the outer work to be committed")
+		_, err = store.Mustang.Get(context.Background(), otherID)
+		assert.ErrorIs(t, err, db.ErrNotFound, "Expected the nested work to be rolled back")
+	})
+
+	// ensures Tx variants require a tx
+	t.Run("Tx method without a tx", func(t *testing.T) {
+		store := NewStore()
+
+		_, err := store.Mustang.GetTx(context.Background(), mustangID)
+		assert.Error(t, err, "Expected an error without a tx in ctx")
+	})
+}
+
+func TestStore_broadcast(t *testing.T) {
+	mustangID := uuid.MustParse("72bc87f3-4a9f-4d05-93fe-844d3cd94c65")
+
+	// ensures committed changes are published and rolled back ones are not
+	b := db.NewBroadcaster(10, 10)
+	store := NewStore(WithBroadcaster(b))
+	sub, _ := b.Subscribe("")
+
+	assert.NoError(t, store.Mustang.Create(context.Background(), &db.Mustang{ID: mustangID, Name: "Foobar"}), "Expecting no create error")
+
+	err := store.WithTx(context.Background(), nil, func(ctx context.Context) error {
+		if _, err := store.Mustang.Delete(ctx, mustangID, 0); err != nil {
+			return err
+		}
+		return errors.New("failed")
+	})
+	assert.Error(t, err, "Expecting the error from fn")
+
+	_, err = store.Mustang.Delete(context.Background(), mustangID, 1)
+	assert.NoError(t, err, "Expecting no delete error")
+
+	var events []string
+	for len(sub.Changes()) > 0 {
+		c := <-sub.Changes()
+		events = append(events, c.EventType)
+	}
+	assert.Equal(t, []string{db.EventMustangCreated, db.EventMustangDeleted}, events, "Expected only the committed changes")
+}
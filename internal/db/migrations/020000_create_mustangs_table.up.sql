@@ -0,0 +1,26 @@
+--
+-- Microservice: Ford Mustang Service
+--
+-- Switch to using products schema
+USE products;
+
+CREATE TABLE mustangs (
+  mustang_id      BINARY(16) NOT NULL PRIMARY KEY,
+  mustang_id_text VARCHAR(36) generated always AS
+   (insert(
+      insert(
+        insert(
+          insert(hex(mustang_id),9,0,'-'),
+          14,0,'-'),
+        19,0,'-'),
+      24,0,'-')
+   ) virtual,
+  name            varchar(64) NOT NULL,
+  created_at      DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  updated_at      DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+  deleted_at      DATETIME,
+  INDEX idx__mustangs__created_at (created_at, mustang_id)
+)
+ENGINE=InnoDB
+DEFAULT CHARSET=utf8mb4
+COMMENT='Mustangs managed by the ford-mustang service';
@@ -0,0 +1,7 @@
+USE products;
+
+ALTER TABLE mustangs DROP INDEX idx__mustangs__deleted_at;
+
+ALTER TABLE products DROP INDEX idx__products__deleted_at;
+
+ALTER TABLE categories DROP INDEX idx__categories__deleted_at;
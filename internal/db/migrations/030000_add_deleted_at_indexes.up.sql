@@ -0,0 +1,10 @@
+--
+-- Index deleted_at so the purger can find expired soft deleted rows without a table scan
+--
+USE products;
+
+ALTER TABLE categories ADD INDEX idx__categories__deleted_at (deleted_at);
+
+ALTER TABLE products ADD INDEX idx__products__deleted_at (deleted_at);
+
+ALTER TABLE mustangs ADD INDEX idx__mustangs__deleted_at (deleted_at);
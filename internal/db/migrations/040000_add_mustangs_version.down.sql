@@ -0,0 +1,3 @@
+USE products;
+
+ALTER TABLE mustangs DROP COLUMN version;
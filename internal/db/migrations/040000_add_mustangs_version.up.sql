@@ -0,0 +1,6 @@
+--
+-- Version mustangs for optimistic concurrency control, every write increments the version
+--
+USE products;
+
+ALTER TABLE mustangs ADD COLUMN version BIGINT UNSIGNED NOT NULL DEFAULT 1 AFTER name;
@@ -0,0 +1,18 @@
+--
+-- Transactional outbox, change events are written in the same transaction as the change
+-- and relayed to downstream consumers afterwards
+--
+USE products;
+
+CREATE TABLE outbox (
+  outbox_id       BIGINT UNSIGNED NOT NULL AUTO_INCREMENT PRIMARY KEY,
+  event_type      VARCHAR(64) NOT NULL,
+  aggregate_id    BINARY(16) NOT NULL,
+  payload         JSON NOT NULL,
+  created_at      DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  sent_at         DATETIME,
+  INDEX idx__outbox__sent_at (sent_at, outbox_id)
+)
+ENGINE=InnoDB
+DEFAULT CHARSET=utf8mb4
+COMMENT='Change events waiting to be published';
@@ -0,0 +1,20 @@
+--
+-- Audit history, every change to a mustang appends an immutable row recording
+-- who made it and the mustang before and after. The rows are purged along with
+-- their mustang
+--
+USE products;
+
+CREATE TABLE mustang_history (
+  history_id      BIGINT UNSIGNED NOT NULL AUTO_INCREMENT PRIMARY KEY,
+  mustang_id      BINARY(16) NOT NULL,
+  operation       VARCHAR(16) NOT NULL,
+  actor           VARCHAR(255) NOT NULL,
+  before_state    JSON,
+  after_state     JSON NOT NULL,
+  created_at      DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  INDEX idx__mustang_history__mustang_id (mustang_id, history_id)
+)
+ENGINE=InnoDB
+DEFAULT CHARSET=utf8mb4
+COMMENT='Changes made to mustangs, rows are never updated and are purged with their mustang';
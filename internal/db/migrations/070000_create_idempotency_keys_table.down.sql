@@ -0,0 +1,3 @@
+USE products;
+
+DROP TABLE IF EXISTS idempotency_keys;
@@ -0,0 +1,21 @@
+--
+-- Idempotency keys, the result of a create is kept under the key the client sent
+-- so a retried request returns it rather than creating again. Keys are scoped to
+-- the actor that sent them, so two clients picking the same key don't get each
+-- other's results
+--
+USE products;
+
+CREATE TABLE idempotency_keys (
+  actor           VARCHAR(255) NOT NULL DEFAULT 'unknown',
+  idempotency_key VARCHAR(255) NOT NULL,
+  request_hash    CHAR(64) NOT NULL,
+  response        JSON NOT NULL,
+  created_at      DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  expires_at      DATETIME NOT NULL,
+  PRIMARY KEY (actor, idempotency_key),
+  INDEX idx__idempotency_keys__expires_at (expires_at)
+)
+ENGINE=InnoDB
+DEFAULT CHARSET=utf8mb4
+COMMENT='Results of idempotent requests, kept until they expire';
@@ -0,0 +1,25 @@
+--
+-- Microservice: Product Dictionary Service
+--
+-- SQLite variant of the initial tables, UUIDs are stored as TEXT
+CREATE TABLE categories (
+  category_id     TEXT NOT NULL PRIMARY KEY,
+  name            VARCHAR(64) NOT NULL,
+  created_at      DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  updated_at      DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  deleted_at      DATETIME,
+  CONSTRAINT uq__categories__name UNIQUE (name)
+);
+
+CREATE TABLE products (
+  product_id            TEXT NOT NULL PRIMARY KEY,
+  category_id           TEXT NOT NULL,
+  name                  VARCHAR(64) NOT NULL,
+  created_at            DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  updated_at            DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  deleted_at            DATETIME,
+  CONSTRAINT uq__products__name UNIQUE (name),
+  CONSTRAINT fk__products__category_id FOREIGN KEY (category_id) REFERENCES categories (category_id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx__products__product_id_category_id ON products (product_id, category_id);
@@ -0,0 +1,13 @@
+--
+-- Microservice: Ford Mustang Service
+--
+-- SQLite variant of the mustangs table, updated_at is kept current by the statements
+CREATE TABLE mustangs (
+  mustang_id      TEXT NOT NULL PRIMARY KEY,
+  name            VARCHAR(64) NOT NULL,
+  created_at      DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  updated_at      DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  deleted_at      DATETIME
+);
+
+CREATE INDEX idx__mustangs__created_at ON mustangs (created_at, mustang_id);
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx__mustangs__deleted_at;
+
+DROP INDEX IF EXISTS idx__products__deleted_at;
+
+DROP INDEX IF EXISTS idx__categories__deleted_at;
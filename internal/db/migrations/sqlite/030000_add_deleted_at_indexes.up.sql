@@ -0,0 +1,8 @@
+--
+-- Index deleted_at so the purger can find expired soft deleted rows without a table scan
+--
+CREATE INDEX idx__categories__deleted_at ON categories (deleted_at);
+
+CREATE INDEX idx__products__deleted_at ON products (deleted_at);
+
+CREATE INDEX idx__mustangs__deleted_at ON mustangs (deleted_at);
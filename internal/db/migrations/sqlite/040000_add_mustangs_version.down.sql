@@ -0,0 +1 @@
+ALTER TABLE mustangs DROP COLUMN version;
@@ -0,0 +1,4 @@
+--
+-- Version mustangs for optimistic concurrency control, every write increments the version
+--
+ALTER TABLE mustangs ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
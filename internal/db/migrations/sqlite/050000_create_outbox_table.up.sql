@@ -0,0 +1,14 @@
+--
+-- Transactional outbox, change events are written in the same transaction as the change
+-- and relayed to downstream consumers afterwards
+--
+CREATE TABLE outbox (
+  outbox_id       INTEGER PRIMARY KEY AUTOINCREMENT,
+  event_type      VARCHAR(64) NOT NULL,
+  aggregate_id    TEXT NOT NULL,
+  payload         TEXT NOT NULL,
+  created_at      DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  sent_at         DATETIME
+);
+
+CREATE INDEX idx__outbox__sent_at ON outbox (sent_at, outbox_id);
@@ -0,0 +1,16 @@
+--
+-- Audit history, every change to a mustang appends an immutable row recording
+-- who made it and the mustang before and after. The rows are purged along with
+-- their mustang
+--
+CREATE TABLE mustang_history (
+  history_id      INTEGER PRIMARY KEY AUTOINCREMENT,
+  mustang_id      TEXT NOT NULL,
+  operation       VARCHAR(16) NOT NULL,
+  actor           VARCHAR(255) NOT NULL,
+  before_state    TEXT,
+  after_state     TEXT NOT NULL,
+  created_at      DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx__mustang_history__mustang_id ON mustang_history (mustang_id, history_id);
@@ -4,6 +4,9 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/caring/go-packages/pkg/errors"
 	"github.com/google/uuid"
@@ -11,18 +14,23 @@ import (
 	"github.com/caring/ford-mustang/pb"
 )
 
-
-
 // mustangService provides an API for interacting with the mustangs table
 type mustangService struct {
-	db    *sql.DB
-	stmts map[string]*sql.Stmt
+	db       *sql.DB
+	stmts    map[string]*sql.Stmt
+	dialect  *dialect
+	replicas *replicaSet
+	// withTx starts a transaction for mutations made without one, see Store.WithTx
+	withTx func(ctx context.Context, opts *sql.TxOptions, fn func(ctx context.Context) error) error
+	// idempotencyTTL is how long idempotency keys are kept
+	idempotencyTTL time.Duration
 }
 
 // Mustang is a struct representation of a row in the mustangs table
 type Mustang struct {
-	ID  	uuid.UUID
-	Name  string
+	ID      uuid.UUID
+	Name    string
+	Version int64
 }
 
 // protoMustang is an interface that most proto mustang objects will satisfy
@@ -30,6 +38,11 @@ type protoMustang interface {
 	GetName() string
 }
 
+// protoVersioned is satisfied by proto mustang objects that carry the version a write expects
+type protoVersioned interface {
+	GetVersion() int64
+}
+
 // NewMustang is a convenience helper cast a proto mustang to it's DB layer struct
 func NewMustang(ID string, proto protoMustang) (*Mustang, error) {
 	mID, err := ParseUUID(ID)
@@ -37,17 +50,24 @@ func NewMustang(ID string, proto protoMustang) (*Mustang, error) {
 		return nil, err
 	}
 
-	return &Mustang{
-		ID:  	mID,
+	m := &Mustang{
+		ID:   mID,
 		Name: proto.GetName(),
-	}, nil
+	}
+
+	if v, ok := proto.(protoVersioned); ok {
+		m.Version = v.GetVersion()
+	}
+
+	return m, nil
 }
 
 // ToProto casts a db mustang into a proto response object
 func (m *Mustang) ToProto() *pb.MustangResponse {
 	return &pb.MustangResponse{
-		Id:  				m.ID.String(),
-		Name:       m.Name,
+		Id:      m.ID.String(),
+		Name:    m.Name,
+		Version: m.Version,
 	}
 }
 
@@ -65,27 +85,15 @@ func (svc *mustangService) GetTx(ctx context.Context, ID uuid.UUID) (*Mustang, e
 func (svc *mustangService) get(ctx context.Context, useTx bool, ID uuid.UUID) (*Mustang, error) {
 	errMsg := func() string { return "Error executing get mustang - " + fmt.Sprint(ID) }
 
-	var (
-		stmt *sql.Stmt
-		err  error
-		tx   *sql.Tx
-	)
-
-	if useTx {
-
-		if tx, err = FromCtx(ctx); err != nil {
-			return nil, err
-		}
-
-		stmt = tx.Stmt(svc.stmts["get-mustang"])
-	} else {
-		stmt = svc.stmts["get-mustang"]
+	stmt, err := svc.replicas.readStmt(ctx, useTx, "get-mustang", svc.stmts)
+	if err != nil {
+		return nil, err
 	}
 
-	p := Mustang{}
+	m := Mustang{}
 
 	err = stmt.QueryRowContext(ctx, ID).
-		Scan(&m.MustangID, &m.Name)
+		Scan(&m.ID, &m.Name, &m.Version)
 	if err != nil {
 
 		if errors.Is(err, sql.ErrNoRows) {
@@ -95,7 +103,7 @@ func (svc *mustangService) get(ctx context.Context, useTx bool, ID uuid.UUID) (*
 		return nil, errors.Wrap(err, errMsg())
 	}
 
-	return &p, nil
+	return &m, nil
 }
 
 // Create a new mustang
@@ -109,42 +117,39 @@ func (svc *mustangService) CreateTx(ctx context.Context, input *Mustang) error {
 }
 
 // create a new mustang. if useTx = true then it will attempt to create the mustang within a transaction
-// from context.
+// from context. the mustang.created event is written to the outbox and audit history in the same transaction
 func (svc *mustangService) create(ctx context.Context, useTx bool, input *Mustang) error {
 	errMsg := func() string { return "Error executing create mustang - " + fmt.Sprint(input) }
 
-	var (
-		stmt *sql.Stmt
-		err  error
-		tx   *sql.Tx
-	)
-
-	if useTx {
-
-		if tx, err = FromCtx(ctx); err != nil {
+	return svc.inTx(ctx, useTx, func(ctx context.Context) error {
+		stmt, err := txStmt(ctx, useTx, svc.stmts["create-mustang"])
+		if err != nil {
 			return err
 		}
 
-		stmt = tx.Stmt(svc.stmts["create-mustang"])
-	} else {
-		stmt = svc.stmts["create-mustang"]
-	}
+		result, err := stmt.ExecContext(ctx, input.ID, input.Name)
+		if err != nil {
+			return errors.Wrap(err, errMsg())
+		}
 
-	result, err := stmt.ExecContext(ctx, input.MustangID, input.Name)
-	if err != nil {
-		return errors.Wrap(err, errMsg())
-	}
+		rowCount, err := result.RowsAffected()
+		if err != nil {
+			return errors.Wrap(err, errMsg())
+		}
 
-	rowCount, err := result.RowsAffected()
-	if err != nil {
-		return errors.Wrap(err, errMsg())
-	}
+		if rowCount == 0 {
+			return errors.Wrap(ErrNotCreated, errMsg())
+		}
 
-	if rowCount == 0 {
-		return errors.Wrap(ErrNotCreated, errMsg())
-	}
+		if _, err = svc.recordEvent(ctx, EventMustangCreated, input.ID, nil); err != nil {
+			return err
+		}
 
-	return nil
+		// new rows start at the column default
+		input.Version = 1
+
+		return nil
+	})
 }
 
 // Update updates a single mustang row in the DB
@@ -158,90 +163,438 @@ func (svc *mustangService) UpdateTx(ctx context.Context, input *Mustang) error {
 }
 
 // update a mustang. if useTx = true then it will attempt to update the mustang within a transaction
-// from context.
+// from context. a non zero input.Version must match the stored version or ErrVersionMismatch is returned,
+// a missing or deleted mustang is ErrNotFound.
+// the mustang.updated event is written to the outbox and audit history in the same transaction
 func (svc *mustangService) update(ctx context.Context, useTx bool, input *Mustang) error {
 	errMsg := func() string { return "Error executing update mustang - " + fmt.Sprint(input) }
 
-	var (
-		stmt *sql.Stmt
-		err  error
-		tx   *sql.Tx
-	)
+	return svc.inTx(ctx, useTx, func(ctx context.Context) error {
+		stmt, err := txStmt(ctx, useTx, svc.stmts["update-mustang"])
+		if err != nil {
+			return err
+		}
+		versionStmt, err := txStmt(ctx, useTx, svc.stmts["get-mustang-version"])
+		if err != nil {
+			return err
+		}
+
+		before, err := svc.snapshot(ctx, input.ID)
+		if err != nil {
+			return errors.Wrap(err, errMsg())
+		}
+		// the snapshot locks the row, a missing or deleted mustang stays that way until the write
+		if before == nil || before.Deleted {
+			return errors.Wrap(ErrNotFound, errMsg())
+		}
+
+		result, err := stmt.ExecContext(ctx, input.Name, input.ID, input.Version, input.Version)
+		if err != nil {
+			return errors.Wrap(err, errMsg())
+		}
+
+		rowCount, err := result.RowsAffected()
+		if err != nil {
+			return errors.Wrap(err, errMsg())
+		}
+
+		if rowCount == 0 {
+			if err = checkVersion(ctx, versionStmt, input.ID, input.Version); err != nil {
+				return errors.Wrap(err, errMsg())
+			}
+			return errors.Wrap(ErrNoRowsAffected, errMsg())
+		}
+
+		_, err = svc.recordEvent(ctx, EventMustangUpdated, input.ID, before)
+		return err
+	})
+}
+
+// mustangPatchColumns maps the field mask paths a patch may name to the column
+// they write and the value written from the input
+var mustangPatchColumns = map[string]struct {
+	column string
+	value  func(m *Mustang) interface{}
+}{
+	"name": {"name", func(m *Mustang) interface{} { return m.Name }},
+}
+
+// Patch updates only the columns named by the field mask paths on a single mustang row in the DB,
+// an empty set of paths updates every column
+func (svc *mustangService) Patch(ctx context.Context, input *Mustang, paths []string) error {
+	return svc.patch(ctx, false, input, paths)
+}
 
-	if useTx {
+// PatchTx updates only the columns named by the field mask paths within a tx from ctx
+func (svc *mustangService) PatchTx(ctx context.Context, input *Mustang, paths []string) error {
+	return svc.patch(ctx, true, input, paths)
+}
+
+// patch a mustang. if useTx = true then it will attempt to patch the mustang within a transaction
+// from context. a non zero input.Version must match the stored version or ErrVersionMismatch is returned,
+// a missing or deleted mustang is ErrNotFound.
+// the mustang.updated event is written to the outbox and audit history in the same transaction
+func (svc *mustangService) patch(ctx context.Context, useTx bool, input *Mustang, paths []string) error {
+	errMsg := func() string { return "Error executing patch mustang - " + fmt.Sprint(input) + " " + fmt.Sprint(paths) }
 
-		if tx, err = FromCtx(ctx); err != nil {
+	if len(paths) == 0 {
+		return svc.update(ctx, useTx, input)
+	}
+
+	// dedupe and sort the paths so the same mask always builds the same query
+	unique := map[string]bool{}
+	for _, p := range paths {
+		if _, ok := mustangPatchColumns[p]; !ok {
+			return errors.Wrap(ErrInvalidField, errMsg())
+		}
+		unique[p] = true
+	}
+	sorted := make([]string, 0, len(unique))
+	for p := range unique {
+		sorted = append(sorted, p)
+	}
+	sort.Strings(sorted)
+
+	set := make([]string, 0, len(sorted))
+	args := make([]interface{}, 0, len(sorted)+3)
+	for _, p := range sorted {
+		col := mustangPatchColumns[p]
+		set = append(set, col.column+" = ?")
+		args = append(args, col.value(input))
+	}
+	args = append(args, input.ID, input.Version, input.Version)
+	query := fmt.Sprintf(svc.dialect.patchMustangQuery, strings.Join(set, ",\n    "))
+
+	return svc.inTx(ctx, useTx, func(ctx context.Context) error {
+		conn, err := txConn(ctx, useTx, svc.db)
+		if err != nil {
 			return err
 		}
+		versionStmt, err := txStmt(ctx, useTx, svc.stmts["get-mustang-version"])
+		if err != nil {
+			return err
+		}
+
+		before, err := svc.snapshot(ctx, input.ID)
+		if err != nil {
+			return errors.Wrap(err, errMsg())
+		}
+		// the snapshot locks the row, a missing or deleted mustang stays that way until the write
+		if before == nil || before.Deleted {
+			return errors.Wrap(ErrNotFound, errMsg())
+		}
+
+		result, err := conn.ExecContext(ctx, query, args...)
+		if err != nil {
+			return errors.Wrap(err, errMsg())
+		}
+
+		rowCount, err := result.RowsAffected()
+		if err != nil {
+			return errors.Wrap(err, errMsg())
+		}
+
+		if rowCount == 0 {
+			if err = checkVersion(ctx, versionStmt, input.ID, input.Version); err != nil {
+				return errors.Wrap(err, errMsg())
+			}
+			return errors.Wrap(ErrNoRowsAffected, errMsg())
+		}
+
+		_, err = svc.recordEvent(ctx, EventMustangUpdated, input.ID, before)
+		return err
+	})
+}
+
+// Delete sets deleted_at for a single mustangs row and returns it with its new version, a non zero
+// version must match the stored version
+func (svc *mustangService) Delete(ctx context.Context, ID uuid.UUID, version int64) (*Mustang, error) {
+	return svc.delete(ctx, false, ID, version)
+}
+
+// DeleteTx sets deleted_at for a single mustangs row within a tx from ctx and returns it with its new version
+func (svc *mustangService) DeleteTx(ctx context.Context, ID uuid.UUID, version int64) (*Mustang, error) {
+	return svc.delete(ctx, true, ID, version)
+}
+
+// delete a mustang by setting deleted at. if useTx = true then it will attempt to delete the mustang within a transaction
+// from context. a non zero version must match the stored version or ErrVersionMismatch is returned.
+// the mustang.deleted event is written to the outbox and audit history in the same transaction
+func (svc *mustangService) delete(ctx context.Context, useTx bool, ID uuid.UUID, version int64) (*Mustang, error) {
+	errMsg := func() string { return "Error executing delete mustang - " + ID.String() }
+
+	var deleted *Mustang
+	err := svc.inTx(ctx, useTx, func(ctx context.Context) error {
+		stmt, err := txStmt(ctx, useTx, svc.stmts["delete-mustang"])
+		if err != nil {
+			return err
+		}
+		versionStmt, err := txStmt(ctx, useTx, svc.stmts["get-mustang-version"])
+		if err != nil {
+			return err
+		}
+
+		before, err := svc.snapshot(ctx, ID)
+		if err != nil {
+			return errors.Wrap(err, errMsg())
+		}
 
-		stmt = tx.Stmt(svc.stmts["update-mustang"])
-	} else {
-		stmt = svc.stmts["update-mustang"]
+		result, err := stmt.ExecContext(ctx, ID, version, version)
+		if err != nil {
+			return errors.Wrap(err, errMsg())
+		}
+
+		rowCount, err := result.RowsAffected()
+		if err != nil {
+			return errors.Wrap(err, errMsg())
+		}
+
+		if rowCount == 0 {
+			if err = checkVersion(ctx, versionStmt, ID, version); err != nil {
+				return errors.Wrap(err, errMsg())
+			}
+			return errors.Wrap(ErrNotFound, errMsg())
+		}
+
+		deleted, err = svc.recordEvent(ctx, EventMustangDeleted, ID, before)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return deleted, nil
+}
+
+// checkVersion explains a write that affected no rows. when an expected version was given and the
+// row exists with a different version ErrVersionMismatch is returned, otherwise the caller's own
+// no rows error applies and nil is returned
+func checkVersion(ctx context.Context, stmt *sql.Stmt, ID uuid.UUID, expected int64) error {
+	if expected == 0 {
+		return nil
 	}
 
-	result, err := stmt.ExecContext(ctx, input.Name, input.MustangID)
+	var current int64
+	err := stmt.QueryRowContext(ctx, ID).Scan(&current)
 	if err != nil {
-		return errors.Wrap(err, errMsg())
+
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+
+		return err
+	}
+
+	if current != expected {
+		return ErrVersionMismatch
 	}
 
-	rowCount, err := result.RowsAffected()
+	return nil
+}
+
+// rowExists returns ErrNotFound when the lookup stmt finds no row for ID
+func rowExists(ctx context.Context, stmt *sql.Stmt, ID uuid.UUID) error {
+	rows, err := stmt.QueryContext(ctx, ID)
 	if err != nil {
-		return errors.Wrap(err, errMsg())
+		return err
 	}
+	defer rows.Close()
 
-	if rowCount == 0 {
-		return errors.Wrap(ErrNoRowsAffected, errMsg())
+	if !rows.Next() {
+		if err = rows.Err(); err != nil {
+			return err
+		}
+		return ErrNotFound
 	}
 
 	return nil
 }
 
-// Delete sets deleted_at for a single mustangs row
-func (svc *mustangService) Delete(ctx context.Context, ID uuid.UUID) error {
-	return svc.delete(ctx, false, ID)
+// Restore clears deleted_at for a single soft deleted mustangs row
+func (svc *mustangService) Restore(ctx context.Context, ID uuid.UUID) error {
+	return svc.restore(ctx, false, ID)
 }
 
-// DeleteTx sets deleted_at for a single mustangs row within a tx from ctx
-func (svc *mustangService) DeleteTx(ctx context.Context, ID uuid.UUID) error {
-	return svc.delete(ctx, true, ID)
+// RestoreTx clears deleted_at for a single soft deleted mustangs row within a tx from ctx
+func (svc *mustangService) RestoreTx(ctx context.Context, ID uuid.UUID) error {
+	return svc.restore(ctx, true, ID)
 }
 
-// delete a mustang by setting deleted at. if useTx = true then it will attempt to delete the mustang within a transaction
-// from context.
-func (svc *mustangService) delete(ctx context.Context, useTx bool, ID uuid.UUID) error {
-	errMsg := func() string { return "Error executing delete mustang - " + ID.String() }
+// restore a mustang by clearing deleted at. if useTx = true then it will attempt to restore the mustang within a transaction
+// from context. restoring a mustang that was never deleted returns ErrNotDeleted.
+// the mustang.restored event is written to the outbox and audit history in the same transaction
+func (svc *mustangService) restore(ctx context.Context, useTx bool, ID uuid.UUID) error {
+	errMsg := func() string { return "Error executing restore mustang - " + ID.String() }
 
-	var (
-		stmt *sql.Stmt
-		err  error
-		tx   *sql.Tx
-	)
+	return svc.inTx(ctx, useTx, func(ctx context.Context) error {
+		stmt, err := txStmt(ctx, useTx, svc.stmts["restore-mustang"])
+		if err != nil {
+			return err
+		}
+		deleted, err := txStmt(ctx, useTx, svc.stmts["get-mustang-deleted"])
+		if err != nil {
+			return err
+		}
+
+		before, err := svc.snapshot(ctx, ID)
+		if err != nil {
+			return errors.Wrap(err, errMsg())
+		}
 
-	if useTx {
+		result, err := stmt.ExecContext(ctx, ID)
+		if err != nil {
+			return errors.Wrap(err, errMsg())
+		}
+
+		rowCount, err := result.RowsAffected()
+		if err != nil {
+			return errors.Wrap(err, errMsg())
+		}
 
-		if tx, err = FromCtx(ctx); err != nil {
+		if rowCount > 0 {
+			_, err = svc.recordEvent(ctx, EventMustangRestored, ID, before)
 			return err
 		}
 
-		stmt = tx.Stmt(svc.stmts["delete-mustang"])
-	} else {
-		stmt = svc.stmts["delete-mustang"]
+		// nothing was restored, find out if the row is missing or was never deleted
+		var isDeleted bool
+		err = deleted.QueryRowContext(ctx, ID).Scan(&isDeleted)
+		if err != nil {
+
+			if errors.Is(err, sql.ErrNoRows) {
+				return errors.Wrap(ErrNotFound, errMsg())
+			}
+
+			return errors.Wrap(err, errMsg())
+		}
+
+		if !isDeleted {
+			return errors.Wrap(ErrNotDeleted, errMsg())
+		}
+
+		return errors.Wrap(ErrNoRowsAffected, errMsg())
+	})
+}
+
+// List fetches a page of mustangs following the given cursor, a nil cursor fetches the first page.
+// The returned cursor points at the last mustang of the page and is nil when there are no more pages
+func (svc *mustangService) List(ctx context.Context, limit int, after *Cursor) ([]*Mustang, *Cursor, error) {
+	return svc.list(ctx, false, limit, after)
+}
+
+// ListTx fetches a page of mustangs inside of a tx from ctx
+func (svc *mustangService) ListTx(ctx context.Context, limit int, after *Cursor) ([]*Mustang, *Cursor, error) {
+	return svc.list(ctx, true, limit, after)
+}
+
+// list fetches a page of mustangs using keyset pagination over (created_at, mustang_id)
+func (svc *mustangService) list(ctx context.Context, useTx bool, limit int, after *Cursor) ([]*Mustang, *Cursor, error) {
+	errMsg := func() string { return "Error executing list mustangs - " + after.Encode() }
+
+	name := "list-mustangs"
+	args := []interface{}{pageFetchLimit(limit)}
+	if after != nil {
+		name = "list-mustangs-after"
+		args = []interface{}{after.CreatedAt, after.CreatedAt, after.ID, pageFetchLimit(limit)}
 	}
 
-	result, err := stmt.ExecContext(ctx, ID)
+	stmt, err := svc.replicas.readStmt(ctx, useTx, name, svc.stmts)
 	if err != nil {
-		return errors.Wrap(err, errMsg())
+		return nil, nil, err
 	}
 
-	rowCount, err := result.RowsAffected()
+	rows, err := stmt.QueryContext(ctx, args...)
 	if err != nil {
-		return errors.Wrap(err, errMsg())
+		return nil, nil, errors.Wrap(err, errMsg())
+	}
+	defer rows.Close()
+
+	var (
+		mustangs []*Mustang
+		next     *Cursor
+		more     bool
+	)
+
+	for rows.Next() {
+		m := Mustang{}
+		var createdAt time.Time
+
+		if err = rows.Scan(&m.ID, &m.Name, &m.Version, &createdAt); err != nil {
+			return nil, nil, errors.Wrap(err, errMsg())
+		}
+
+		if len(mustangs) == limit {
+			more = true
+			break
+		}
+
+		mustangs = append(mustangs, &m)
+		next = &Cursor{CreatedAt: createdAt, ID: m.ID}
 	}
 
-	if rowCount == 0 {
-		return errors.Wrap(ErrNotFound, errMsg())
+	if err = rows.Err(); err != nil {
+		return nil, nil, errors.Wrap(err, errMsg())
 	}
 
-	return nil
+	if !more {
+		next = nil
+	}
+
+	return mustangs, next, nil
+}
+
+// BatchGet fetches all mustangs matching the given IDs in a single query, IDs that
+// do not match a mustang are left out of the result
+func (svc *mustangService) BatchGet(ctx context.Context, IDs []uuid.UUID) ([]*Mustang, error) {
+	return svc.batchGet(ctx, false, IDs)
+}
+
+// BatchGetTx fetches all mustangs matching the given IDs inside of a tx from ctx
+func (svc *mustangService) BatchGetTx(ctx context.Context, IDs []uuid.UUID) ([]*Mustang, error) {
+	return svc.batchGet(ctx, true, IDs)
 }
 
+// batchGet fetches all mustangs matching the given IDs with a WHERE mustang_id IN (...) query
+func (svc *mustangService) batchGet(ctx context.Context, useTx bool, IDs []uuid.UUID) ([]*Mustang, error) {
+	errMsg := func() string { return "Error executing batch get mustangs - " + fmt.Sprint(IDs) }
+
+	if len(IDs) == 0 {
+		return []*Mustang{}, nil
+	}
+	if len(IDs) > MaxBatchSize {
+		return nil, errors.Wrap(ErrBatchTooLarge, errMsg())
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat(svc.dialect.uuidParam+", ", len(IDs)), ", ")
+	query := fmt.Sprintf(svc.dialect.batchGetMustangsQuery, placeholders)
+	args := make([]interface{}, 0, len(IDs))
+	for _, ID := range IDs {
+		args = append(args, ID)
+	}
+
+	conn, err := svc.replicas.readConn(ctx, useTx, svc.db)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := conn.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, errMsg())
+	}
+	defer rows.Close()
+
+	mustangs := make([]*Mustang, 0, len(IDs))
+	for rows.Next() {
+		m := Mustang{}
+		if err = rows.Scan(&m.ID, &m.Name, &m.Version); err != nil {
+			return nil, errors.Wrap(err, errMsg())
+		}
+		mustangs = append(mustangs, &m)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, errors.Wrap(err, errMsg())
+	}
+
+	return mustangs, nil
+}
@@ -1,346 +1,698 @@
 package db
 
 import (
-  "context"
-  "database/sql"
-  "database/sql/driver"
-  "testing"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"testing"
+	"time"
 
-  "github.com/DATA-DOG/go-sqlmock"
-  "github.com/google/uuid"
-  "github.com/stretchr/testify/assert"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
 
-  "github.com/caring/ford-mustang/pb"
+	"github.com/caring/ford-mustang/pb"
 )
 
-
-
 // ensures that casting from proto to store structs occurs correctly
 func TestNewMustang(t *testing.T) {
-  mustangID := uuid.MustParse("72bc87f3-4a9f-4d05-93fe-844d3cd94c65")
-  proto := pb.CreateMustangRequest{
-    Name:       "Foobar",
-  }
+	mustangID := uuid.MustParse("72bc87f3-4a9f-4d05-93fe-844d3cd94c65")
+	proto := pb.CreateMustangRequest{
+		Name: "Foobar",
+	}
 
-  r, err := NewMustang(mustangID.String(), &proto)
+	r, err := NewMustang(mustangID.String(), &proto)
 
-  assert.NoError(t, err, "Expected NewCategory not to error")
-  assert.Equal(t, mustangID, r.ID, "Expected UUIDs to match")
-  assert.Equal(t, proto.Name, r.Name, "Expected name to be correctly assigned")
+	assert.NoError(t, err, "Expected NewCategory not to error")
+	assert.Equal(t, mustangID, r.ID, "Expected UUIDs to match")
+	assert.Equal(t, proto.Name, r.Name, "Expected name to be correctly assigned")
 }
 
 // ensures that casting from store to proto response occurs correctly
 func TestMustang_ToProto(t *testing.T) {
-  mustangID := uuid.MustParse("72bc87f3-4a9f-4d05-93fe-844d3cd94c65")
+	mustangID := uuid.MustParse("72bc87f3-4a9f-4d05-93fe-844d3cd94c65")
 
-  mustang := &Mustang{
-    ID:  mustangID,
-    Name:       "foobar",
-  }
+	mustang := &Mustang{
+		ID:   mustangID,
+		Name: "foobar",
+	}
 
-  r := mustang.ToProto()
+	r := mustang.ToProto()
 
-  assert.Equal(t, mustangID.String(), r.MustangId, "Expected field to be mapped back to proto object correctly")
-  assert.Equal(t, "foobar", r.Name, "Expected field to be mapped back to proto object correctly")
+	assert.Equal(t, mustangID.String(), r.Id, "Expected field to be mapped back to proto object correctly")
+	assert.Equal(t, "foobar", r.Name, "Expected field to be mapped back to proto object correctly")
 }
 
 func TestMustangService_get(t *testing.T) {
-  mustangID := uuid.MustParse("72bc87f3-4a9f-4d05-93fe-844d3cd94c65")
-  stmt := map[string]string{
-    "get-mustang": "SELECT mustangs",
-  }
-  args := []driver.Value{
-    "72bc87f3-4a9f-4d05-93fe-844d3cd94c65",
-  }
-
-  // ensures execution within a transaction occurs without error and the correct result is returned
-  t.Run("With a provided transaction", func(t *testing.T) {
-    store, mock, err := NewTestDB(stmt)
-    if ok := assert.NoError(t, err, "Expected no error"); !ok {
-      assert.FailNow(t, "test setup failed")
-    }
-
-    mock.ExpectBegin()
-    mock.ExpectQuery("SELECT mustangs").
-      WithArgs(args...).
-      WillReturnRows(
-        sqlmock.NewRows([]string{"mustang_id", "name"}).
-          AddRow(mustangID, "Foobar"),
-      )
-
-    tx, err := store.GetTx()
-    if ok := assert.NoError(t, err, "Expected no error"); !ok {
-      assert.FailNow(t, "transaction setup failed")
-    }
-
-    result, err := store.Mustang.GetTx(ToCtx(context.Background(), tx), mustangID)
-    assert.NoError(t, err, "Expecting no query error")
-
-    assert.Equal(t, mustangID, r.ID, "Expected correct mustang ID to be returned")
-    assert.Equal(t, "Foobar", r.Name, "Expected correct name to be returned")
-
-    err = mock.ExpectationsWereMet()
-    assert.NoError(t, err, "Expecting all mock conditions to be met")
-  })
-
-  // ensures that execution outside of transaction occurs without error and the correct result is returned
-  t.Run("Without a provided transaction", func(t *testing.T) {
-    store, mock, err := NewTestDB(stmt)
-    if ok := assert.NoError(t, err, "Expected no error"); !ok {
-      assert.FailNow(t, "test setup failed")
-    }
-
-    mock.ExpectQuery("SELECT mustangs").
-      WithArgs(args...).
-      WillReturnRows(
-        sqlmock.NewRows([]string{"mustang_id", "name"}).
-          AddRow(mustangID, "Foobar"),
-      )
-
-    result, err := store.Mustang.Get(context.Background(), mustangID)
-    assert.NoError(t, err, "Expecting no query error")
-
-    assert.Equal(t, mustangID, r.ID, "Expected correct mustang ID to be returned")
-    assert.Equal(t, "Foobar", r.Name, "Expected correct name to be returned")
-
-    err = mock.ExpectationsWereMet()
-    assert.NoError(t, err, "Expecting all mock conditions to be met")
-  })
-
-  // ensures a record not found is handled correctly
-  t.Run("No rows returned", func(t *testing.T) {
-    store, mock, err := NewTestDB(stmt)
-    if ok := assert.NoError(t, err, "Expected no error"); !ok {
-      assert.FailNow(t, "test setup failed")
-    }
-
-    mock.ExpectQuery("SELECT mustangs").
-      WithArgs(args...).WillReturnError(sql.ErrNoRows)
-
-    _, err = store.Mustang.Get(context.Background(), mustangID)
-    assert.EqualError(t, err, "Error executing get mustang - 72bc87f3-4a9f-4d05-93fe-844d3cd94c65: the record you are attempting to find or update is not found", "Expecting no query error")
-
-    err = mock.ExpectationsWereMet()
-    assert.NoError(t, err, "Expecting all mock conditions to be met")
-  })
+	mustangID := uuid.MustParse("72bc87f3-4a9f-4d05-93fe-844d3cd94c65")
+	stmt := map[string]string{
+		"get-mustang": "SELECT mustangs",
+	}
+	args := []driver.Value{
+		"72bc87f3-4a9f-4d05-93fe-844d3cd94c65",
+	}
+
+	// ensures execution within a transaction occurs without error and the correct result is returned
+	t.Run("With a provided transaction", func(t *testing.T) {
+		store, mock, err := NewTestDB(stmt)
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "test setup failed")
+		}
+
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT mustangs").
+			WithArgs(args...).
+			WillReturnRows(
+				sqlmock.NewRows([]string{"mustang_id", "name", "version"}).
+					AddRow(mustangID[:], "Foobar", 1),
+			)
+
+		tx, err := store.GetTx()
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "transaction setup failed")
+		}
+
+		r, err := store.Mustang.GetTx(ToCtx(context.Background(), tx), mustangID)
+		assert.NoError(t, err, "Expecting no query error")
+
+		assert.Equal(t, mustangID, r.ID, "Expected correct mustang ID to be returned")
+		assert.Equal(t, "Foobar", r.Name, "Expected correct name to be returned")
+		assert.Equal(t, int64(1), r.Version, "Expected correct version to be returned")
+
+		err = mock.ExpectationsWereMet()
+		assert.NoError(t, err, "Expecting all mock conditions to be met")
+	})
+
+	// ensures that execution outside of transaction occurs without error and the correct result is returned
+	t.Run("Without a provided transaction", func(t *testing.T) {
+		store, mock, err := NewTestDB(stmt)
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "test setup failed")
+		}
+
+		mock.ExpectQuery("SELECT mustangs").
+			WithArgs(args...).
+			WillReturnRows(
+				sqlmock.NewRows([]string{"mustang_id", "name", "version"}).
+					AddRow(mustangID[:], "Foobar", 1),
+			)
+
+		r, err := store.Mustang.Get(context.Background(), mustangID)
+		assert.NoError(t, err, "Expecting no query error")
+
+		assert.Equal(t, mustangID, r.ID, "Expected correct mustang ID to be returned")
+		assert.Equal(t, "Foobar", r.Name, "Expected correct name to be returned")
+		assert.Equal(t, int64(1), r.Version, "Expected correct version to be returned")
+
+		err = mock.ExpectationsWereMet()
+		assert.NoError(t, err, "Expecting all mock conditions to be met")
+	})
+
+	// ensures a record not found is handled correctly
+	t.Run("No rows returned", func(t *testing.T) {
+		store, mock, err := NewTestDB(stmt)
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "test setup failed")
+		}
+
+		mock.ExpectQuery("SELECT mustangs").
+			WithArgs(args...).WillReturnError(sql.ErrNoRows)
+
+		_, err = store.Mustang.Get(context.Background(), mustangID)
+		assert.EqualError(t, err, "Error executing get mustang - 72bc87f3-4a9f-4d05-93fe-844d3cd94c65: the record you are attempting to find or update is not found", "Expecting no query error")
+
+		err = mock.ExpectationsWereMet()
+		assert.NoError(t, err, "Expecting all mock conditions to be met")
+	})
 }
 
 func TestMustangService_create(t *testing.T) {
-  mustangID := uuid.MustParse("72bc87f3-4a9f-4d05-93fe-844d3cd94c65")
-  stmt := map[string]string{
-    "create-mustang": "INSERT mustangs",
-  }
-  input := &Mustang{
-    ID:   mustangID,
-    Name: "Foobar",
-  }
-  args := []driver.Value{
-    "72bc87f3-4a9f-4d05-93fe-844d3cd94c65",
-    "Foobar",
-  }
-
-  // ensures that execution within a transaction occurs without error
-  t.Run("With a provided transaction", func(t *testing.T) {
-    store, mock, err := NewTestDB(stmt)
-    if ok := assert.NoError(t, err, "Expected no error"); !ok {
-      assert.FailNow(t, "test setup failed")
-    }
-
-    mock.ExpectBegin()
-    mock.ExpectExec("INSERT mustangs").
-      WithArgs(args...).
-      WillReturnResult(sqlmock.NewResult(0, 1))
-
-    tx, err := store.GetTx()
-    if ok := assert.NoError(t, err, "Expected no error"); !ok {
-      assert.FailNow(t, "transaction setup failed")
-    }
-
-    err = store.Mustang.CreateTx(ToCtx(context.Background(), tx), input)
-    assert.NoError(t, err, "Expecting no query error")
-
-    err = mock.ExpectationsWereMet()
-    assert.NoError(t, err, "Expecting all mock conditions to be met")
-  })
-
-  // ensures that execution outside of a transaction occurs without error
-  t.Run("Without a provided transaction", func(t *testing.T) {
-    store, mock, err := NewTestDB(stmt)
-    if ok := assert.NoError(t, err, "Expected no error"); !ok {
-      assert.FailNow(t, "test setup failed")
-    }
-
-    mock.ExpectExec("INSERT mustangs").
-      WithArgs(args...).
-      WillReturnResult(sqlmock.NewResult(0, 1))
-
-    err = store.Mustang.Create(context.Background(), input)
-    assert.NoError(t, err, "Expecting no query error")
-
-    err = mock.ExpectationsWereMet()
-    assert.NoError(t, err, "Expecting all mock conditions to be met")
-  })
-
-  // ensures that a failed record create is handled correctly
-  t.Run("Failed record create", func(t *testing.T) {
-    store, mock, err := NewTestDB(stmt)
-    if ok := assert.NoError(t, err, "Expected no error"); !ok {
-      assert.FailNow(t, "test setup failed")
-    }
-
-    mock.ExpectExec("INSERT mustangs").
-      WithArgs(args...).
-      WillReturnResult(sqlmock.NewResult(0, 0))
-
-    err = store.Mustang.Create(context.Background(), input)
-    assert.EqualError(t, err, "Error executing create mustang - &{72bc87f3-4a9f-4d05-93fe-844d3cd94c65 Foobar}: no new rows were created", "Expecting no query error")
-
-    err = mock.ExpectationsWereMet()
-    assert.NoError(t, err, "Expecting all mock conditions to be met")
-  })
+	mustangID := uuid.MustParse("72bc87f3-4a9f-4d05-93fe-844d3cd94c65")
+	stmt := withOutbox(map[string]string{
+		"create-mustang": "INSERT mustangs",
+	})
+	input := &Mustang{
+		ID:   mustangID,
+		Name: "Foobar",
+	}
+	args := []driver.Value{
+		"72bc87f3-4a9f-4d05-93fe-844d3cd94c65",
+		"Foobar",
+	}
+
+	// ensures that execution within a transaction occurs without error
+	t.Run("With a provided transaction", func(t *testing.T) {
+		store, mock, err := NewTestDB(stmt)
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "test setup failed")
+		}
+
+		mock.ExpectBegin()
+		mock.ExpectExec("INSERT mustangs").
+			WithArgs(args...).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		expectMustangEvent(mock, EventMustangCreated, Mustang{ID: mustangID, Name: "Foobar", Version: 1}, false)
+
+		tx, err := store.GetTx()
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "transaction setup failed")
+		}
+
+		err = store.Mustang.CreateTx(ToCtx(context.Background(), tx), input)
+		assert.NoError(t, err, "Expecting no query error")
+
+		err = mock.ExpectationsWereMet()
+		assert.NoError(t, err, "Expecting all mock conditions to be met")
+	})
+
+	// ensures that execution outside of a transaction occurs without error
+	t.Run("Without a provided transaction", func(t *testing.T) {
+		store, mock, err := NewTestDB(stmt)
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "test setup failed")
+		}
+
+		mock.ExpectBegin()
+		mock.ExpectExec("INSERT mustangs").
+			WithArgs(args...).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		expectMustangEvent(mock, EventMustangCreated, Mustang{ID: mustangID, Name: "Foobar", Version: 1}, false)
+		mock.ExpectCommit()
+
+		err = store.Mustang.Create(context.Background(), input)
+		assert.NoError(t, err, "Expecting no query error")
+
+		err = mock.ExpectationsWereMet()
+		assert.NoError(t, err, "Expecting all mock conditions to be met")
+	})
+
+	// ensures that a failed record create is handled correctly
+	t.Run("Failed record create", func(t *testing.T) {
+		store, mock, err := NewTestDB(stmt)
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "test setup failed")
+		}
+
+		mock.ExpectBegin()
+		mock.ExpectExec("INSERT mustangs").
+			WithArgs(args...).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectRollback()
+
+		err = store.Mustang.Create(context.Background(), input)
+		assert.EqualError(t, err, "Error executing create mustang - &{72bc87f3-4a9f-4d05-93fe-844d3cd94c65 Foobar 0}: no new rows were created", "Expecting no query error")
+
+		err = mock.ExpectationsWereMet()
+		assert.NoError(t, err, "Expecting all mock conditions to be met")
+	})
 }
 
 func TestMustangService_update(t *testing.T) {
-  mustangID := uuid.MustParse("72bc87f3-4a9f-4d05-93fe-844d3cd94c65")
-  stmt := map[string]string{
-    "update-mustang": "UPDATE mustangs",
-  }
-  input := &Mustang{
-    ID:   mustangID,
-    Name: "Foobar",
-  }
-  args := []driver.Value{
-    "Foobar",
-    "72bc87f3-4a9f-4d05-93fe-844d3cd94c65",
-  }
-
-  // ensures that execution within a transaction occurs without error
-  t.Run("With a provided transaction", func(t *testing.T) {
-    store, mock, err := NewTestDB(stmt)
-    if ok := assert.NoError(t, err, "Expected no error"); !ok {
-      assert.FailNow(t, "test setup failed")
-    }
-
-    mock.ExpectBegin()
-    mock.ExpectExec("UPDATE mustangs").
-      WithArgs(args...).
-      WillReturnResult(sqlmock.NewResult(0, 1))
-
-    tx, err := store.GetTx()
-    if ok := assert.NoError(t, err, "Expected no error"); !ok {
-      assert.FailNow(t, "transaction setup failed")
-    }
-
-    err = store.Mustang.UpdateTx(ToCtx(context.Background(), tx), input)
-    assert.NoError(t, err, "Expecting no query error")
-
-    err = mock.ExpectationsWereMet()
-    assert.NoError(t, err, "Expecting all mock conditions to be met")
-  })
-
-  // ensures execution out of a transaction occurs without error
-  t.Run("Without a provided transaction", func(t *testing.T) {
-    store, mock, err := NewTestDB(stmt)
-    if ok := assert.NoError(t, err, "Expected no error"); !ok {
-      assert.FailNow(t, "test setup failed")
-    }
-
-    mock.ExpectExec("UPDATE mustangs").
-      WithArgs(args...).
-      WillReturnResult(sqlmock.NewResult(0, 1))
-
-    err = store.Mustang.Update(context.Background(), input)
-    assert.NoError(t, err, "Expecting no query error")
-
-    err = mock.ExpectationsWereMet()
-    assert.NoError(t, err, "Expecting all mock conditions to be met")
-  })
-
-  // ensures correct error to be returned when no rows are updated
-  t.Run("No updates occurred", func(t *testing.T) {
-    store, mock, err := NewTestDB(stmt)
-    if ok := assert.NoError(t, err, "Expected no error"); !ok {
-      assert.FailNow(t, "test setup failed")
-    }
-
-    mock.ExpectExec("UPDATE mustangs").
-      WithArgs(args...).
-      WillReturnResult(sqlmock.NewResult(0, 0))
-
-    err = store.Mustang.Update(context.Background(), input)
-    assert.EqualError(t, err, "Error executing update mustang - &{72bc87f3-4a9f-4d05-93fe-844d3cd94c65 Foobar}: no rows affected", "Expecting no query error")
-
-    err = mock.ExpectationsWereMet()
-    assert.NoError(t, err, "Expecting all mock conditions to be met")
-  })
+	mustangID := uuid.MustParse("72bc87f3-4a9f-4d05-93fe-844d3cd94c65")
+	stmt := withOutbox(map[string]string{
+		"update-mustang":      "UPDATE mustangs",
+		"get-mustang-version": "SELECT version",
+	})
+	input := &Mustang{
+		ID:   mustangID,
+		Name: "Foobar",
+	}
+	args := []driver.Value{
+		"Foobar",
+		"72bc87f3-4a9f-4d05-93fe-844d3cd94c65",
+		0,
+		0,
+	}
+
+	// ensures that execution within a transaction occurs without error
+	t.Run("With a provided transaction", func(t *testing.T) {
+		store, mock, err := NewTestDB(stmt)
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "test setup failed")
+		}
+
+		mock.ExpectBegin()
+		expectMustangSnapshot(mock, Mustang{ID: mustangID, Name: "Foobar", Version: 1}, false)
+		mock.ExpectExec("UPDATE mustangs").
+			WithArgs(args...).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		expectMustangEvent(mock, EventMustangUpdated, Mustang{ID: mustangID, Name: "Foobar", Version: 2}, false)
+
+		tx, err := store.GetTx()
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "transaction setup failed")
+		}
+
+		err = store.Mustang.UpdateTx(ToCtx(context.Background(), tx), input)
+		assert.NoError(t, err, "Expecting no query error")
+
+		err = mock.ExpectationsWereMet()
+		assert.NoError(t, err, "Expecting all mock conditions to be met")
+	})
+
+	// ensures execution out of a transaction occurs without error
+	t.Run("Without a provided transaction", func(t *testing.T) {
+		store, mock, err := NewTestDB(stmt)
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "test setup failed")
+		}
+
+		mock.ExpectBegin()
+		expectMustangSnapshot(mock, Mustang{ID: mustangID, Name: "Foobar", Version: 1}, false)
+		mock.ExpectExec("UPDATE mustangs").
+			WithArgs(args...).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		expectMustangEvent(mock, EventMustangUpdated, Mustang{ID: mustangID, Name: "Foobar", Version: 2}, false)
+		mock.ExpectCommit()
+
+		err = store.Mustang.Update(context.Background(), input)
+		assert.NoError(t, err, "Expecting no query error")
+
+		err = mock.ExpectationsWereMet()
+		assert.NoError(t, err, "Expecting all mock conditions to be met")
+	})
+
+	// ensures a stale version is reported as a version mismatch
+	t.Run("Version mismatch", func(t *testing.T) {
+		store, mock, err := NewTestDB(stmt)
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "test setup failed")
+		}
+
+		mock.ExpectBegin()
+		expectMustangSnapshot(mock, Mustang{ID: mustangID, Name: "Foobar", Version: 3}, false)
+		mock.ExpectExec("UPDATE mustangs").
+			WithArgs("Foobar", "72bc87f3-4a9f-4d05-93fe-844d3cd94c65", 2, 2).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery("SELECT version").
+			WithArgs("72bc87f3-4a9f-4d05-93fe-844d3cd94c65").
+			WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(3))
+		mock.ExpectRollback()
+
+		err = store.Mustang.Update(context.Background(), &Mustang{ID: mustangID, Name: "Foobar", Version: 2})
+		assert.EqualError(t, err, "Error executing update mustang - &{72bc87f3-4a9f-4d05-93fe-844d3cd94c65 Foobar 2}: the record has been modified since the version you provided", "Expecting version mismatch error")
+
+		err = mock.ExpectationsWereMet()
+		assert.NoError(t, err, "Expecting all mock conditions to be met")
+	})
+
+	// ensures updating a record that does not exist is not found, without attempting the write
+	t.Run("Updating a non existent record", func(t *testing.T) {
+		store, mock, err := NewTestDB(stmt)
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "test setup failed")
+		}
+
+		mock.ExpectBegin()
+		expectNoMustangSnapshot(mock, mustangID)
+		mock.ExpectRollback()
+
+		err = store.Mustang.Update(context.Background(), input)
+		assert.EqualError(t, err, "Error executing update mustang - &{72bc87f3-4a9f-4d05-93fe-844d3cd94c65 Foobar 0}: the record you are attempting to find or update is not found", "Expecting not found error")
+
+		err = mock.ExpectationsWereMet()
+		assert.NoError(t, err, "Expecting all mock conditions to be met")
+	})
+
+	// ensures updating a soft deleted record is not found, even with its current version
+	t.Run("Updating a deleted record", func(t *testing.T) {
+		store, mock, err := NewTestDB(stmt)
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "test setup failed")
+		}
+
+		mock.ExpectBegin()
+		expectMustangSnapshot(mock, Mustang{ID: mustangID, Name: "Foobar", Version: 2}, true)
+		mock.ExpectRollback()
+
+		err = store.Mustang.Update(context.Background(), &Mustang{ID: mustangID, Name: "Foobar", Version: 2})
+		assert.ErrorIs(t, err, ErrNotFound, "Expecting not found error")
+
+		err = mock.ExpectationsWereMet()
+		assert.NoError(t, err, "Expecting all mock conditions to be met")
+	})
 }
 
 func TestMustangService_delete(t *testing.T) {
-  mustangID := uuid.MustParse("72bc87f3-4a9f-4d05-93fe-844d3cd94c65")
-  stmt := map[string]string{
-    "delete-mustang": "UPDATE mustangs",
-  }
-  args := []driver.Value{
-    "72bc87f3-4a9f-4d05-93fe-844d3cd94c65",
-  }
-
-  // ensures that execution withing a transaction occurs without error
-  t.Run("With a provided transaction", func(t *testing.T) {
-    store, mock, err := NewTestDB(stmt)
-    if ok := assert.NoError(t, err, "Expected no error"); !ok {
-      assert.FailNow(t, "test setup failed")
-    }
-
-    mock.ExpectBegin()
-    mock.ExpectExec("UPDATE mustangs").
-      WithArgs(args...).
-      WillReturnResult(sqlmock.NewResult(0, 1))
-
-    tx, err := store.GetTx()
-    if ok := assert.NoError(t, err, "Expected no error"); !ok {
-      assert.FailNow(t, "transaction setup failed")
-    }
-
-    err = store.Mustang.DeleteTx(ToCtx(context.Background(), tx), mustangID)
-    assert.NoError(t, err, "Expecting no query error")
-
-    err = mock.ExpectationsWereMet()
-    assert.NoError(t, err, "Expecting all mock conditions to be met")
-  })
-
-  // ensures that execution outside of a transaction occurs without error
-  t.Run("Without a provided transaction", func(t *testing.T) {
-    store, mock, err := NewTestDB(stmt)
-    if ok := assert.NoError(t, err, "Expected no error"); !ok {
-      assert.FailNow(t, "test setup failed")
-    }
-
-    mock.ExpectExec("UPDATE mustangs").
-      WithArgs(args...).
-      WillReturnResult(sqlmock.NewResult(0, 1))
-
-    err = store.Mustang.Delete(context.Background(), mustangID)
-    assert.NoError(t, err, "Expecting no query error")
-
-    err = mock.ExpectationsWereMet()
-    assert.NoError(t, err, "Expecting all mock conditions to be met")
-  })
-
-  // ensures that deleting a non existent record is handled correctly
-  t.Run("Deleting a non existent record", func(t *testing.T) {
-    store, mock, err := NewTestDB(stmt)
-    if ok := assert.NoError(t, err, "Expected no error"); !ok {
-      assert.FailNow(t, "test setup failed")
-    }
-
-    mock.ExpectExec("UPDATE mustangs").
-      WithArgs(args...).
-      WillReturnResult(sqlmock.NewResult(0, 0))
-
-    err = store.Mustang.Delete(context.Background(), mustangID)
-    assert.EqualError(t, err, "Error executing delete mustang - 72bc87f3-4a9f-4d05-93fe-844d3cd94c65: the record you are attempting to find or update is not found", "Expecting not found error")
-
-    err = mock.ExpectationsWereMet()
-    assert.NoError(t, err, "Expecting all mock conditions to be met")
-  })
-}
\ No newline at end of file
+	mustangID := uuid.MustParse("72bc87f3-4a9f-4d05-93fe-844d3cd94c65")
+	stmt := withOutbox(map[string]string{
+		"delete-mustang":      "UPDATE mustangs",
+		"get-mustang-version": "SELECT version",
+	})
+	args := []driver.Value{
+		"72bc87f3-4a9f-4d05-93fe-844d3cd94c65",
+		0,
+		0,
+	}
+
+	// ensures that execution withing a transaction occurs without error
+	t.Run("With a provided transaction", func(t *testing.T) {
+		store, mock, err := NewTestDB(stmt)
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "test setup failed")
+		}
+
+		mock.ExpectBegin()
+		expectMustangSnapshot(mock, Mustang{ID: mustangID, Name: "Foobar", Version: 1}, false)
+		mock.ExpectExec("UPDATE mustangs").
+			WithArgs(args...).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		expectMustangEvent(mock, EventMustangDeleted, Mustang{ID: mustangID, Name: "Foobar", Version: 2}, true)
+
+		tx, err := store.GetTx()
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "transaction setup failed")
+		}
+
+		_, err = store.Mustang.DeleteTx(ToCtx(context.Background(), tx), mustangID, 0)
+		assert.NoError(t, err, "Expecting no query error")
+
+		err = mock.ExpectationsWereMet()
+		assert.NoError(t, err, "Expecting all mock conditions to be met")
+	})
+
+	// ensures that execution outside of a transaction occurs without error
+	t.Run("Without a provided transaction", func(t *testing.T) {
+		store, mock, err := NewTestDB(stmt)
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "test setup failed")
+		}
+
+		mock.ExpectBegin()
+		expectMustangSnapshot(mock, Mustang{ID: mustangID, Name: "Foobar", Version: 1}, false)
+		mock.ExpectExec("UPDATE mustangs").
+			WithArgs(args...).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		expectMustangEvent(mock, EventMustangDeleted, Mustang{ID: mustangID, Name: "Foobar", Version: 2}, true)
+		mock.ExpectCommit()
+
+		m, err := store.Mustang.Delete(context.Background(), mustangID, 0)
+		assert.NoError(t, err, "Expecting no query error")
+		assert.Equal(t, &Mustang{ID: mustangID, Name: "Foobar", Version: 2}, m, "Expected the deleted mustang with its new version")
+
+		err = mock.ExpectationsWereMet()
+		assert.NoError(t, err, "Expecting all mock conditions to be met")
+	})
+
+	// ensures that deleting a non existent record is handled correctly
+	t.Run("Deleting a non existent record", func(t *testing.T) {
+		store, mock, err := NewTestDB(stmt)
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "test setup failed")
+		}
+
+		mock.ExpectBegin()
+		expectNoMustangSnapshot(mock, mustangID)
+		mock.ExpectExec("UPDATE mustangs").
+			WithArgs(args...).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectRollback()
+
+		_, err = store.Mustang.Delete(context.Background(), mustangID, 0)
+		assert.EqualError(t, err, "Error executing delete mustang - 72bc87f3-4a9f-4d05-93fe-844d3cd94c65: the record you are attempting to find or update is not found", "Expecting not found error")
+
+		err = mock.ExpectationsWereMet()
+		assert.NoError(t, err, "Expecting all mock conditions to be met")
+	})
+}
+
+func TestMustangService_list(t *testing.T) {
+	firstID := uuid.MustParse("72bc87f3-4a9f-4d05-93fe-844d3cd94c65")
+	secondID := uuid.MustParse("94cc5321-ec44-464f-9008-3d81f5e2c18f")
+	createdAt := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	stmt := map[string]string{
+		"list-mustangs":       "SELECT mustangs FIRST",
+		"list-mustangs-after": "SELECT mustangs AFTER",
+	}
+
+	// ensures a first page with more results returns a cursor to the last row
+	t.Run("First page with more results", func(t *testing.T) {
+		store, mock, err := NewTestDB(stmt)
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "test setup failed")
+		}
+
+		mock.ExpectQuery("SELECT mustangs FIRST").
+			WithArgs(2).
+			WillReturnRows(
+				sqlmock.NewRows([]string{"mustang_id", "name", "version", "created_at"}).
+					AddRow(firstID[:], "Foobar", 1, createdAt).
+					AddRow(secondID[:], "Bazbar", 1, createdAt),
+			)
+
+		r, next, err := store.Mustang.List(context.Background(), 1, nil)
+		assert.NoError(t, err, "Expecting no query error")
+
+		assert.Len(t, r, 1, "Expected the page to be limited")
+		assert.Equal(t, firstID, r[0].ID, "Expected correct mustang ID to be returned")
+		if ok := assert.NotNil(t, next, "Expected a cursor to the next page"); ok {
+			assert.Equal(t, firstID, next.ID, "Expected cursor to point at the last row of the page")
+		}
+
+		err = mock.ExpectationsWereMet()
+		assert.NoError(t, err, "Expecting all mock conditions to be met")
+	})
+
+	// ensures the last page following a cursor returns no next cursor
+	t.Run("Last page after a cursor", func(t *testing.T) {
+		store, mock, err := NewTestDB(stmt)
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "test setup failed")
+		}
+
+		mock.ExpectQuery("SELECT mustangs AFTER").
+			WithArgs(createdAt, createdAt, "72bc87f3-4a9f-4d05-93fe-844d3cd94c65", 3).
+			WillReturnRows(
+				sqlmock.NewRows([]string{"mustang_id", "name", "version", "created_at"}).
+					AddRow(secondID[:], "Bazbar", 1, createdAt),
+			)
+
+		r, next, err := store.Mustang.List(context.Background(), 2, &Cursor{CreatedAt: createdAt, ID: firstID})
+		assert.NoError(t, err, "Expecting no query error")
+
+		assert.Len(t, r, 1, "Expected the remaining rows to be returned")
+		assert.Equal(t, secondID, r[0].ID, "Expected correct mustang ID to be returned")
+		assert.Nil(t, next, "Expected no cursor on the last page")
+
+		err = mock.ExpectationsWereMet()
+		assert.NoError(t, err, "Expecting all mock conditions to be met")
+	})
+}
+
+func TestMustangService_batchGet(t *testing.T) {
+	firstID := uuid.MustParse("72bc87f3-4a9f-4d05-93fe-844d3cd94c65")
+	secondID := uuid.MustParse("94cc5321-ec44-464f-9008-3d81f5e2c18f")
+	args := []driver.Value{
+		"72bc87f3-4a9f-4d05-93fe-844d3cd94c65",
+		"94cc5321-ec44-464f-9008-3d81f5e2c18f",
+	}
+
+	// ensures all keys are fetched with a single IN query
+	t.Run("Without a provided transaction", func(t *testing.T) {
+		store, mock, err := NewTestDB(map[string]string{})
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "test setup failed")
+		}
+
+		mock.ExpectQuery(`mustang_id IN \(UUID_TO_BIN\(\?\), UUID_TO_BIN\(\?\)\)`).
+			WithArgs(args...).
+			WillReturnRows(
+				sqlmock.NewRows([]string{"mustang_id", "name", "version"}).
+					AddRow(secondID[:], "Bazbar", 1),
+			)
+
+		r, err := store.Mustang.BatchGet(context.Background(), []uuid.UUID{firstID, secondID})
+		assert.NoError(t, err, "Expecting no query error")
+
+		assert.Len(t, r, 1, "Expected only found mustangs to be returned")
+		assert.Equal(t, secondID, r[0].ID, "Expected correct mustang ID to be returned")
+
+		err = mock.ExpectationsWereMet()
+		assert.NoError(t, err, "Expecting all mock conditions to be met")
+	})
+
+	// ensures no query is run for an empty set of keys
+	t.Run("No keys", func(t *testing.T) {
+		store, mock, err := NewTestDB(map[string]string{})
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "test setup failed")
+		}
+
+		r, err := store.Mustang.BatchGet(context.Background(), nil)
+		assert.NoError(t, err, "Expecting no query error")
+		assert.Empty(t, r, "Expected no mustangs to be returned")
+
+		err = mock.ExpectationsWereMet()
+		assert.NoError(t, err, "Expecting all mock conditions to be met")
+	})
+}
+
+func TestMustangService_restore(t *testing.T) {
+	mustangID := uuid.MustParse("72bc87f3-4a9f-4d05-93fe-844d3cd94c65")
+	stmt := withOutbox(map[string]string{
+		"restore-mustang":     "UPDATE mustangs",
+		"get-mustang-deleted": "SELECT deleted mustangs",
+	})
+	args := []driver.Value{
+		"72bc87f3-4a9f-4d05-93fe-844d3cd94c65",
+	}
+
+	// ensures that a deleted record is restored without error
+	t.Run("Restoring a deleted record", func(t *testing.T) {
+		store, mock, err := NewTestDB(stmt)
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "test setup failed")
+		}
+
+		mock.ExpectBegin()
+		expectMustangSnapshot(mock, Mustang{ID: mustangID, Name: "Foobar", Version: 2}, true)
+		mock.ExpectExec("UPDATE mustangs").
+			WithArgs(args...).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		expectMustangEvent(mock, EventMustangRestored, Mustang{ID: mustangID, Name: "Foobar", Version: 3}, false)
+		mock.ExpectCommit()
+
+		err = store.Mustang.Restore(context.Background(), mustangID)
+		assert.NoError(t, err, "Expecting no query error")
+
+		err = mock.ExpectationsWereMet()
+		assert.NoError(t, err, "Expecting all mock conditions to be met")
+	})
+
+	// ensures that restoring a record that was never deleted is handled correctly
+	t.Run("Restoring a record that is not deleted", func(t *testing.T) {
+		store, mock, err := NewTestDB(stmt)
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "test setup failed")
+		}
+
+		mock.ExpectBegin()
+		expectMustangSnapshot(mock, Mustang{ID: mustangID, Name: "Foobar", Version: 2}, false)
+		mock.ExpectExec("UPDATE mustangs").
+			WithArgs(args...).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery("SELECT deleted mustangs").
+			WithArgs(args...).
+			WillReturnRows(sqlmock.NewRows([]string{"deleted"}).AddRow(false))
+		mock.ExpectRollback()
+
+		err = store.Mustang.Restore(context.Background(), mustangID)
+		assert.EqualError(t, err, "Error executing restore mustang - 72bc87f3-4a9f-4d05-93fe-844d3cd94c65: the record you are attempting to restore is not deleted", "Expecting not deleted error")
+
+		err = mock.ExpectationsWereMet()
+		assert.NoError(t, err, "Expecting all mock conditions to be met")
+	})
+
+	// ensures that restoring a non existent record is handled correctly
+	t.Run("Restoring a non existent record", func(t *testing.T) {
+		store, mock, err := NewTestDB(stmt)
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "test setup failed")
+		}
+
+		mock.ExpectBegin()
+		expectNoMustangSnapshot(mock, mustangID)
+		mock.ExpectExec("UPDATE mustangs").
+			WithArgs(args...).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery("SELECT deleted mustangs").
+			WithArgs(args...).
+			WillReturnError(sql.ErrNoRows)
+		mock.ExpectRollback()
+
+		err = store.Mustang.Restore(context.Background(), mustangID)
+		assert.ErrorIs(t, err, ErrNotFound, "Expecting not found error")
+
+		err = mock.ExpectationsWereMet()
+		assert.NoError(t, err, "Expecting all mock conditions to be met")
+	})
+}
+
+func TestMustangService_patch(t *testing.T) {
+	mustangID := uuid.MustParse("72bc87f3-4a9f-4d05-93fe-844d3cd94c65")
+	stmt := withOutbox(map[string]string{
+		"update-mustang":      "UPDATE mustangs SET ALL",
+		"get-mustang-version": "SELECT version",
+	})
+	input := &Mustang{
+		ID:      mustangID,
+		Name:    "Foobar",
+		Version: 2,
+	}
+
+	// ensures only the masked columns are written
+	t.Run("Masked fields", func(t *testing.T) {
+		store, mock, err := NewTestDB(stmt)
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "test setup failed")
+		}
+
+		mock.ExpectBegin()
+		expectMustangSnapshot(mock, Mustang{ID: mustangID, Name: "Foobar", Version: 2}, false)
+		mock.ExpectExec(`SET\s+name = \?,\s+version = version \+ 1`).
+			WithArgs("Foobar", "72bc87f3-4a9f-4d05-93fe-844d3cd94c65", 2, 2).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		expectMustangEvent(mock, EventMustangUpdated, Mustang{ID: mustangID, Name: "Foobar", Version: 3}, false)
+		mock.ExpectCommit()
+
+		err = store.Mustang.Patch(context.Background(), input, []string{"name", "name"})
+		assert.NoError(t, err, "Expecting no query error")
+
+		err = mock.ExpectationsWereMet()
+		assert.NoError(t, err, "Expecting all mock conditions to be met")
+	})
+
+	// ensures an empty mask falls back to a full update
+	t.Run("Empty mask", func(t *testing.T) {
+		store, mock, err := NewTestDB(stmt)
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "test setup failed")
+		}
+
+		mock.ExpectBegin()
+		expectMustangSnapshot(mock, Mustang{ID: mustangID, Name: "Foobar", Version: 2}, false)
+		mock.ExpectExec("UPDATE mustangs SET ALL").
+			WithArgs("Foobar", "72bc87f3-4a9f-4d05-93fe-844d3cd94c65", 2, 2).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		expectMustangEvent(mock, EventMustangUpdated, Mustang{ID: mustangID, Name: "Foobar", Version: 3}, false)
+		mock.ExpectCommit()
+
+		err = store.Mustang.Patch(context.Background(), input, nil)
+		assert.NoError(t, err, "Expecting no query error")
+
+		err = mock.ExpectationsWereMet()
+		assert.NoError(t, err, "Expecting all mock conditions to be met")
+	})
+
+	// ensures a mask naming an unknown field is rejected without a query
+	t.Run("Unknown field", func(t *testing.T) {
+		store, mock, err := NewTestDB(stmt)
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "test setup failed")
+		}
+
+		err = store.Mustang.Patch(context.Background(), input, []string{"version"})
+		assert.ErrorIs(t, err, ErrInvalidField, "Expecting an invalid field error")
+
+		err = mock.ExpectationsWereMet()
+		assert.NoError(t, err, "Expecting all mock conditions to be met")
+	})
+
+	// ensures patching a soft deleted record is not found, without attempting the write
+	t.Run("Patching a deleted record", func(t *testing.T) {
+		store, mock, err := NewTestDB(stmt)
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "test setup failed")
+		}
+
+		mock.ExpectBegin()
+		expectMustangSnapshot(mock, Mustang{ID: mustangID, Name: "Foobar", Version: 2}, true)
+		mock.ExpectRollback()
+
+		err = store.Mustang.Patch(context.Background(), input, []string{"name"})
+		assert.ErrorIs(t, err, ErrNotFound, "Expecting not found error")
+
+		err = mock.ExpectationsWereMet()
+		assert.NoError(t, err, "Expecting all mock conditions to be met")
+	})
+}
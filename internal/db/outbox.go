@@ -0,0 +1,228 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/caring/go-packages/pkg/errors"
+	"github.com/google/uuid"
+)
+
+// Event types written to the outbox when a mustang changes
+const (
+	EventMustangCreated  = "mustang.created"
+	EventMustangUpdated  = "mustang.updated"
+	EventMustangDeleted  = "mustang.deleted"
+	EventMustangRestored = "mustang.restored"
+)
+
+// MustangEvent is the state of a mustang carried by its change events and audit history
+type MustangEvent struct {
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	Version int64  `json:"version"`
+	Deleted bool   `json:"deleted"`
+}
+
+// OutboxEvent is a change event written to the outbox in the transaction that made the change
+type OutboxEvent struct {
+	ID          int64
+	EventType   string
+	AggregateID uuid.UUID
+	Payload     []byte
+	CreatedAt   time.Time
+}
+
+// Publisher delivers outbox events to downstream consumers. Publish may be called more than
+// once for the same event, consumers should use OutboxEvent.ID to drop duplicates. Publish
+// should give up once ctx is done, the event's row stays locked until it returns
+type Publisher interface {
+	Publish(ctx context.Context, event *OutboxEvent) error
+}
+
+// jsonArg encodes v as the argument for a JSON column. it is sent as a string, MySQL refuses JSON from a binary string
+func jsonArg(v interface{}) (string, error) {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return string(raw), nil
+}
+
+// inTx runs fn in the tx from ctx, or in a new one when ctx has none, so that a mutation and
+// its outbox event commit or roll back together. when useTx is set the tx from ctx is required
+func (svc *mustangService) inTx(ctx context.Context, useTx bool, fn func(ctx context.Context) error) error {
+	if _, err := FromCtx(ctx); err == nil {
+		return fn(ctx)
+	} else if useTx {
+		return err
+	}
+
+	return svc.withTx(ctx, nil, fn)
+}
+
+// recordEvent writes an event for the mustang to the outbox and its audit history within the tx from
+// ctx. the mustang is read back so the payload holds what the transaction wrote, before is the mustang
+// as it was read ahead of the write and is nil for a create. the change is also queued for watchers,
+// it is broadcast when the transaction commits. the mustang is returned as the transaction wrote it
+func (svc *mustangService) recordEvent(ctx context.Context, eventType string, ID uuid.UUID, before *MustangEvent) (*Mustang, error) {
+	errMsg := func() string { return "Error executing record mustang event - " + eventType + " " + ID.String() }
+
+	create, err := txStmt(ctx, true, svc.stmts["create-outbox-event"])
+	if err != nil {
+		return nil, err
+	}
+
+	after, err := svc.snapshot(ctx, ID)
+	if err != nil {
+		return nil, errors.Wrap(err, errMsg())
+	}
+	if after == nil {
+		return nil, errors.Wrap(ErrNotFound, errMsg())
+	}
+
+	payload, err := jsonArg(after)
+	if err != nil {
+		return nil, errors.Wrap(err, errMsg())
+	}
+
+	if _, err = create.ExecContext(ctx, eventType, ID, payload); err != nil {
+		return nil, errors.Wrap(err, errMsg())
+	}
+
+	if err = svc.recordHistory(ctx, eventType, ID, before, after); err != nil {
+		return nil, err
+	}
+
+	m := Mustang{ID: ID, Name: after.Name, Version: after.Version}
+	queueChange(ctx, Change{
+		EventType: eventType,
+		Mustang:   m,
+	})
+
+	return &m, nil
+}
+
+// snapshot reads the mustang as it stands within the tx from ctx, soft deleted or not.
+// a mustang that does not exist is nil
+func (svc *mustangService) snapshot(ctx context.Context, ID uuid.UUID) (*MustangEvent, error) {
+	get, err := txStmt(ctx, true, svc.stmts["get-mustang-event"])
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		m       Mustang
+		deleted bool
+	)
+	err = get.QueryRowContext(ctx, ID).
+		Scan(&m.ID, &m.Name, &m.Version, &deleted)
+	if err != nil {
+
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	return &MustangEvent{
+		ID:      m.ID.String(),
+		Name:    m.Name,
+		Version: m.Version,
+		Deleted: deleted,
+	}, nil
+}
+
+// RelayOutbox publishes up to batchSize pending outbox events in the order they were written and
+// marks them sent, returning how many were sent. Publishing stops at the first failure so an event
+// is never delivered before the events written ahead of it, the events published before the failure
+// are still marked sent. Delivery is at least once, an event is published again if marking it sent
+// does not commit. The batch is row locked without waiting, so a relay that finds an event locked by another relay,
+// or by a transaction still writing it, sends nothing and leaves the batch for a later run. Relays
+// never overtake each other that way. The rows stay locked while the batch is published to the
+// sink, each publish is given publishTimeout so a slow sink can't hold them indefinitely
+func (s *Store) RelayOutbox(ctx context.Context, publisher Publisher, batchSize int, publishTimeout time.Duration) (int, error) {
+	errMsg := func() string { return "Error executing relay outbox - " + strconv.Itoa(batchSize) }
+
+	var (
+		sent   int
+		pubErr error
+	)
+
+	err := s.WithTx(ctx, nil, func(ctx context.Context) error {
+		// a retried transaction starts over
+		sent, pubErr = 0, nil
+
+		events, err := s.pendingEvents(ctx, batchSize)
+		if isLockNowait(err) {
+			return nil
+		} else if err != nil {
+			return errors.Wrap(err, errMsg())
+		}
+
+		mark, err := txStmt(ctx, true, s.stmts["mark-outbox-sent"])
+		if err != nil {
+			return err
+		}
+
+		for _, e := range events {
+			if err = publish(ctx, publisher, e, publishTimeout); err != nil {
+				pubErr = errors.Wrap(err, errMsg()+" - publish "+strconv.FormatInt(e.ID, 10))
+				break
+			}
+
+			if _, err = mark.ExecContext(ctx, e.ID); err != nil {
+				return errors.Wrap(err, errMsg())
+			}
+			sent++
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return sent, pubErr
+}
+
+// publish publishes a single event, giving up after timeout
+func publish(ctx context.Context, publisher Publisher, e *OutboxEvent, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	return publisher.Publish(ctx, e)
+}
+
+// pendingEvents locks and reads a batch of unsent outbox events within the tx from ctx
+func (s *Store) pendingEvents(ctx context.Context, batchSize int) ([]*OutboxEvent, error) {
+	stmt, err := txStmt(ctx, true, s.stmts["list-outbox-pending"])
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := stmt.QueryContext(ctx, batchSize)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := make([]*OutboxEvent, 0, batchSize)
+	for rows.Next() {
+		e := OutboxEvent{}
+		if err = rows.Scan(&e.ID, &e.EventType, &e.AggregateID, &e.Payload, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		events = append(events, &e)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
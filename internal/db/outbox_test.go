@@ -0,0 +1,140 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-sql-driver/mysql"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+// testPublisher records the events it publishes and fails on the event IDs it is told to. Events
+// it is told to block on are only given up on when ctx is done
+type testPublisher struct {
+	published []int64
+	failOn    map[int64]error
+	blockOn   map[int64]bool
+}
+
+func (p *testPublisher) Publish(ctx context.Context, event *OutboxEvent) error {
+	if err, ok := p.failOn[event.ID]; ok {
+		return err
+	}
+	if p.blockOn[event.ID] {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	p.published = append(p.published, event.ID)
+	return nil
+}
+
+func TestStore_RelayOutbox(t *testing.T) {
+	mustangID := uuid.MustParse("72bc87f3-4a9f-4d05-93fe-844d3cd94c65")
+	createdAt := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	stmt := map[string]string{
+		"list-outbox-pending": "SELECT outbox",
+		"mark-outbox-sent":    "UPDATE outbox",
+	}
+	rows := func() *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"outbox_id", "event_type", "aggregate_id", "payload", "created_at"}).
+			AddRow(1, EventMustangCreated, mustangID[:], []byte(`{"version":1}`), createdAt).
+			AddRow(2, EventMustangUpdated, mustangID[:], []byte(`{"version":2}`), createdAt)
+	}
+
+	// ensures pending events are published in order and marked sent in one tx
+	t.Run("Publishes pending events", func(t *testing.T) {
+		store, mock, err := NewTestDB(stmt)
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "test setup failed")
+		}
+
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT outbox").
+			WithArgs(10).
+			WillReturnRows(rows())
+		mock.ExpectExec("UPDATE outbox").WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("UPDATE outbox").WithArgs(2).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		publisher := &testPublisher{}
+		sent, err := store.RelayOutbox(context.Background(), publisher, 10, time.Second)
+		assert.NoError(t, err, "Expecting no relay error")
+		assert.Equal(t, 2, sent, "Expected both events to be sent")
+		assert.Equal(t, []int64{1, 2}, publisher.published, "Expected the events in the order they were written")
+
+		err = mock.ExpectationsWereMet()
+		assert.NoError(t, err, "Expecting all mock conditions to be met")
+	})
+
+	// ensures a failed publish stops the batch and keeps the events sent before it
+	t.Run("Failed publish", func(t *testing.T) {
+		store, mock, err := NewTestDB(stmt)
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "test setup failed")
+		}
+
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT outbox").
+			WithArgs(10).
+			WillReturnRows(rows())
+		mock.ExpectExec("UPDATE outbox").WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		publisher := &testPublisher{failOn: map[int64]error{2: assert.AnError}}
+		sent, err := store.RelayOutbox(context.Background(), publisher, 10, time.Second)
+		assert.ErrorIs(t, err, assert.AnError, "Expecting the publish error")
+		assert.Equal(t, 1, sent, "Expected only the first event to be sent")
+
+		err = mock.ExpectationsWereMet()
+		assert.NoError(t, err, "Expecting all mock conditions to be met")
+	})
+
+	// ensures a sink that never answers is given up on so the batch's row locks are released
+	t.Run("Publish timeout", func(t *testing.T) {
+		store, mock, err := NewTestDB(stmt)
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "test setup failed")
+		}
+
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT outbox").
+			WithArgs(10).
+			WillReturnRows(rows())
+		mock.ExpectExec("UPDATE outbox").WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		publisher := &testPublisher{blockOn: map[int64]bool{2: true}}
+		sent, err := store.RelayOutbox(context.Background(), publisher, 10, 10*time.Millisecond)
+		assert.ErrorIs(t, err, context.DeadlineExceeded, "Expecting the publish to time out")
+		assert.Equal(t, 1, sent, "Expected only the first event to be sent")
+
+		err = mock.ExpectationsWereMet()
+		assert.NoError(t, err, "Expecting all mock conditions to be met")
+	})
+
+	// ensures a batch another relay holds is left to it rather than overtaken
+	t.Run("Batch locked", func(t *testing.T) {
+		store, mock, err := NewTestDB(stmt)
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "test setup failed")
+		}
+
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT outbox").
+			WithArgs(10).
+			WillReturnError(&mysql.MySQLError{Number: mysqlErrLockNowait})
+		mock.ExpectCommit()
+
+		publisher := &testPublisher{}
+		sent, err := store.RelayOutbox(context.Background(), publisher, 10, time.Second)
+		assert.NoError(t, err, "Expecting no relay error")
+		assert.Equal(t, 0, sent, "Expected nothing to be sent")
+		assert.Empty(t, publisher.published, "Expected nothing to be published")
+
+		err = mock.ExpectationsWereMet()
+		assert.NoError(t, err, "Expecting all mock conditions to be met")
+	})
+}
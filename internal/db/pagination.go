@@ -0,0 +1,112 @@
+package db
+
+import (
+	"encoding/base64"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/caring/go-packages/pkg/errors"
+	"github.com/google/uuid"
+)
+
+const (
+	// DefaultPageSize is used when a list request does not specify a page size
+	DefaultPageSize = 50
+	// MaxPageSize is the largest page a list request may ask for
+	MaxPageSize = 1000
+	// MaxBatchSize is the largest number of keys a batch request may ask for
+	MaxBatchSize = 1000
+)
+
+// Cursor is a keyset position over (created_at, id) used to page through a table
+type Cursor struct {
+	CreatedAt time.Time
+	ID        uuid.UUID
+}
+
+// Encode serializes a cursor into an opaque page token
+func (c *Cursor) Encode() string {
+	if c == nil {
+		return ""
+	}
+	raw := c.CreatedAt.UTC().Format(time.RFC3339Nano) + "|" + c.ID.String()
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// DecodeCursor parses an opaque page token back into a cursor, an empty
+// token returns a nil cursor which represents the first page
+func DecodeCursor(token string) (*Cursor, error) {
+	if token == "" {
+		return nil, nil
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, errors.Wrap(ErrInvalidPageToken, err.Error())
+	}
+
+	parts := strings.SplitN(string(raw), "|", 2)
+	if len(parts) != 2 {
+		return nil, errors.Wrap(ErrInvalidPageToken, "malformed cursor")
+	}
+
+	createdAt, err := time.Parse(time.RFC3339Nano, parts[0])
+	if err != nil {
+		return nil, errors.Wrap(ErrInvalidPageToken, err.Error())
+	}
+
+	ID, err := uuid.Parse(parts[1])
+	if err != nil {
+		return nil, errors.Wrap(ErrInvalidPageToken, err.Error())
+	}
+
+	return &Cursor{CreatedAt: createdAt, ID: ID}, nil
+}
+
+// EncodeHistoryToken serializes the ID of the last history entry of a page into an opaque
+// page token, an ID of 0 means there are no more pages and gives an empty token
+func EncodeHistoryToken(after int64) string {
+	if after == 0 {
+		return ""
+	}
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(after, 10)))
+}
+
+// DecodeHistoryToken parses an opaque page token back into the ID of a history entry,
+// an empty token returns 0 which represents the first page
+func DecodeHistoryToken(token string) (int64, error) {
+	if token == "" {
+		return 0, nil
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return 0, errors.Wrap(ErrInvalidPageToken, err.Error())
+	}
+
+	after, err := strconv.ParseInt(string(raw), 10, 64)
+	if err != nil || after <= 0 {
+		return 0, errors.Wrap(ErrInvalidPageToken, "malformed history token")
+	}
+
+	return after, nil
+}
+
+// PageSize clamps a requested page size to the allowed range
+func PageSize(requested int32) int {
+	switch {
+	case requested <= 0:
+		return DefaultPageSize
+	case requested > MaxPageSize:
+		return MaxPageSize
+	default:
+		return int(requested)
+	}
+}
+
+// pageFetchLimit is the number of rows to fetch for a page of limit rows, one extra row
+// is fetched to know if another page follows this one
+func pageFetchLimit(limit int) int {
+	return limit + 1
+}
@@ -0,0 +1,67 @@
+package db
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCursor(t *testing.T) {
+	// ensures a cursor survives a round trip through its page token
+	t.Run("Round trip", func(t *testing.T) {
+		c := &Cursor{
+			CreatedAt: time.Date(2020, 1, 2, 3, 4, 5, 6, time.UTC),
+			ID:        uuid.MustParse("72bc87f3-4a9f-4d05-93fe-844d3cd94c65"),
+		}
+
+		result, err := DecodeCursor(c.Encode())
+		assert.NoError(t, err, "Expected no error from decode")
+		assert.True(t, c.CreatedAt.Equal(result.CreatedAt), "Expected created at to match")
+		assert.Equal(t, c.ID, result.ID, "Expected IDs to match")
+	})
+
+	// ensures an empty token is the first page
+	t.Run("Empty token", func(t *testing.T) {
+		result, err := DecodeCursor("")
+		assert.NoError(t, err, "Expected no error from decode")
+		assert.Nil(t, result, "Expected a nil cursor")
+		assert.Equal(t, "", result.Encode(), "Expected a nil cursor to encode to an empty token")
+	})
+
+	// ensures garbage tokens are rejected
+	t.Run("Invalid token", func(t *testing.T) {
+		_, err := DecodeCursor("not-a-token")
+		assert.ErrorIs(t, err, ErrInvalidPageToken, "Expected an invalid page token error")
+	})
+}
+
+func TestPageSize(t *testing.T) {
+	assert.Equal(t, DefaultPageSize, PageSize(0), "Expected default page size")
+	assert.Equal(t, 10, PageSize(10), "Expected requested page size")
+	assert.Equal(t, MaxPageSize, PageSize(MaxPageSize+1), "Expected page size to be capped")
+}
+
+func TestHistoryToken(t *testing.T) {
+	// ensures a history entry ID survives a round trip through its page token
+	t.Run("Round trip", func(t *testing.T) {
+		result, err := DecodeHistoryToken(EncodeHistoryToken(42))
+		assert.NoError(t, err, "Expected no error from decode")
+		assert.Equal(t, int64(42), result, "Expected IDs to match")
+	})
+
+	// ensures an empty token is the first page
+	t.Run("Empty token", func(t *testing.T) {
+		result, err := DecodeHistoryToken("")
+		assert.NoError(t, err, "Expected no error from decode")
+		assert.Equal(t, int64(0), result, "Expected the first page")
+		assert.Equal(t, "", EncodeHistoryToken(0), "Expected no more pages to encode to an empty token")
+	})
+
+	// ensures garbage tokens are rejected
+	t.Run("Invalid token", func(t *testing.T) {
+		_, err := DecodeHistoryToken("not-a-token")
+		assert.ErrorIs(t, err, ErrInvalidPageToken, "Expected an invalid page token error")
+	})
+}
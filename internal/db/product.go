@@ -0,0 +1,295 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/caring/go-packages/pkg/errors"
+	"github.com/google/uuid"
+
+	"github.com/caring/ford-mustang/pb"
+)
+
+// productService provides an API for interacting with the products table
+type productService struct {
+	db       *sql.DB
+	stmts    map[string]*sql.Stmt
+	replicas *replicaSet
+}
+
+// Product is a struct representation of a row in the products table
+type Product struct {
+	ID         uuid.UUID
+	CategoryID uuid.UUID
+	Name       string
+}
+
+// protoProduct is an interface that most proto product objects will satisfy
+type protoProduct interface {
+	GetCategoryId() string
+	GetName() string
+}
+
+// NewProduct is a convenience helper cast a proto product to it's DB layer struct
+func NewProduct(ID string, proto protoProduct) (*Product, error) {
+	pID, err := ParseUUID(ID)
+	if err != nil {
+		return nil, err
+	}
+
+	cID, err := ParseUUID(proto.GetCategoryId())
+	if err != nil {
+		return nil, err
+	}
+
+	return &Product{
+		ID:         pID,
+		CategoryID: cID,
+		Name:       proto.GetName(),
+	}, nil
+}
+
+// ToProto casts a db product into a proto response object
+func (p *Product) ToProto() *pb.ProductResponse {
+	return &pb.ProductResponse{
+		Id:         p.ID.String(),
+		CategoryId: p.CategoryID.String(),
+		Name:       p.Name,
+	}
+}
+
+// Get fetches a single product from the db
+func (svc *productService) Get(ctx context.Context, ID uuid.UUID) (*Product, error) {
+	return svc.get(ctx, false, ID)
+}
+
+// GetTx fetches a single product from the db inside of a tx from ctx
+func (svc *productService) GetTx(ctx context.Context, ID uuid.UUID) (*Product, error) {
+	return svc.get(ctx, true, ID)
+}
+
+// get fetches a single product from the db
+func (svc *productService) get(ctx context.Context, useTx bool, ID uuid.UUID) (*Product, error) {
+	errMsg := func() string { return "Error executing get product - " + fmt.Sprint(ID) }
+
+	stmt, err := svc.replicas.readStmt(ctx, useTx, "get-product", svc.stmts)
+	if err != nil {
+		return nil, err
+	}
+
+	p := Product{}
+
+	err = stmt.QueryRowContext(ctx, ID).
+		Scan(&p.ID, &p.CategoryID, &p.Name)
+	if err != nil {
+
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, errors.Wrap(ErrNotFound, errMsg())
+		}
+
+		return nil, errors.Wrap(err, errMsg())
+	}
+
+	return &p, nil
+}
+
+// Create a new product
+func (svc *productService) Create(ctx context.Context, input *Product) error {
+	return svc.create(ctx, false, input)
+}
+
+// CreateTx creates a new product withing a tx from ctx
+func (svc *productService) CreateTx(ctx context.Context, input *Product) error {
+	return svc.create(ctx, true, input)
+}
+
+// create a new product. if useTx = true then it will attempt to create the product within a transaction
+// from context.
+func (svc *productService) create(ctx context.Context, useTx bool, input *Product) error {
+	errMsg := func() string { return "Error executing create product - " + fmt.Sprint(input) }
+
+	stmt, err := txStmt(ctx, useTx, svc.stmts["create-product"])
+	if err != nil {
+		return err
+	}
+
+	result, err := stmt.ExecContext(ctx, input.ID, input.CategoryID, input.Name)
+	if err != nil {
+		return errors.Wrap(translateDriverError(err), errMsg())
+	}
+
+	rowCount, err := result.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, errMsg())
+	}
+
+	if rowCount == 0 {
+		return errors.Wrap(ErrNotCreated, errMsg())
+	}
+
+	return nil
+}
+
+// Update updates a single product row in the DB
+func (svc *productService) Update(ctx context.Context, input *Product) error {
+	return svc.update(ctx, false, input)
+}
+
+// UpdateTx updates a single product row in the DB within a tx from ctx
+func (svc *productService) UpdateTx(ctx context.Context, input *Product) error {
+	return svc.update(ctx, true, input)
+}
+
+// update a product. if useTx = true then it will attempt to update the product within a transaction
+// from context. a missing or deleted product is ErrNotFound
+func (svc *productService) update(ctx context.Context, useTx bool, input *Product) error {
+	errMsg := func() string { return "Error executing update product - " + fmt.Sprint(input) }
+
+	stmt, err := txStmt(ctx, useTx, svc.stmts["update-product"])
+	if err != nil {
+		return err
+	}
+	getStmt, err := txStmt(ctx, useTx, svc.stmts["get-product"])
+	if err != nil {
+		return err
+	}
+
+	result, err := stmt.ExecContext(ctx, input.CategoryID, input.Name, input.ID)
+	if err != nil {
+		return errors.Wrap(translateDriverError(err), errMsg())
+	}
+
+	rowCount, err := result.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, errMsg())
+	}
+
+	if rowCount == 0 {
+		// MySQL counts the rows changed rather than matched, so an update that left the row as it
+		// was affects none and the row is looked up to tell that apart from a missing one
+		if err = rowExists(ctx, getStmt, input.ID); err != nil {
+			return errors.Wrap(err, errMsg())
+		}
+	}
+
+	return nil
+}
+
+// Delete sets deleted_at for a single products row and returns the row as deleted
+func (svc *productService) Delete(ctx context.Context, ID uuid.UUID) (*Product, error) {
+	return svc.delete(ctx, false, ID)
+}
+
+// DeleteTx sets deleted_at for a single products row within a tx from ctx and returns the row as deleted
+func (svc *productService) DeleteTx(ctx context.Context, ID uuid.UUID) (*Product, error) {
+	return svc.delete(ctx, true, ID)
+}
+
+// delete a product by setting deleted at. if useTx = true then it will attempt to delete the product within a transaction
+// from context. the row is read back after the write, run it in a tx for the read to see the same write
+func (svc *productService) delete(ctx context.Context, useTx bool, ID uuid.UUID) (*Product, error) {
+	errMsg := func() string { return "Error executing delete product - " + ID.String() }
+
+	stmt, err := txStmt(ctx, useTx, svc.stmts["delete-product"])
+	if err != nil {
+		return nil, err
+	}
+	getStmt, err := txStmt(ctx, useTx, svc.stmts["get-deleted-product"])
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := stmt.ExecContext(ctx, ID)
+	if err != nil {
+		return nil, errors.Wrap(err, errMsg())
+	}
+
+	rowCount, err := result.RowsAffected()
+	if err != nil {
+		return nil, errors.Wrap(err, errMsg())
+	}
+
+	if rowCount == 0 {
+		return nil, errors.Wrap(ErrNotFound, errMsg())
+	}
+
+	p := Product{}
+	err = getStmt.QueryRowContext(ctx, ID).
+		Scan(&p.ID, &p.CategoryID, &p.Name)
+	if err != nil {
+		return nil, errors.Wrap(err, errMsg())
+	}
+
+	return &p, nil
+}
+
+// ListByCategory fetches a page of products in a category following the given cursor, a nil cursor
+// fetches the first page. The returned cursor is nil when there are no more pages
+func (svc *productService) ListByCategory(ctx context.Context, categoryID uuid.UUID, limit int, after *Cursor) ([]*Product, *Cursor, error) {
+	return svc.listByCategory(ctx, false, categoryID, limit, after)
+}
+
+// ListByCategoryTx fetches a page of products in a category inside of a tx from ctx
+func (svc *productService) ListByCategoryTx(ctx context.Context, categoryID uuid.UUID, limit int, after *Cursor) ([]*Product, *Cursor, error) {
+	return svc.listByCategory(ctx, true, categoryID, limit, after)
+}
+
+// listByCategory fetches a page of products in a category using keyset pagination over (created_at, product_id)
+func (svc *productService) listByCategory(ctx context.Context, useTx bool, categoryID uuid.UUID, limit int, after *Cursor) ([]*Product, *Cursor, error) {
+	errMsg := func() string {
+		return "Error executing list products by category - " + categoryID.String() + " " + after.Encode()
+	}
+
+	name := "list-products-by-category"
+	args := []interface{}{categoryID, pageFetchLimit(limit)}
+	if after != nil {
+		name = "list-products-by-category-after"
+		args = []interface{}{categoryID, after.CreatedAt, after.CreatedAt, after.ID, pageFetchLimit(limit)}
+	}
+
+	stmt, err := svc.replicas.readStmt(ctx, useTx, name, svc.stmts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rows, err := stmt.QueryContext(ctx, args...)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, errMsg())
+	}
+	defer rows.Close()
+
+	var (
+		products []*Product
+		next     *Cursor
+		more     bool
+	)
+
+	for rows.Next() {
+		p := Product{}
+		var createdAt time.Time
+
+		if err = rows.Scan(&p.ID, &p.CategoryID, &p.Name, &createdAt); err != nil {
+			return nil, nil, errors.Wrap(err, errMsg())
+		}
+
+		if len(products) == limit {
+			more = true
+			break
+		}
+
+		products = append(products, &p)
+		next = &Cursor{CreatedAt: createdAt, ID: p.ID}
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, nil, errors.Wrap(err, errMsg())
+	}
+
+	if !more {
+		next = nil
+	}
+
+	return products, next, nil
+}
@@ -0,0 +1,253 @@
+package db
+
+import (
+	"context"
+	"database/sql/driver"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-sql-driver/mysql"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/caring/ford-mustang/pb"
+)
+
+// ensures that casting from proto to store structs occurs correctly
+func TestNewProduct(t *testing.T) {
+	productID := uuid.MustParse("72bc87f3-4a9f-4d05-93fe-844d3cd94c65")
+	categoryID := uuid.MustParse("94cc5321-ec44-464f-9008-3d81f5e2c18f")
+	proto := pb.CreateProductRequest{
+		CategoryId: categoryID.String(),
+		Name:       "Foobar",
+	}
+
+	r, err := NewProduct(productID.String(), &proto)
+
+	assert.NoError(t, err, "Expected NewProduct not to error")
+	assert.Equal(t, productID, r.ID, "Expected UUIDs to match")
+	assert.Equal(t, categoryID, r.CategoryID, "Expected category UUIDs to match")
+	assert.Equal(t, proto.Name, r.Name, "Expected name to be correctly assigned")
+}
+
+// ensures that casting from store to proto response occurs correctly
+func TestProduct_ToProto(t *testing.T) {
+	productID := uuid.MustParse("72bc87f3-4a9f-4d05-93fe-844d3cd94c65")
+	categoryID := uuid.MustParse("94cc5321-ec44-464f-9008-3d81f5e2c18f")
+
+	product := &Product{
+		ID:         productID,
+		CategoryID: categoryID,
+		Name:       "foobar",
+	}
+
+	r := product.ToProto()
+
+	assert.Equal(t, productID.String(), r.Id, "Expected field to be mapped back to proto object correctly")
+	assert.Equal(t, categoryID.String(), r.CategoryId, "Expected field to be mapped back to proto object correctly")
+	assert.Equal(t, "foobar", r.Name, "Expected field to be mapped back to proto object correctly")
+}
+
+func TestProductService_create(t *testing.T) {
+	productID := uuid.MustParse("72bc87f3-4a9f-4d05-93fe-844d3cd94c65")
+	categoryID := uuid.MustParse("94cc5321-ec44-464f-9008-3d81f5e2c18f")
+	stmt := map[string]string{
+		"create-product": "INSERT products",
+	}
+	input := &Product{
+		ID:         productID,
+		CategoryID: categoryID,
+		Name:       "Foobar",
+	}
+	args := []driver.Value{
+		"72bc87f3-4a9f-4d05-93fe-844d3cd94c65",
+		"94cc5321-ec44-464f-9008-3d81f5e2c18f",
+		"Foobar",
+	}
+
+	// ensures that execution outside of a transaction occurs without error
+	t.Run("Without a provided transaction", func(t *testing.T) {
+		store, mock, err := NewTestDB(stmt)
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "test setup failed")
+		}
+
+		mock.ExpectExec("INSERT products").
+			WithArgs(args...).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		err = store.Product.Create(context.Background(), input)
+		assert.NoError(t, err, "Expecting no query error")
+
+		err = mock.ExpectationsWereMet()
+		assert.NoError(t, err, "Expecting all mock conditions to be met")
+	})
+
+	// ensures that a missing category is reported as an invalid reference
+	t.Run("Missing category", func(t *testing.T) {
+		store, mock, err := NewTestDB(stmt)
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "test setup failed")
+		}
+
+		mock.ExpectExec("INSERT products").
+			WithArgs(args...).
+			WillReturnError(&mysql.MySQLError{Number: mysqlErrNoReferencedRow, Message: "Cannot add or update a child row"})
+
+		err = store.Product.Create(context.Background(), input)
+		assert.ErrorIs(t, err, ErrInvalidReference, "Expecting an invalid reference error")
+
+		err = mock.ExpectationsWereMet()
+		assert.NoError(t, err, "Expecting all mock conditions to be met")
+	})
+}
+
+func TestProductService_update(t *testing.T) {
+	productID := uuid.MustParse("72bc87f3-4a9f-4d05-93fe-844d3cd94c65")
+	categoryID := uuid.MustParse("94cc5321-ec44-464f-9008-3d81f5e2c18f")
+	stmt := map[string]string{
+		"update-product": "UPDATE products",
+		"get-product":    "SELECT products",
+	}
+	input := &Product{
+		ID:         productID,
+		CategoryID: categoryID,
+		Name:       "Foobar",
+	}
+	args := []driver.Value{
+		"94cc5321-ec44-464f-9008-3d81f5e2c18f",
+		"Foobar",
+		"72bc87f3-4a9f-4d05-93fe-844d3cd94c65",
+	}
+
+	// ensures that execution outside of a transaction occurs without error
+	t.Run("Without a provided transaction", func(t *testing.T) {
+		store, mock, err := NewTestDB(stmt)
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "test setup failed")
+		}
+
+		mock.ExpectExec("UPDATE products").
+			WithArgs(args...).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		err = store.Product.Update(context.Background(), input)
+		assert.NoError(t, err, "Expecting no query error")
+
+		err = mock.ExpectationsWereMet()
+		assert.NoError(t, err, "Expecting all mock conditions to be met")
+	})
+
+	// ensures that updating a missing or deleted record is not found
+	t.Run("Updating a non existent record", func(t *testing.T) {
+		store, mock, err := NewTestDB(stmt)
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "test setup failed")
+		}
+
+		mock.ExpectExec("UPDATE products").
+			WithArgs(args...).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery("SELECT products").
+			WithArgs("72bc87f3-4a9f-4d05-93fe-844d3cd94c65").
+			WillReturnRows(sqlmock.NewRows([]string{"product_id", "category_id", "name"}))
+
+		err = store.Product.Update(context.Background(), input)
+		assert.ErrorIs(t, err, ErrNotFound, "Expecting not found error")
+
+		err = mock.ExpectationsWereMet()
+		assert.NoError(t, err, "Expecting all mock conditions to be met")
+	})
+}
+
+func TestProductService_delete(t *testing.T) {
+	productID := uuid.MustParse("72bc87f3-4a9f-4d05-93fe-844d3cd94c65")
+	categoryID := uuid.MustParse("94cc5321-ec44-464f-9008-3d81f5e2c18f")
+	stmt := map[string]string{
+		"delete-product":      "UPDATE products",
+		"get-deleted-product": "SELECT deleted products",
+	}
+	args := []driver.Value{
+		"72bc87f3-4a9f-4d05-93fe-844d3cd94c65",
+	}
+
+	// ensures the product is returned as it was deleted
+	t.Run("Without a provided transaction", func(t *testing.T) {
+		store, mock, err := NewTestDB(stmt)
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "test setup failed")
+		}
+
+		mock.ExpectExec("UPDATE products").
+			WithArgs(args...).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery("SELECT deleted products").
+			WithArgs(args...).
+			WillReturnRows(
+				sqlmock.NewRows([]string{"product_id", "category_id", "name"}).
+					AddRow(productID[:], categoryID[:], "Foobar"),
+			)
+
+		p, err := store.Product.Delete(context.Background(), productID)
+		assert.NoError(t, err, "Expecting no query error")
+		assert.Equal(t, &Product{ID: productID, CategoryID: categoryID, Name: "Foobar"}, p, "Expected the deleted product to be returned")
+
+		err = mock.ExpectationsWereMet()
+		assert.NoError(t, err, "Expecting all mock conditions to be met")
+	})
+
+	// ensures that deleting a missing or already deleted record is not found
+	t.Run("Deleting a non existent record", func(t *testing.T) {
+		store, mock, err := NewTestDB(stmt)
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "test setup failed")
+		}
+
+		mock.ExpectExec("UPDATE products").
+			WithArgs(args...).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+
+		_, err = store.Product.Delete(context.Background(), productID)
+		assert.ErrorIs(t, err, ErrNotFound, "Expecting not found error")
+
+		err = mock.ExpectationsWereMet()
+		assert.NoError(t, err, "Expecting all mock conditions to be met")
+	})
+}
+
+func TestProductService_listByCategory(t *testing.T) {
+	productID := uuid.MustParse("72bc87f3-4a9f-4d05-93fe-844d3cd94c65")
+	categoryID := uuid.MustParse("94cc5321-ec44-464f-9008-3d81f5e2c18f")
+	createdAt := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	stmt := map[string]string{
+		"list-products-by-category":       "SELECT products FIRST",
+		"list-products-by-category-after": "SELECT products AFTER",
+	}
+
+	// ensures a single page of products is returned without a next cursor
+	t.Run("Single page", func(t *testing.T) {
+		store, mock, err := NewTestDB(stmt)
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "test setup failed")
+		}
+
+		mock.ExpectQuery("SELECT products FIRST").
+			WithArgs("94cc5321-ec44-464f-9008-3d81f5e2c18f", 11).
+			WillReturnRows(
+				sqlmock.NewRows([]string{"product_id", "category_id", "name", "created_at"}).
+					AddRow(productID[:], categoryID[:], "Foobar", createdAt),
+			)
+
+		r, next, err := store.Product.ListByCategory(context.Background(), categoryID, 10, nil)
+		assert.NoError(t, err, "Expecting no query error")
+
+		assert.Len(t, r, 1, "Expected the category's products to be returned")
+		assert.Equal(t, productID, r[0].ID, "Expected correct product ID to be returned")
+		assert.Equal(t, categoryID, r[0].CategoryID, "Expected correct category ID to be returned")
+		assert.Nil(t, next, "Expected no cursor on the last page")
+
+		err = mock.ExpectationsWereMet()
+		assert.NoError(t, err, "Expecting all mock conditions to be met")
+	})
+}
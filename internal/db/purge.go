@@ -0,0 +1,126 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"github.com/caring/go-packages/pkg/errors"
+)
+
+// purgeTarget is a soft deleted table and the statement that hard deletes a batch of its rows
+type purgeTarget struct {
+	Table string
+	stmt  string
+	// expires is set for tables whose rows carry their own expiry, they are deleted once
+	// expired rather than once they age out of the retention window
+	expires bool
+	// cascade is a table holding copies of the purged rows, its rows for a batch are deleted
+	// in the batch's transaction just before the batch itself
+	cascade *purgeTarget
+}
+
+// purgeTargets are purged in order, products go before categories so that
+// categories emptied by the products purge can be removed in the same run.
+// the outbox is purged of events that were sent rather than soft deleted, and the history
+// of a mustang is purged with it
+var purgeTargets = []purgeTarget{
+	{Table: "products", stmt: "purge-products"},
+	{Table: "categories", stmt: "purge-categories"},
+	{Table: "mustangs", stmt: "purge-mustangs", cascade: &purgeTarget{Table: "mustang_history", stmt: "purge-mustang-history"}},
+	{Table: "outbox", stmt: "purge-outbox"},
+	{Table: "idempotency_keys", stmt: "purge-idempotency-keys", expires: true},
+}
+
+// PurgeCount is the number of rows permanently deleted from a table
+type PurgeCount struct {
+	Table string
+	Count int64
+}
+
+// Purge permanently deletes rows that were soft deleted before the cutoff. Rows are deleted
+// in batches of batchSize, each batch is committed in its own transaction so locks are held
+// briefly. Expired idempotency keys are deleted whatever the cutoff. The counts of rows deleted
+// from each table are returned, including when an error interrupts the purge part way through.
+// rows deleted along with another table's batch are counted after that table
+func (s *Store) Purge(ctx context.Context, before time.Time, batchSize int) ([]PurgeCount, error) {
+	counts := make([]PurgeCount, 0, len(purgeTargets))
+
+	for _, target := range purgeTargets {
+		count := PurgeCount{Table: target.Table}
+		var cascaded []PurgeCount
+		if target.cascade != nil {
+			cascaded = append(cascaded, PurgeCount{Table: target.cascade.Table})
+		}
+
+		cutoff := before
+		if target.expires {
+			cutoff = time.Now().UTC()
+		}
+
+		for {
+			n, c, err := s.purgeBatch(ctx, target, cutoff, batchSize)
+			count.Count += n
+			if len(cascaded) > 0 {
+				cascaded[0].Count += c
+			}
+			if err != nil {
+				return append(append(counts, count), cascaded...), err
+			}
+			if n < int64(batchSize) {
+				break
+			}
+		}
+
+		counts = append(append(counts, count), cascaded...)
+	}
+
+	return counts, nil
+}
+
+// purgeBatch permanently deletes a single batch of soft deleted rows within a transaction, along
+// with the target's cascade rows for the batch. The counts deleted from each are returned, a batch
+// that deadlocks is retried like any other transaction
+func (s *Store) purgeBatch(ctx context.Context, target purgeTarget, before time.Time, batchSize int) (int64, int64, error) {
+	errMsg := func() string { return "Error executing purge " + target.Table + " - " + before.String() }
+
+	var rowCount, cascaded int64
+	err := s.WithTx(ctx, nil, func(ctx context.Context) error {
+		// a retried transaction starts over
+		rowCount, cascaded = 0, 0
+
+		if target.cascade != nil {
+			n, err := s.purgeRows(ctx, target.cascade.stmt, before, batchSize)
+			if err != nil {
+				return err
+			}
+			cascaded = n
+		}
+
+		n, err := s.purgeRows(ctx, target.stmt, before, batchSize)
+		if err != nil {
+			return err
+		}
+		rowCount = n
+		return nil
+	})
+	if err != nil {
+		return 0, 0, errors.Wrap(err, errMsg())
+	}
+
+	return rowCount, cascaded, nil
+}
+
+// purgeRows runs the named purge statement within the tx from ctx and returns how many rows it deleted
+func (s *Store) purgeRows(ctx context.Context, name string, before time.Time, batchSize int) (int64, error) {
+	stmt, err := txStmt(ctx, true, s.stmts[name])
+	if err != nil {
+		return 0, err
+	}
+
+	result, err := stmt.ExecContext(ctx, before, batchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
@@ -0,0 +1,179 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-sql-driver/mysql"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStore_Purge(t *testing.T) {
+	before := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	stmt := map[string]string{
+		"purge-products":         "DELETE FROM products",
+		"purge-categories":       "DELETE FROM categories",
+		"purge-mustang-history":  "DELETE mustang_history",
+		"purge-mustangs":         "DELETE FROM mustangs",
+		"purge-outbox":           "DELETE FROM outbox",
+		"purge-idempotency-keys": "DELETE FROM idempotency_keys",
+	}
+
+	// ensures each table is purged in batches until a partial batch is deleted
+	t.Run("Purges in batches", func(t *testing.T) {
+		store, mock, err := NewTestDB(stmt)
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "test setup failed")
+		}
+
+		mock.ExpectBegin()
+		mock.ExpectExec("DELETE FROM products").
+			WithArgs(before, 2).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectCommit()
+
+		mock.ExpectBegin()
+		mock.ExpectExec("DELETE FROM categories").
+			WithArgs(before, 2).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		// the history of each batch of mustangs is deleted in the batch's transaction
+		mock.ExpectBegin()
+		mock.ExpectExec("DELETE mustang_history").
+			WithArgs(before, 2).
+			WillReturnResult(sqlmock.NewResult(0, 5))
+		mock.ExpectExec("DELETE FROM mustangs").
+			WithArgs(before, 2).
+			WillReturnResult(sqlmock.NewResult(0, 2))
+		mock.ExpectCommit()
+		mock.ExpectBegin()
+		mock.ExpectExec("DELETE mustang_history").
+			WithArgs(before, 2).
+			WillReturnResult(sqlmock.NewResult(0, 2))
+		mock.ExpectExec("DELETE FROM mustangs").
+			WithArgs(before, 2).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		mock.ExpectBegin()
+		mock.ExpectExec("DELETE FROM outbox").
+			WithArgs(before, 2).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectCommit()
+
+		// expired keys are deleted at the current time, not the retention cutoff
+		mock.ExpectBegin()
+		mock.ExpectExec("DELETE FROM idempotency_keys").
+			WithArgs(sqlmock.AnyArg(), 2).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		counts, err := store.Purge(context.Background(), before, 2)
+		assert.NoError(t, err, "Expecting no query error")
+
+		assert.Equal(t, []PurgeCount{
+			{Table: "products", Count: 0},
+			{Table: "categories", Count: 1},
+			{Table: "mustangs", Count: 3},
+			{Table: "mustang_history", Count: 7},
+			{Table: "outbox", Count: 0},
+			{Table: "idempotency_keys", Count: 1},
+		}, counts, "Expected the rows purged from each table to be counted")
+
+		err = mock.ExpectationsWereMet()
+		assert.NoError(t, err, "Expecting all mock conditions to be met")
+	})
+
+	// ensures a failed batch is rolled back and stops the purge
+	t.Run("Failed batch", func(t *testing.T) {
+		store, mock, err := NewTestDB(stmt)
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "test setup failed")
+		}
+
+		mock.ExpectBegin()
+		mock.ExpectExec("DELETE FROM products").
+			WithArgs(before, 2).
+			WillReturnError(assert.AnError)
+		mock.ExpectRollback()
+
+		counts, err := store.Purge(context.Background(), before, 2)
+		assert.ErrorIs(t, err, assert.AnError, "Expecting the query error")
+		assert.Equal(t, []PurgeCount{{Table: "products", Count: 0}}, counts, "Expected partial counts")
+
+		err = mock.ExpectationsWereMet()
+		assert.NoError(t, err, "Expecting all mock conditions to be met")
+	})
+
+	// ensures a batch that deadlocks is retried rather than ending the purge
+	t.Run("Deadlocked batch", func(t *testing.T) {
+		store, mock, err := NewTestDB(stmt, WithTxRetries(3, time.Millisecond))
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "test setup failed")
+		}
+
+		mock.ExpectBegin()
+		mock.ExpectExec("DELETE FROM products").
+			WithArgs(before, 2).
+			WillReturnError(&mysql.MySQLError{Number: mysqlErrDeadlock, Message: "Deadlock found when trying to get lock"})
+		mock.ExpectRollback()
+		mock.ExpectBegin()
+		mock.ExpectExec("DELETE FROM products").
+			WithArgs(before, 2).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+		for _, table := range []string{"categories", "mustangs", "outbox", "idempotency_keys"} {
+			mock.ExpectBegin()
+			if table == "mustangs" {
+				mock.ExpectExec("DELETE mustang_history").WillReturnResult(sqlmock.NewResult(0, 0))
+			}
+			mock.ExpectExec("DELETE FROM " + table).WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectCommit()
+		}
+
+		counts, err := store.Purge(context.Background(), before, 2)
+		assert.NoError(t, err, "Expecting the deadlock to be retried")
+		assert.Contains(t, counts, PurgeCount{Table: "products", Count: 1}, "Expected the retried batch to be counted once")
+		assert.Equal(t, uint64(1), store.TxRetries(), "Expected a single retry")
+
+		err = mock.ExpectationsWereMet()
+		assert.NoError(t, err, "Expecting all mock conditions to be met")
+	})
+
+	// ensures mustangs are kept when their history can't be deleted
+	t.Run("Failed history", func(t *testing.T) {
+		store, mock, err := NewTestDB(stmt)
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "test setup failed")
+		}
+
+		for _, table := range []string{"products", "categories"} {
+			mock.ExpectBegin()
+			mock.ExpectExec("DELETE FROM "+table).
+				WithArgs(before, 2).
+				WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectCommit()
+		}
+
+		mock.ExpectBegin()
+		mock.ExpectExec("DELETE mustang_history").
+			WithArgs(before, 2).
+			WillReturnError(assert.AnError)
+		mock.ExpectRollback()
+
+		counts, err := store.Purge(context.Background(), before, 2)
+		assert.ErrorIs(t, err, assert.AnError, "Expecting the query error")
+		assert.Equal(t, []PurgeCount{
+			{Table: "products", Count: 0},
+			{Table: "categories", Count: 0},
+			{Table: "mustangs", Count: 0},
+			{Table: "mustang_history", Count: 0},
+		}, counts, "Expected partial counts")
+
+		err = mock.ExpectationsWereMet()
+		assert.NoError(t, err, "Expecting all mock conditions to be met")
+	})
+}
@@ -0,0 +1,142 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"sync/atomic"
+
+	"github.com/caring/go-packages/pkg/errors"
+)
+
+// readStatements are the statements prepared on replicas, everything else only runs on the primary
+var readStatements = []string{
+	"get-mustang",
+	"list-mustangs",
+	"list-mustangs-after",
+	"list-mustang-history",
+	"get-category",
+	"get-product",
+	"list-products-by-category",
+	"list-products-by-category-after",
+}
+
+type primaryCtxKey struct{}
+
+// WithPrimary marks ctx so reads made with it go to the primary rather than a replica,
+// use it to read your own writes when replication lag would otherwise hide them
+func WithPrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryCtxKey{}, true)
+}
+
+// PrimaryFromCtx reports whether ctx has been marked to read from the primary
+func PrimaryFromCtx(ctx context.Context) bool {
+	forced, _ := ctx.Value(primaryCtxKey{}).(bool)
+	return forced
+}
+
+// WithReplicas sets the data source names of read replicas. non transactional reads
+// are spread across the replicas and everything else goes to the primary
+func WithReplicas(dataSourceNames ...string) Option {
+	return func(s *Store) {
+		s.replicaDSNs = append(s.replicaDSNs, dataSourceNames...)
+	}
+}
+
+// replica is a read only connection and the read statements prepared on it
+type replica struct {
+	db    *sql.DB
+	stmts map[string]*sql.Stmt
+}
+
+// replicaSet round robins reads across the configured replicas
+type replicaSet struct {
+	replicas []replica
+	next     uint32
+}
+
+// open connects to each replica and prepares the read statements on it
+func (r *replicaSet) open(driverName string, dataSourceNames []string, unprepared map[string]string) error {
+	reads := map[string]string{}
+	for _, name := range readStatements {
+		if q, ok := unprepared[name]; ok {
+			reads[name] = q
+		}
+	}
+
+	for _, dsn := range dataSourceNames {
+		db, err := sql.Open(driverName, dsn)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		stmts, err := prepareStmts(db, reads)
+		if err != nil {
+			db.Close()
+			return errors.WithStack(err)
+		}
+
+		if err = db.Ping(); err != nil {
+			db.Close()
+			return errors.WithStack(err)
+		}
+
+		r.add(db, stmts)
+	}
+
+	return nil
+}
+
+// add a replica connection with its prepared read statements
+func (r *replicaSet) add(db *sql.DB, stmts map[string]*sql.Stmt) {
+	r.replicas = append(r.replicas, replica{db: db, stmts: stmts})
+}
+
+// pick returns the next replica in turn, ok is false when there are no replicas
+func (r *replicaSet) pick() (replica, bool) {
+	if r == nil || len(r.replicas) == 0 {
+		return replica{}, false
+	}
+	i := atomic.AddUint32(&r.next, 1)
+	return r.replicas[int(i)%len(r.replicas)], true
+}
+
+// close the connection to every replica, returning the first error
+func (r *replicaSet) close() error {
+	var first error
+	for _, rep := range r.replicas {
+		if err := rep.db.Close(); err != nil && first == nil {
+			first = errors.WithStack(err)
+		}
+	}
+	return first
+}
+
+// readStmt returns the statement a read runs with. reads in a tx use the tx, reads marked
+// WithPrimary or without any replicas use the primary and the rest go to a replica
+func (r *replicaSet) readStmt(ctx context.Context, useTx bool, name string, primary map[string]*sql.Stmt) (*sql.Stmt, error) {
+	if _, err := FromCtx(ctx); useTx || err == nil || PrimaryFromCtx(ctx) {
+		return txStmt(ctx, useTx, primary[name])
+	}
+
+	rep, ok := r.pick()
+	if !ok {
+		return primary[name], nil
+	}
+	if stmt, ok := rep.stmts[name]; ok {
+		return stmt, nil
+	}
+	return primary[name], nil
+}
+
+// readConn returns the connection a read that can't be prepared runs on, routed the same way as readStmt
+func (r *replicaSet) readConn(ctx context.Context, useTx bool, primary *sql.DB) (querier, error) {
+	if _, err := FromCtx(ctx); useTx || err == nil || PrimaryFromCtx(ctx) {
+		return txConn(ctx, useTx, primary)
+	}
+
+	rep, ok := r.pick()
+	if !ok {
+		return primary, nil
+	}
+	return rep.db, nil
+}
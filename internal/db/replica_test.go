@@ -0,0 +1,113 @@
+package db
+
+import (
+	"context"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReplicaSet_routing(t *testing.T) {
+	mustangID := uuid.MustParse("72bc87f3-4a9f-4d05-93fe-844d3cd94c65")
+	stmt := withOutbox(map[string]string{
+		"get-mustang":         "SELECT mustangs",
+		"delete-mustang":      "UPDATE mustangs",
+		"get-mustang-version": "SELECT version",
+	})
+
+	// ensures reads outside of a transaction go to the replica
+	t.Run("Reads go to the replica", func(t *testing.T) {
+		store, mock, replica, err := NewTestDBWithReplica(stmt)
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "test setup failed")
+		}
+
+		replica.ExpectQuery("SELECT mustangs").
+			WillReturnRows(
+				sqlmock.NewRows([]string{"mustang_id", "name", "version"}).
+					AddRow(mustangID[:], "Foobar", 1),
+			)
+
+		r, err := store.Mustang.Get(context.Background(), mustangID)
+		assert.NoError(t, err, "Expecting no query error")
+		assert.Equal(t, mustangID, r.ID, "Expected correct mustang ID to be returned")
+
+		assert.NoError(t, mock.ExpectationsWereMet(), "Expecting nothing to run on the primary")
+		assert.NoError(t, replica.ExpectationsWereMet(), "Expecting all replica mock conditions to be met")
+	})
+
+	// ensures WithPrimary sends reads to the primary
+	t.Run("Forced primary read", func(t *testing.T) {
+		store, mock, replica, err := NewTestDBWithReplica(stmt)
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "test setup failed")
+		}
+
+		mock.ExpectQuery("SELECT mustangs").
+			WillReturnRows(
+				sqlmock.NewRows([]string{"mustang_id", "name", "version"}).
+					AddRow(mustangID[:], "Foobar", 1),
+			)
+
+		_, err = store.Mustang.Get(WithPrimary(context.Background()), mustangID)
+		assert.NoError(t, err, "Expecting no query error")
+
+		assert.NoError(t, mock.ExpectationsWereMet(), "Expecting all primary mock conditions to be met")
+		assert.NoError(t, replica.ExpectationsWereMet(), "Expecting nothing to run on the replica")
+	})
+
+	// ensures reads inside a transaction stay on the primary with the writes
+	t.Run("Reads in a transaction", func(t *testing.T) {
+		store, mock, replica, err := NewTestDBWithReplica(stmt)
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "test setup failed")
+		}
+
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT mustangs").
+			WillReturnRows(
+				sqlmock.NewRows([]string{"mustang_id", "name", "version"}).
+					AddRow(mustangID[:], "Foobar", 1),
+			)
+		expectMustangSnapshot(mock, Mustang{ID: mustangID, Name: "Foobar", Version: 1}, false)
+		mock.ExpectExec("UPDATE mustangs").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		expectMustangEvent(mock, EventMustangDeleted, Mustang{ID: mustangID, Name: "Foobar", Version: 2}, true)
+		mock.ExpectCommit()
+
+		err = store.WithTx(context.Background(), nil, func(ctx context.Context) error {
+			if _, err := store.Mustang.Get(ctx, mustangID); err != nil {
+				return err
+			}
+			_, err := store.Mustang.Delete(ctx, mustangID, 0)
+			return err
+		})
+		assert.NoError(t, err, "Expecting no error")
+
+		assert.NoError(t, mock.ExpectationsWereMet(), "Expecting all primary mock conditions to be met")
+		assert.NoError(t, replica.ExpectationsWereMet(), "Expecting nothing to run on the replica")
+	})
+
+	// ensures writes never go to the replica
+	t.Run("Writes go to the primary", func(t *testing.T) {
+		store, mock, replica, err := NewTestDBWithReplica(stmt)
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "test setup failed")
+		}
+
+		mock.ExpectBegin()
+		expectMustangSnapshot(mock, Mustang{ID: mustangID, Name: "Foobar", Version: 1}, false)
+		mock.ExpectExec("UPDATE mustangs").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		expectMustangEvent(mock, EventMustangDeleted, Mustang{ID: mustangID, Name: "Foobar", Version: 2}, true)
+		mock.ExpectCommit()
+
+		_, err = store.Mustang.Delete(context.Background(), mustangID, 0)
+		assert.NoError(t, err, "Expecting no query error")
+
+		assert.NoError(t, mock.ExpectationsWereMet(), "Expecting all primary mock conditions to be met")
+		assert.NoError(t, replica.ExpectationsWereMet(), "Expecting nothing to run on the replica")
+	})
+}
@@ -0,0 +1,54 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/google/uuid"
+)
+
+// MustangRepository is the API for storing mustangs. The SQL store is one implementation,
+// callers that only need mustangs should depend on this rather than a concrete store.
+// Tx variants require a transaction started by the backend's WithTx in ctx
+type MustangRepository interface {
+	Get(ctx context.Context, ID uuid.UUID) (*Mustang, error)
+	GetTx(ctx context.Context, ID uuid.UUID) (*Mustang, error)
+	Create(ctx context.Context, input *Mustang) error
+	CreateTx(ctx context.Context, input *Mustang) error
+	CreateIdempotent(ctx context.Context, key string, input *Mustang) (*Mustang, error)
+	CreateIdempotentTx(ctx context.Context, key string, input *Mustang) (*Mustang, error)
+	Update(ctx context.Context, input *Mustang) error
+	UpdateTx(ctx context.Context, input *Mustang) error
+	Patch(ctx context.Context, input *Mustang, paths []string) error
+	PatchTx(ctx context.Context, input *Mustang, paths []string) error
+	Delete(ctx context.Context, ID uuid.UUID, version int64) (*Mustang, error)
+	DeleteTx(ctx context.Context, ID uuid.UUID, version int64) (*Mustang, error)
+	Restore(ctx context.Context, ID uuid.UUID) error
+	RestoreTx(ctx context.Context, ID uuid.UUID) error
+	List(ctx context.Context, limit int, after *Cursor) ([]*Mustang, *Cursor, error)
+	ListTx(ctx context.Context, limit int, after *Cursor) ([]*Mustang, *Cursor, error)
+	BatchGet(ctx context.Context, IDs []uuid.UUID) ([]*Mustang, error)
+	BatchGetTx(ctx context.Context, IDs []uuid.UUID) ([]*Mustang, error)
+}
+
+// Backend is a storage backend the service can run against
+type Backend interface {
+	// Mustangs returns the backend's mustang repository
+	Mustangs() MustangRepository
+	// WithTx runs fn inside of a transaction carried by the ctx passed to fn
+	WithTx(ctx context.Context, opts *sql.TxOptions, fn func(ctx context.Context) error) error
+	// Ping checks the backend is available
+	Ping(ctx context.Context) error
+	// Close releases the backend's resources
+	Close() error
+}
+
+var (
+	_ MustangRepository = (*mustangService)(nil)
+	_ Backend           = (*Store)(nil)
+)
+
+// Mustangs returns the MySQL backed mustang repository
+func (s *Store) Mustangs() MustangRepository {
+	return s.Mustang
+}
@@ -0,0 +1,108 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// MySQL error numbers for lock contention, the transaction may succeed if run again
+const (
+	mysqlErrLockWaitTimeout = 1205
+	mysqlErrDeadlock        = 1213
+	mysqlErrLockNowait      = 3572
+)
+
+// retryPolicy controls how transactions that fail on lock contention are run again
+type retryPolicy struct {
+	maxRetries int
+	baseDelay  time.Duration
+	maxDelay   time.Duration
+	onRetry    func(attempt int, err error)
+}
+
+// defaultRetryPolicy retries a contended transaction up to 3 times over roughly 70ms
+var defaultRetryPolicy = retryPolicy{
+	maxRetries: 3,
+	baseDelay:  10 * time.Millisecond,
+	maxDelay:   time.Second,
+}
+
+// WithTxRetries sets how many times WithTx runs a transaction again after a deadlock or
+// lock wait timeout and the base delay the jittered exponential backoff starts from.
+// a maxRetries of 0 disables retries
+func WithTxRetries(maxRetries int, baseDelay time.Duration) Option {
+	return func(s *Store) {
+		s.retry.maxRetries = maxRetries
+		s.retry.baseDelay = baseDelay
+	}
+}
+
+// WithTxRetryHook sets a func called before each retry of a transaction, use it to
+// log or emit metrics on contention
+func WithTxRetryHook(fn func(attempt int, err error)) Option {
+	return func(s *Store) {
+		s.retry.onRetry = fn
+	}
+}
+
+// TxRetries is the number of times a transaction has been retried since the store was created
+func (s *Store) TxRetries() uint64 {
+	return atomic.LoadUint64(&s.txRetries)
+}
+
+// retryTx calls run until it succeeds, fails with an error that is not retryable, or runs
+// out of retries. the wait between attempts grows exponentially with jitter
+func (s *Store) retryTx(ctx context.Context, run func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := run()
+		if err == nil || !isRetryable(err) || attempt > s.retry.maxRetries {
+			return err
+		}
+
+		atomic.AddUint64(&s.txRetries, 1)
+		if s.retry.onRetry != nil {
+			s.retry.onRetry(attempt, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(s.retry.backoff(attempt)):
+		}
+	}
+}
+
+// backoff returns the wait before the given retry attempt, half of the exponential
+// delay is fixed and half is random so contending transactions spread out
+func (p retryPolicy) backoff(attempt int) time.Duration {
+	delay := p.baseDelay << uint(attempt-1)
+	if delay <= 0 || delay > p.maxDelay {
+		delay = p.maxDelay
+	}
+	half := int64(delay / 2)
+	if half <= 0 {
+		return delay
+	}
+	return time.Duration(half + rand.Int63n(half))
+}
+
+// isRetryable reports whether err is a deadlock or lock wait timeout reported by MySQL
+func isRetryable(err error) bool {
+	var mErr *mysql.MySQLError
+	if !errors.As(err, &mErr) {
+		return false
+	}
+	return mErr.Number == mysqlErrDeadlock || mErr.Number == mysqlErrLockWaitTimeout
+}
+
+// isLockNowait reports whether err is MySQL refusing to wait for a row another transaction
+// has locked, it is not retried as the caller asked not to wait
+func isLockNowait(err error) bool {
+	var mErr *mysql.MySQLError
+	return errors.As(err, &mErr) && mErr.Number == mysqlErrLockNowait
+}
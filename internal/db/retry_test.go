@@ -0,0 +1,89 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStore_WithTx_retry(t *testing.T) {
+	deadlock := &mysql.MySQLError{Number: mysqlErrDeadlock, Message: "Deadlock found when trying to get lock"}
+
+	// ensures a deadlocked transaction is run again and counted
+	t.Run("Retries a deadlock", func(t *testing.T) {
+		var hooked []int
+		store, mock, err := NewTestDB(map[string]string{},
+			WithTxRetries(3, time.Millisecond),
+			WithTxRetryHook(func(attempt int, err error) { hooked = append(hooked, attempt) }),
+		)
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "test setup failed")
+		}
+
+		mock.ExpectBegin()
+		mock.ExpectRollback()
+		mock.ExpectBegin()
+		mock.ExpectCommit()
+
+		calls := 0
+		err = store.WithTx(context.Background(), nil, func(ctx context.Context) error {
+			calls++
+			if calls == 1 {
+				return deadlock
+			}
+			return nil
+		})
+		assert.NoError(t, err, "Expecting the retry to succeed")
+		assert.Equal(t, 2, calls, "Expected the transaction to run twice")
+		assert.Equal(t, uint64(1), store.TxRetries(), "Expected the retry to be counted")
+		assert.Equal(t, []int{1}, hooked, "Expected the retry hook to be called")
+
+		err = mock.ExpectationsWereMet()
+		assert.NoError(t, err, "Expecting all mock conditions to be met")
+	})
+
+	// ensures retries stop at the configured limit
+	t.Run("Gives up after the limit", func(t *testing.T) {
+		store, mock, err := NewTestDB(map[string]string{}, WithTxRetries(1, time.Millisecond))
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "test setup failed")
+		}
+
+		mock.ExpectBegin()
+		mock.ExpectRollback()
+		mock.ExpectBegin()
+		mock.ExpectRollback()
+
+		err = store.WithTx(context.Background(), nil, func(ctx context.Context) error {
+			return deadlock
+		})
+		assert.ErrorIs(t, err, deadlock, "Expecting the deadlock to be returned")
+		assert.Equal(t, uint64(1), store.TxRetries(), "Expected one retry to be counted")
+
+		err = mock.ExpectationsWereMet()
+		assert.NoError(t, err, "Expecting all mock conditions to be met")
+	})
+
+	// ensures errors other than lock contention are not retried
+	t.Run("Does not retry other errors", func(t *testing.T) {
+		store, mock, err := NewTestDB(map[string]string{}, WithTxRetries(3, time.Millisecond))
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "test setup failed")
+		}
+
+		mock.ExpectBegin()
+		mock.ExpectRollback()
+
+		err = store.WithTx(context.Background(), nil, func(ctx context.Context) error {
+			return assert.AnError
+		})
+		assert.ErrorIs(t, err, assert.AnError, "Expecting the error to be returned")
+		assert.Equal(t, uint64(0), store.TxRetries(), "Expected no retries")
+
+		err = mock.ExpectationsWereMet()
+		assert.NoError(t, err, "Expecting all mock conditions to be met")
+	})
+}
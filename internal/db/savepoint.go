@@ -0,0 +1,55 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+
+	"github.com/caring/go-packages/pkg/errors"
+)
+
+type savepointCtxKey struct{}
+
+// savepointDepth is how many savepoints deep the tx in ctx is nested
+func savepointDepth(ctx context.Context) int {
+	depth, _ := ctx.Value(savepointCtxKey{}).(int)
+	return depth
+}
+
+// runSavepoint runs fn inside of a savepoint on tx. The savepoint is released when fn
+// returns nil and rolled back to when fn returns an error or panics, leaving the rest
+// of tx untouched either way. the changes fn queued for publishing are dropped with it
+func runSavepoint(ctx context.Context, tx *sql.Tx, fn func(ctx context.Context) error) (err error) {
+	depth := savepointDepth(ctx) + 1
+	name := "sp_" + strconv.Itoa(depth)
+
+	if _, err = tx.ExecContext(ctx, "SAVEPOINT "+name); err != nil {
+		return errors.Wrap(err, "Error creating savepoint - "+name)
+	}
+
+	mark := changesMark(ctx)
+
+	defer func() {
+		if p := recover(); p != nil {
+			truncateChanges(ctx, mark)
+			tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT "+name)
+			panic(p)
+		}
+	}()
+
+	if err = fn(context.WithValue(ctx, savepointCtxKey{}, depth)); err != nil {
+		truncateChanges(ctx, mark)
+		// a deadlock rolls back the whole tx and its savepoints, so a failed rollback here
+		// is expected then and the error from fn is the one worth returning
+		if _, rbErr := tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT "+name); rbErr != nil && !isRetryable(err) {
+			return errors.Wrap(err, "rollback to savepoint "+name+" failed: "+rbErr.Error())
+		}
+		return err
+	}
+
+	if _, err = tx.ExecContext(ctx, "RELEASE SAVEPOINT "+name); err != nil {
+		return errors.Wrap(err, "Error releasing savepoint - "+name)
+	}
+
+	return nil
+}
@@ -0,0 +1,78 @@
+package db
+
+import (
+	"context"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStore_WithTx_savepoint(t *testing.T) {
+	mustangID := uuid.MustParse("72bc87f3-4a9f-4d05-93fe-844d3cd94c65")
+	stmt := withOutbox(map[string]string{
+		"delete-mustang":      "UPDATE mustangs",
+		"get-mustang-version": "SELECT version",
+	})
+
+	// ensures a failed nested unit only rolls back to its savepoint and the outer tx commits
+	t.Run("Rolls back only the nested work", func(t *testing.T) {
+		store, mock, err := NewTestDB(stmt)
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "test setup failed")
+		}
+
+		mock.ExpectBegin()
+		mock.ExpectExec("SAVEPOINT sp_1").WillReturnResult(sqlmock.NewResult(0, 0))
+		expectMustangSnapshot(mock, Mustang{ID: mustangID, Name: "Foobar", Version: 1}, false)
+		mock.ExpectExec("UPDATE mustangs").WillReturnResult(sqlmock.NewResult(0, 1))
+		expectMustangEvent(mock, EventMustangDeleted, Mustang{ID: mustangID, Name: "Foobar", Version: 2}, true)
+		mock.ExpectExec("ROLLBACK TO SAVEPOINT sp_1").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectCommit()
+
+		var nestedErr error
+		err = store.WithTx(context.Background(), nil, func(ctx context.Context) error {
+			nestedErr = store.WithTx(ctx, nil, func(ctx context.Context) error {
+				if _, err := store.Mustang.Delete(ctx, mustangID, 0); err != nil {
+					return err
+				}
+				return assert.AnError
+			})
+			// skip the bad row and carry on with the outer transaction
+			return nil
+		})
+		assert.NoError(t, err, "Expecting the outer tx to commit")
+		assert.ErrorIs(t, nestedErr, assert.AnError, "Expecting the nested error to be returned")
+
+		err = mock.ExpectationsWereMet()
+		assert.NoError(t, err, "Expecting all mock conditions to be met")
+	})
+
+	// ensures deeper nesting uses a distinct savepoint per level
+	t.Run("Names savepoints by depth", func(t *testing.T) {
+		store, mock, err := NewTestDB(stmt)
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "test setup failed")
+		}
+
+		mock.ExpectBegin()
+		mock.ExpectExec("SAVEPOINT sp_1").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("SAVEPOINT sp_2").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("RELEASE SAVEPOINT sp_2").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("RELEASE SAVEPOINT sp_1").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectCommit()
+
+		err = store.WithTx(context.Background(), nil, func(ctx context.Context) error {
+			return store.WithTx(ctx, nil, func(ctx context.Context) error {
+				return store.WithTx(ctx, nil, func(ctx context.Context) error {
+					return nil
+				})
+			})
+		})
+		assert.NoError(t, err, "Expecting no error")
+
+		err = mock.ExpectationsWereMet()
+		assert.NoError(t, err, "Expecting all mock conditions to be met")
+	})
+}
@@ -0,0 +1,284 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+// the tests in this file run the SQLite statements against a real database
+
+func TestSQLite_mustangLifecycle(t *testing.T) {
+	ctx := context.Background()
+	mustangID := uuid.MustParse("72bc87f3-4a9f-4d05-93fe-844d3cd94c65")
+
+	store, err := NewSQLiteTestDB(t)
+	if ok := assert.NoError(t, err, "Expected no error"); !ok {
+		assert.FailNow(t, "test setup failed")
+	}
+
+	m := &Mustang{ID: mustangID, Name: "Foobar"}
+	err = store.Mustang.Create(ctx, m)
+	assert.NoError(t, err, "Expecting no create error")
+
+	r, err := store.Mustang.Get(ctx, mustangID)
+	assert.NoError(t, err, "Expecting no get error")
+	assert.Equal(t, mustangID, r.ID, "Expected the UUID to round trip through TEXT")
+	assert.Equal(t, int64(1), r.Version, "Expected new mustangs to start at version 1")
+
+	err = store.Mustang.Update(ctx, &Mustang{ID: mustangID, Name: "Bazqux", Version: 3})
+	assert.ErrorIs(t, err, ErrVersionMismatch, "Expected a stale version to be rejected")
+
+	err = store.Mustang.Patch(ctx, &Mustang{ID: mustangID, Name: "Bazqux", Version: 1}, []string{"name"})
+	assert.NoError(t, err, "Expecting no patch error")
+
+	deleted, err := store.Mustang.Delete(ctx, mustangID, 2)
+	assert.NoError(t, err, "Expecting no delete error")
+	assert.Equal(t, int64(3), deleted.Version, "Expected the deleted mustang with its new version")
+
+	_, err = store.Mustang.Get(ctx, mustangID)
+	assert.ErrorIs(t, err, ErrNotFound, "Expected deleted mustangs to be hidden")
+
+	err = store.Mustang.Restore(ctx, mustangID)
+	assert.NoError(t, err, "Expecting no restore error")
+
+	err = store.Mustang.Restore(ctx, mustangID)
+	assert.ErrorIs(t, err, ErrNotDeleted, "Expected restoring a live mustang to fail")
+
+	r, err = store.Mustang.Get(ctx, mustangID)
+	assert.NoError(t, err, "Expecting no get error")
+	assert.Equal(t, "Bazqux", r.Name, "Expected the patched name")
+	assert.Equal(t, int64(4), r.Version, "Expected every write to bump the version")
+}
+
+func TestSQLite_mustangPages(t *testing.T) {
+	ctx := context.Background()
+	IDs := []uuid.UUID{
+		uuid.MustParse("10000000-0000-0000-0000-000000000000"),
+		uuid.MustParse("20000000-0000-0000-0000-000000000000"),
+		uuid.MustParse("30000000-0000-0000-0000-000000000000"),
+	}
+
+	store, err := NewSQLiteTestDB(t)
+	if ok := assert.NoError(t, err, "Expected no error"); !ok {
+		assert.FailNow(t, "test setup failed")
+	}
+
+	for _, ID := range IDs {
+		assert.NoError(t, store.Mustang.Create(ctx, &Mustang{ID: ID, Name: "Foobar"}), "Expecting no create error")
+	}
+
+	var listed []uuid.UUID
+	var after *Cursor
+	for {
+		page, next, err := store.Mustang.List(ctx, 2, after)
+		if ok := assert.NoError(t, err, "Expecting no list error"); !ok {
+			break
+		}
+		for _, m := range page {
+			listed = append(listed, m.ID)
+		}
+		if next == nil {
+			break
+		}
+		after = next
+	}
+	assert.Equal(t, IDs, listed, "Expected every mustang once in (created_at, mustang_id) order")
+
+	r, err := store.Mustang.BatchGet(ctx, []uuid.UUID{IDs[2], uuid.New(), IDs[0]})
+	assert.NoError(t, err, "Expecting no batch get error")
+	assert.Len(t, r, 2, "Expected only the stored mustangs")
+}
+
+func TestSQLite_constraints(t *testing.T) {
+	ctx := context.Background()
+	categoryID := uuid.MustParse("94cc5321-ec44-464f-9008-3d81f5e2c18f")
+
+	store, err := NewSQLiteTestDB(t)
+	if ok := assert.NoError(t, err, "Expected no error"); !ok {
+		assert.FailNow(t, "test setup failed")
+	}
+
+	err = store.Category.Create(ctx, &Category{ID: categoryID, Name: "Foobar"})
+	assert.NoError(t, err, "Expecting no create error")
+
+	err = store.Category.Create(ctx, &Category{ID: uuid.New(), Name: "Foobar"})
+	assert.ErrorIs(t, err, ErrDuplicate, "Expected a duplicate name to be rejected")
+
+	err = store.Product.Create(ctx, &Product{ID: uuid.New(), CategoryID: uuid.New(), Name: "Foobar"})
+	assert.ErrorIs(t, err, ErrInvalidReference, "Expected a missing category to be rejected")
+
+	productID := uuid.New()
+	err = store.Product.Create(ctx, &Product{ID: productID, CategoryID: categoryID, Name: "Foobar"})
+	assert.NoError(t, err, "Expecting no create error")
+
+	products, _, err := store.Product.ListByCategory(ctx, categoryID, 10, nil)
+	assert.NoError(t, err, "Expecting no list error")
+	if assert.Len(t, products, 1, "Expected the category's product") {
+		assert.Equal(t, productID, products[0].ID, "Expected the created product")
+	}
+
+	deleted, err := store.Product.Delete(ctx, productID)
+	assert.NoError(t, err, "Expecting no delete error")
+	assert.Equal(t, &Product{ID: productID, CategoryID: categoryID, Name: "Foobar"}, deleted, "Expected the deleted product to be returned")
+}
+
+func TestSQLite_transactions(t *testing.T) {
+	ctx := context.Background()
+	outerID := uuid.MustParse("72bc87f3-4a9f-4d05-93fe-844d3cd94c65")
+	nestedID := uuid.MustParse("94cc5321-ec44-464f-9008-3d81f5e2c18f")
+	errFailed := errors.New("failed")
+
+	store, err := NewSQLiteTestDB(t)
+	if ok := assert.NoError(t, err, "Expected no error"); !ok {
+		assert.FailNow(t, "test setup failed")
+	}
+
+	err = store.WithTx(ctx, nil, func(ctx context.Context) error {
+		if err := store.Mustang.Create(ctx, &Mustang{ID: outerID, Name: "Foobar"}); err != nil {
+			return err
+		}
+
+		nestedErr := store.WithTx(ctx, nil, func(ctx context.Context) error {
+			if err := store.Mustang.Create(ctx, &Mustang{ID: nestedID, Name: "Bazqux"}); err != nil {
+				return err
+			}
+			return errFailed
+		})
+		assert.ErrorIs(t, nestedErr, errFailed, "Expecting the error from the nested fn")
+
+		return nil
+	})
+	assert.NoError(t, err, "Expecting no error")
+
+	_, err = store.Mustang.Get(ctx, outerID)
+	assert.NoError(t, err, "Expected the outer work to be committed")
+	_, err = store.Mustang.Get(ctx, nestedID)
+	assert.ErrorIs(t, err, ErrNotFound, "Expected the savepoint to be rolled back")
+}
+
+func TestSQLite_purge(t *testing.T) {
+	ctx := context.Background()
+	deletedID := uuid.MustParse("72bc87f3-4a9f-4d05-93fe-844d3cd94c65")
+	liveID := uuid.MustParse("94cc5321-ec44-464f-9008-3d81f5e2c18f")
+
+	store, err := NewSQLiteTestDB(t)
+	if ok := assert.NoError(t, err, "Expected no error"); !ok {
+		assert.FailNow(t, "test setup failed")
+	}
+
+	assert.NoError(t, store.Mustang.Create(ctx, &Mustang{ID: deletedID, Name: "Foobar"}), "Expecting no create error")
+	assert.NoError(t, store.Mustang.Create(ctx, &Mustang{ID: liveID, Name: "Bazqux"}), "Expecting no create error")
+	_, err = store.Mustang.Delete(ctx, deletedID, 0)
+	assert.NoError(t, err, "Expecting no delete error")
+
+	counts, err := store.Purge(ctx, time.Now().Add(time.Hour), 1)
+	assert.NoError(t, err, "Expecting no purge error")
+	assert.Contains(t, counts, PurgeCount{Table: "mustangs", Count: 1}, "Expected only the deleted mustang to be purged")
+	assert.Contains(t, counts, PurgeCount{Table: "mustang_history", Count: 2}, "Expected the purged mustang's history to go with it")
+
+	history, _, err := store.Mustang.History(ctx, deletedID, 10, 0)
+	assert.NoError(t, err, "Expecting no history error")
+	assert.Empty(t, history, "Expected the purged mustang's history to be gone")
+	history, _, err = store.Mustang.History(ctx, liveID, 10, 0)
+	assert.NoError(t, err, "Expecting no history error")
+	assert.Len(t, history, 1, "Expected the live mustang's history to be kept")
+
+	err = store.Mustang.Restore(ctx, deletedID)
+	assert.ErrorIs(t, err, ErrNotFound, "Expected the purged mustang to be gone")
+	_, err = store.Mustang.Get(ctx, liveID)
+	assert.NoError(t, err, "Expected the live mustang to be kept")
+}
+
+func TestSQLite_outbox(t *testing.T) {
+	ctx := context.Background()
+	mustangID := uuid.MustParse("72bc87f3-4a9f-4d05-93fe-844d3cd94c65")
+
+	store, err := NewSQLiteTestDB(t)
+	if ok := assert.NoError(t, err, "Expected no error"); !ok {
+		assert.FailNow(t, "test setup failed")
+	}
+
+	assert.NoError(t, store.Mustang.Create(ctx, &Mustang{ID: mustangID, Name: "Foobar"}), "Expecting no create error")
+	_, err = store.Mustang.Delete(ctx, mustangID, 0)
+	assert.NoError(t, err, "Expecting no delete error")
+
+	// a mutation that rolls back leaves no event behind
+	err = store.WithTx(ctx, nil, func(ctx context.Context) error {
+		if err := store.Mustang.Restore(ctx, mustangID); err != nil {
+			return err
+		}
+		return errors.New("failed")
+	})
+	assert.Error(t, err, "Expecting the error from fn")
+
+	publisher := &testPublisher{}
+	sent, err := store.RelayOutbox(ctx, publisher, 10, time.Second)
+	assert.NoError(t, err, "Expecting no relay error")
+	assert.Equal(t, 2, sent, "Expected the committed events only")
+
+	sent, err = store.RelayOutbox(ctx, publisher, 10, time.Second)
+	assert.NoError(t, err, "Expecting no relay error")
+	assert.Equal(t, 0, sent, "Expected sent events not to be published again")
+}
+
+func TestSQLite_history(t *testing.T) {
+	ctx := WithActor(context.Background(), "support@caring.com")
+	mustangID := uuid.MustParse("72bc87f3-4a9f-4d05-93fe-844d3cd94c65")
+
+	store, err := NewSQLiteTestDB(t)
+	if ok := assert.NoError(t, err, "Expected no error"); !ok {
+		assert.FailNow(t, "test setup failed")
+	}
+
+	assert.NoError(t, store.Mustang.Create(ctx, &Mustang{ID: mustangID, Name: "Foobar"}), "Expecting no create error")
+	assert.NoError(t, store.Mustang.Update(ctx, &Mustang{ID: mustangID, Name: "Bazqux"}), "Expecting no update error")
+	_, err = store.Mustang.Delete(context.Background(), mustangID, 0)
+	assert.NoError(t, err, "Expecting no delete error")
+
+	entries, next, err := store.Mustang.History(ctx, mustangID, 2, 0)
+	assert.NoError(t, err, "Expecting no history error")
+	if assert.Len(t, entries, 2, "Expected a full page") {
+		assert.Equal(t, OperationUpdate, entries[1].Operation, "Expected entries oldest first")
+		assert.Equal(t, "support@caring.com", entries[1].Actor, "Expected the actor from ctx")
+		assert.Equal(t, "Foobar", entries[1].Before.Name, "Expected the name before the update")
+		assert.Equal(t, "Bazqux", entries[1].After.Name, "Expected the name after the update")
+	}
+
+	entries, next, err = store.Mustang.History(ctx, mustangID, 2, next)
+	assert.NoError(t, err, "Expecting no history error")
+	if assert.Len(t, entries, 1, "Expected the rest of the history") {
+		assert.Equal(t, UnknownActor, entries[0].Actor, "Expected the unknown actor")
+		assert.True(t, entries[0].After.Deleted, "Expected the delete")
+	}
+	assert.Equal(t, int64(0), next, "Expected no more pages")
+}
+
+func TestSQLite_idempotency(t *testing.T) {
+	ctx := context.Background()
+
+	store, err := NewSQLiteTestDB(t)
+	if ok := assert.NoError(t, err, "Expected no error"); !ok {
+		assert.FailNow(t, "test setup failed")
+	}
+
+	first, err := store.Mustang.CreateIdempotent(ctx, "retry-1", &Mustang{ID: uuid.New(), Name: "Foobar"})
+	assert.NoError(t, err, "Expecting no create error")
+
+	replayed, err := store.Mustang.CreateIdempotent(ctx, "retry-1", &Mustang{ID: uuid.New(), Name: "Foobar"})
+	assert.NoError(t, err, "Expecting no replay error")
+	assert.Equal(t, first, replayed, "Expected the replay to return the first mustang")
+
+	// the key is scoped to the actor, another actor sending it creates their own mustang
+	other, err := store.Mustang.CreateIdempotent(WithActor(ctx, "support@caring.com"), "retry-1", &Mustang{ID: uuid.New(), Name: "Foobar"})
+	assert.NoError(t, err, "Expecting no create error")
+	assert.NotEqual(t, first.ID, other.ID, "Expected another actor to create a new mustang")
+
+	mustangs, _, err := store.Mustang.List(ctx, 10, nil)
+	assert.NoError(t, err, "Expecting no list error")
+	assert.Len(t, mustangs, 2, "Expected a mustang to be created per actor")
+}
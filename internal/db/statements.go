@@ -1,39 +1,402 @@
 package db
 
 var statements = map[string]string{
-  // inserts a new row into the mustangs table
-  "create-mustang": `
+	// inserts a new row into the mustangs table
+	"create-mustang": `
   INSERT INTO mustangs (mustang_id, name)
     values(UUID_TO_BIN(?), ?)
   `,
-  // soft deletes a mustang by id
-  "delete-mustang": `
+	// soft deletes a mustang by id, a version of 0 skips the version check
+	"delete-mustang": `
   UPDATE
     mustangs
   SET
-    deleted_at = NOW()
+    deleted_at = NOW(),
+    version = version + 1
   WHERE
     mustang_id = UUID_TO_BIN(?)
     AND deleted_at IS NULL
+    AND (? = 0 OR version = ?)
   `,
-  // gets a single mustang row by id
-  "get-mustang": `
+	// gets a single mustang row by id
+	"get-mustang": `
   SELECT
-    mustang_id, name
+    mustang_id, name, version
   FROM
     mustangs
   WHERE
     mustang_id = UUID_TO_BIN(?)
     AND deleted_at IS NULL
   `,
-  // update a single mustang row by ID
-  "update-mustang": `
+	// gets the current version of a single mustang row by id
+	"get-mustang-version": `
+  SELECT
+    version
+  FROM
+    mustangs
+  WHERE
+    mustang_id = UUID_TO_BIN(?)
+    AND deleted_at IS NULL
+  `,
+	// update a single mustang row by ID, a version of 0 skips the version check
+	"update-mustang": `
   UPDATE
     mustangs
   SET
-    name = ?
+    name = ?,
+    version = version + 1
   WHERE
     mustang_id = UUID_TO_BIN(?)
     AND deleted_at IS NULL
+    AND (? = 0 OR version = ?)
+  `,
+	// restores a soft deleted mustang by id
+	"restore-mustang": `
+  UPDATE
+    mustangs
+  SET
+    deleted_at = NULL,
+    version = version + 1
+  WHERE
+    mustang_id = UUID_TO_BIN(?)
+    AND deleted_at IS NOT NULL
+  `,
+	// reports whether a mustang row exists and is soft deleted, regardless of deleted_at
+	"get-mustang-deleted": `
+  SELECT
+    deleted_at IS NOT NULL
+  FROM
+    mustangs
+  WHERE
+    mustang_id = UUID_TO_BIN(?)
+  `,
+	// lists the first page of mustangs ordered by creation
+	"list-mustangs": `
+  SELECT
+    mustang_id, name, version, created_at
+  FROM
+    mustangs
+  WHERE
+    deleted_at IS NULL
+  ORDER BY
+    created_at, mustang_id
+  LIMIT ?
+  `,
+	// lists the page of mustangs following a (created_at, mustang_id) cursor
+	"list-mustangs-after": `
+  SELECT
+    mustang_id, name, version, created_at
+  FROM
+    mustangs
+  WHERE
+    deleted_at IS NULL
+    AND (
+      created_at > ?
+      OR (created_at = ? AND mustang_id > UUID_TO_BIN(?))
+    )
+  ORDER BY
+    created_at, mustang_id
+  LIMIT ?
+  `,
+	// gets and locks a single mustang row by id for its change event and audit history, including
+	// soft deleted rows. the lock keeps the state read ahead of a write from changing before it
+	"get-mustang-event": `
+  SELECT
+    mustang_id, name, version, deleted_at IS NOT NULL
+  FROM
+    mustangs
+  WHERE
+    mustang_id = UUID_TO_BIN(?)
+  FOR UPDATE
+  `,
+	// appends a row to the audit history of a mustang
+	"create-mustang-history": `
+  INSERT INTO mustang_history (mustang_id, operation, actor, before_state, after_state)
+    values(UUID_TO_BIN(?), ?, ?, ?, ?)
+  `,
+	// lists the page of a mustang's audit history following a history_id, oldest first
+	"list-mustang-history": `
+  SELECT
+    history_id, operation, actor, before_state, after_state, created_at
+  FROM
+    mustang_history
+  WHERE
+    mustang_id = UUID_TO_BIN(?)
+    AND history_id > ?
+  ORDER BY
+    history_id
+  LIMIT ?
+  `,
+	// gets and locks the result stored with an actor's idempotency key
+	"get-idempotency-key": `
+  SELECT
+    request_hash, response, expires_at
+  FROM
+    idempotency_keys
+  WHERE
+    actor = ?
+    AND idempotency_key = ?
+  FOR UPDATE
+  `,
+	// stores the result of the request that first used an idempotency key
+	"create-idempotency-key": `
+  INSERT INTO idempotency_keys (actor, idempotency_key, request_hash, response, expires_at)
+    values(?, ?, ?, ?, ?)
+  `,
+	// deletes an actor's expired idempotency key so it can be used again
+	"delete-idempotency-key": `
+  DELETE FROM
+    idempotency_keys
+  WHERE
+    actor = ?
+    AND idempotency_key = ?
+  `,
+	// inserts a new row into the categories table
+	"create-category": `
+  INSERT INTO categories (category_id, name)
+    values(UUID_TO_BIN(?), ?)
+  `,
+	// soft deletes a category by id
+	"delete-category": `
+  UPDATE
+    categories
+  SET
+    deleted_at = NOW()
+  WHERE
+    category_id = UUID_TO_BIN(?)
+    AND deleted_at IS NULL
+  `,
+	// gets a single category row by id
+	"get-category": `
+  SELECT
+    category_id, name
+  FROM
+    categories
+  WHERE
+    category_id = UUID_TO_BIN(?)
+    AND deleted_at IS NULL
+  `,
+	// gets a single soft deleted category row by id
+	"get-deleted-category": `
+  SELECT
+    category_id, name
+  FROM
+    categories
+  WHERE
+    category_id = UUID_TO_BIN(?)
+    AND deleted_at IS NOT NULL
+  `,
+	// update a single category row by ID
+	"update-category": `
+  UPDATE
+    categories
+  SET
+    name = ?,
+    updated_at = NOW()
+  WHERE
+    category_id = UUID_TO_BIN(?)
+    AND deleted_at IS NULL
+  `,
+	// inserts a new row into the products table
+	"create-product": `
+  INSERT INTO products (product_id, category_id, name)
+    values(UUID_TO_BIN(?), UUID_TO_BIN(?), ?)
+  `,
+	// soft deletes a product by id
+	"delete-product": `
+  UPDATE
+    products
+  SET
+    deleted_at = NOW()
+  WHERE
+    product_id = UUID_TO_BIN(?)
+    AND deleted_at IS NULL
+  `,
+	// gets a single product row by id
+	"get-product": `
+  SELECT
+    product_id, category_id, name
+  FROM
+    products
+  WHERE
+    product_id = UUID_TO_BIN(?)
+    AND deleted_at IS NULL
+  `,
+	// gets a single soft deleted product row by id
+	"get-deleted-product": `
+  SELECT
+    product_id, category_id, name
+  FROM
+    products
+  WHERE
+    product_id = UUID_TO_BIN(?)
+    AND deleted_at IS NOT NULL
+  `,
+	// update a single product row by ID
+	"update-product": `
+  UPDATE
+    products
+  SET
+    category_id = UUID_TO_BIN(?),
+    name = ?,
+    updated_at = NOW()
+  WHERE
+    product_id = UUID_TO_BIN(?)
+    AND deleted_at IS NULL
+  `,
+	// lists the first page of products in a category ordered by creation
+	"list-products-by-category": `
+  SELECT
+    product_id, category_id, name, created_at
+  FROM
+    products
+  WHERE
+    category_id = UUID_TO_BIN(?)
+    AND deleted_at IS NULL
+  ORDER BY
+    created_at, product_id
+  LIMIT ?
+  `,
+	// lists the page of products in a category following a (created_at, product_id) cursor
+	"list-products-by-category-after": `
+  SELECT
+    product_id, category_id, name, created_at
+  FROM
+    products
+  WHERE
+    category_id = UUID_TO_BIN(?)
+    AND deleted_at IS NULL
+    AND (
+      created_at > ?
+      OR (created_at = ? AND product_id > UUID_TO_BIN(?))
+    )
+  ORDER BY
+    created_at, product_id
+  LIMIT ?
+  `,
+	// inserts a change event into the outbox
+	"create-outbox-event": `
+  INSERT INTO outbox (event_type, aggregate_id, payload)
+    values(?, UUID_TO_BIN(?), ?)
+  `,
+	// locks a batch of unsent outbox events in the order they were written, failing at once
+	// rather than skipping or waiting on rows another relay or writer holds
+	"list-outbox-pending": `
+  SELECT
+    outbox_id, event_type, aggregate_id, payload, created_at
+  FROM
+    outbox
+  WHERE
+    sent_at IS NULL
+  ORDER BY
+    outbox_id
+  LIMIT ?
+  FOR UPDATE NOWAIT
+  `,
+	// marks a single outbox event sent
+	"mark-outbox-sent": `
+  UPDATE
+    outbox
+  SET
+    sent_at = NOW()
+  WHERE
+    outbox_id = ?
+  `,
+	// permanently deletes a batch of products soft deleted before a cutoff
+	"purge-products": `
+  DELETE FROM
+    products
+  WHERE
+    deleted_at < ?
+  ORDER BY
+    deleted_at
+  LIMIT ?
+  `,
+	// permanently deletes a batch of categories soft deleted before a cutoff. categories that
+	// still have products are kept so the foreign key cascade never removes retained products
+	"purge-categories": `
+  DELETE FROM
+    categories
+  WHERE
+    deleted_at < ?
+    AND NOT EXISTS (
+      SELECT 1 FROM products WHERE products.category_id = categories.category_id
+    )
+  ORDER BY
+    deleted_at
+  LIMIT ?
+  `,
+	// permanently deletes the history of the batch of mustangs purge-mustangs deletes next, the
+	// history holds copies of the mustang so it goes with it. both order by the primary key too
+	// so they agree on the batch when deleted_at ties
+	"purge-mustang-history": `
+  DELETE
+    mustang_history
+  FROM
+    mustang_history
+    JOIN (
+      SELECT mustang_id FROM mustangs
+      WHERE
+        deleted_at < ?
+      ORDER BY
+        deleted_at, mustang_id
+      LIMIT ?
+    ) AS purged USING (mustang_id)
+  `,
+	// permanently deletes a batch of mustangs soft deleted before a cutoff
+	"purge-mustangs": `
+  DELETE FROM
+    mustangs
+  WHERE
+    deleted_at < ?
+  ORDER BY
+    deleted_at, mustang_id
+  LIMIT ?
+  `,
+	// permanently deletes a batch of outbox events sent before a cutoff
+	"purge-outbox": `
+  DELETE FROM
+    outbox
+  WHERE
+    sent_at < ?
+  ORDER BY
+    sent_at
+  LIMIT ?
+  `,
+	// permanently deletes a batch of idempotency keys that expired before a cutoff
+	"purge-idempotency-keys": `
+  DELETE FROM
+    idempotency_keys
+  WHERE
+    expires_at < ?
+  ORDER BY
+    expires_at
+  LIMIT ?
   `,
 }
+
+// batchGetMustangsQuery gets all mustang rows matching a set of ids, the IN list
+// varies in length so the placeholders are expanded when the query is run
+const batchGetMustangsQuery = `
+  SELECT
+    mustang_id, name, version
+  FROM
+    mustangs
+  WHERE
+    mustang_id IN (%s)
+    AND deleted_at IS NULL
+  `
+
+// patchMustangQuery updates only the columns named in a field mask on a single mustang row by ID,
+// the SET list is built from the mask when the query is run and a version of 0 skips the version check
+const patchMustangQuery = `
+  UPDATE
+    mustangs
+  SET
+    %s,
+    version = version + 1
+  WHERE
+    mustang_id = UUID_TO_BIN(?)
+    AND deleted_at IS NULL
+    AND (? = 0 OR version = ?)
+  `
@@ -0,0 +1,424 @@
+package db
+
+// sqliteStatements are the SQLite variants of statements. UUIDs are stored as TEXT, timestamps
+// come from CURRENT_TIMESTAMP and time arguments are normalised with datetime() to match them
+var sqliteStatements = map[string]string{
+	// inserts a new row into the mustangs table
+	"create-mustang": `
+  INSERT INTO mustangs (mustang_id, name)
+    values(?, ?)
+  `,
+	// soft deletes a mustang by id, a version of 0 skips the version check
+	"delete-mustang": `
+  UPDATE
+    mustangs
+  SET
+    deleted_at = CURRENT_TIMESTAMP,
+    version = version + 1,
+    updated_at = CURRENT_TIMESTAMP
+  WHERE
+    mustang_id = ?
+    AND deleted_at IS NULL
+    AND (? = 0 OR version = ?)
+  `,
+	// gets a single mustang row by id
+	"get-mustang": `
+  SELECT
+    mustang_id, name, version
+  FROM
+    mustangs
+  WHERE
+    mustang_id = ?
+    AND deleted_at IS NULL
+  `,
+	// gets the current version of a single mustang row by id
+	"get-mustang-version": `
+  SELECT
+    version
+  FROM
+    mustangs
+  WHERE
+    mustang_id = ?
+    AND deleted_at IS NULL
+  `,
+	// update a single mustang row by ID, a version of 0 skips the version check
+	"update-mustang": `
+  UPDATE
+    mustangs
+  SET
+    name = ?,
+    version = version + 1,
+    updated_at = CURRENT_TIMESTAMP
+  WHERE
+    mustang_id = ?
+    AND deleted_at IS NULL
+    AND (? = 0 OR version = ?)
+  `,
+	// restores a soft deleted mustang by id
+	"restore-mustang": `
+  UPDATE
+    mustangs
+  SET
+    deleted_at = NULL,
+    version = version + 1,
+    updated_at = CURRENT_TIMESTAMP
+  WHERE
+    mustang_id = ?
+    AND deleted_at IS NOT NULL
+  `,
+	// reports whether a mustang row exists and is soft deleted, regardless of deleted_at
+	"get-mustang-deleted": `
+  SELECT
+    deleted_at IS NOT NULL
+  FROM
+    mustangs
+  WHERE
+    mustang_id = ?
+  `,
+	// lists the first page of mustangs ordered by creation
+	"list-mustangs": `
+  SELECT
+    mustang_id, name, version, created_at
+  FROM
+    mustangs
+  WHERE
+    deleted_at IS NULL
+  ORDER BY
+    created_at, mustang_id
+  LIMIT ?
+  `,
+	// lists the page of mustangs following a (created_at, mustang_id) cursor
+	"list-mustangs-after": `
+  SELECT
+    mustang_id, name, version, created_at
+  FROM
+    mustangs
+  WHERE
+    deleted_at IS NULL
+    AND (
+      created_at > datetime(?)
+      OR (created_at = datetime(?) AND mustang_id > ?)
+    )
+  ORDER BY
+    created_at, mustang_id
+  LIMIT ?
+  `,
+	// gets a single mustang row by id for its change event and audit history, including soft
+	// deleted rows. SQLite's single writer keeps the row from changing ahead of the write that follows
+	"get-mustang-event": `
+  SELECT
+    mustang_id, name, version, deleted_at IS NOT NULL
+  FROM
+    mustangs
+  WHERE
+    mustang_id = ?
+  `,
+	// appends a row to the audit history of a mustang
+	"create-mustang-history": `
+  INSERT INTO mustang_history (mustang_id, operation, actor, before_state, after_state)
+    values(?, ?, ?, ?, ?)
+  `,
+	// lists the page of a mustang's audit history following a history_id, oldest first
+	"list-mustang-history": `
+  SELECT
+    history_id, operation, actor, before_state, after_state, created_at
+  FROM
+    mustang_history
+  WHERE
+    mustang_id = ?
+    AND history_id > ?
+  ORDER BY
+    history_id
+  LIMIT ?
+  `,
+	// gets the result stored with an actor's idempotency key
+	"get-idempotency-key": `
+  SELECT
+    request_hash, response, expires_at
+  FROM
+    idempotency_keys
+  WHERE
+    actor = ?
+    AND idempotency_key = ?
+  `,
+	// stores the result of the request that first used an idempotency key
+	"create-idempotency-key": `
+  INSERT INTO idempotency_keys (actor, idempotency_key, request_hash, response, expires_at)
+    values(?, ?, ?, ?, datetime(?))
+  `,
+	// deletes an actor's expired idempotency key so it can be used again
+	"delete-idempotency-key": `
+  DELETE FROM
+    idempotency_keys
+  WHERE
+    actor = ?
+    AND idempotency_key = ?
+  `,
+	// inserts a new row into the categories table
+	"create-category": `
+  INSERT INTO categories (category_id, name)
+    values(?, ?)
+  `,
+	// soft deletes a category by id
+	"delete-category": `
+  UPDATE
+    categories
+  SET
+    deleted_at = CURRENT_TIMESTAMP
+  WHERE
+    category_id = ?
+    AND deleted_at IS NULL
+  `,
+	// gets a single category row by id
+	"get-category": `
+  SELECT
+    category_id, name
+  FROM
+    categories
+  WHERE
+    category_id = ?
+    AND deleted_at IS NULL
+  `,
+	// gets a single soft deleted category row by id
+	"get-deleted-category": `
+  SELECT
+    category_id, name
+  FROM
+    categories
+  WHERE
+    category_id = ?
+    AND deleted_at IS NOT NULL
+  `,
+	// update a single category row by ID
+	"update-category": `
+  UPDATE
+    categories
+  SET
+    name = ?,
+    updated_at = CURRENT_TIMESTAMP
+  WHERE
+    category_id = ?
+    AND deleted_at IS NULL
+  `,
+	// inserts a new row into the products table
+	"create-product": `
+  INSERT INTO products (product_id, category_id, name)
+    values(?, ?, ?)
+  `,
+	// soft deletes a product by id
+	"delete-product": `
+  UPDATE
+    products
+  SET
+    deleted_at = CURRENT_TIMESTAMP
+  WHERE
+    product_id = ?
+    AND deleted_at IS NULL
+  `,
+	// gets a single product row by id
+	"get-product": `
+  SELECT
+    product_id, category_id, name
+  FROM
+    products
+  WHERE
+    product_id = ?
+    AND deleted_at IS NULL
+  `,
+	// gets a single soft deleted product row by id
+	"get-deleted-product": `
+  SELECT
+    product_id, category_id, name
+  FROM
+    products
+  WHERE
+    product_id = ?
+    AND deleted_at IS NOT NULL
+  `,
+	// update a single product row by ID
+	"update-product": `
+  UPDATE
+    products
+  SET
+    category_id = ?,
+    name = ?,
+    updated_at = CURRENT_TIMESTAMP
+  WHERE
+    product_id = ?
+    AND deleted_at IS NULL
+  `,
+	// lists the first page of products in a category ordered by creation
+	"list-products-by-category": `
+  SELECT
+    product_id, category_id, name, created_at
+  FROM
+    products
+  WHERE
+    category_id = ?
+    AND deleted_at IS NULL
+  ORDER BY
+    created_at, product_id
+  LIMIT ?
+  `,
+	// lists the page of products in a category following a (created_at, product_id) cursor
+	"list-products-by-category-after": `
+  SELECT
+    product_id, category_id, name, created_at
+  FROM
+    products
+  WHERE
+    category_id = ?
+    AND deleted_at IS NULL
+    AND (
+      created_at > datetime(?)
+      OR (created_at = datetime(?) AND product_id > ?)
+    )
+  ORDER BY
+    created_at, product_id
+  LIMIT ?
+  `,
+	// inserts a change event into the outbox
+	"create-outbox-event": `
+  INSERT INTO outbox (event_type, aggregate_id, payload)
+    values(?, ?, ?)
+  `,
+	// reads a batch of unsent outbox events in the order they were written, SQLite has no
+	// row locks and its single writer keeps relays from overlapping
+	"list-outbox-pending": `
+  SELECT
+    outbox_id, event_type, aggregate_id, payload, created_at
+  FROM
+    outbox
+  WHERE
+    sent_at IS NULL
+  ORDER BY
+    outbox_id
+  LIMIT ?
+  `,
+	// marks a single outbox event sent
+	"mark-outbox-sent": `
+  UPDATE
+    outbox
+  SET
+    sent_at = CURRENT_TIMESTAMP
+  WHERE
+    outbox_id = ?
+  `,
+	// permanently deletes a batch of products soft deleted before a cutoff, SQLite has no
+	// DELETE ... LIMIT so the batch is picked by a subquery
+	"purge-products": `
+  DELETE FROM
+    products
+  WHERE
+    product_id IN (
+      SELECT product_id FROM products
+      WHERE
+        deleted_at < datetime(?)
+      ORDER BY
+        deleted_at
+      LIMIT ?
+    )
+  `,
+	// permanently deletes a batch of categories soft deleted before a cutoff. categories that
+	// still have products are kept so the foreign key cascade never removes retained products
+	"purge-categories": `
+  DELETE FROM
+    categories
+  WHERE
+    category_id IN (
+      SELECT category_id FROM categories
+      WHERE
+        deleted_at < datetime(?)
+        AND NOT EXISTS (
+          SELECT 1 FROM products WHERE products.category_id = categories.category_id
+        )
+      ORDER BY
+        deleted_at
+      LIMIT ?
+    )
+  `,
+	// permanently deletes the history of the batch of mustangs purge-mustangs deletes next, the
+	// history holds copies of the mustang so it goes with it
+	"purge-mustang-history": `
+  DELETE FROM
+    mustang_history
+  WHERE
+    mustang_id IN (
+      SELECT mustang_id FROM mustangs
+      WHERE
+        deleted_at < datetime(?)
+      ORDER BY
+        deleted_at, mustang_id
+      LIMIT ?
+    )
+  `,
+	// permanently deletes a batch of mustangs soft deleted before a cutoff
+	"purge-mustangs": `
+  DELETE FROM
+    mustangs
+  WHERE
+    mustang_id IN (
+      SELECT mustang_id FROM mustangs
+      WHERE
+        deleted_at < datetime(?)
+      ORDER BY
+        deleted_at, mustang_id
+      LIMIT ?
+    )
+  `,
+	// permanently deletes a batch of outbox events sent before a cutoff
+	"purge-outbox": `
+  DELETE FROM
+    outbox
+  WHERE
+    outbox_id IN (
+      SELECT outbox_id FROM outbox
+      WHERE
+        sent_at < datetime(?)
+      ORDER BY
+        sent_at
+      LIMIT ?
+    )
+  `,
+	// permanently deletes a batch of idempotency keys that expired before a cutoff
+	"purge-idempotency-keys": `
+  DELETE FROM
+    idempotency_keys
+  WHERE
+    rowid IN (
+      SELECT rowid FROM idempotency_keys
+      WHERE
+        expires_at < datetime(?)
+      ORDER BY
+        expires_at
+      LIMIT ?
+    )
+  `,
+}
+
+// sqliteBatchGetMustangsQuery gets all mustang rows matching a set of ids, the IN list
+// varies in length so the placeholders are expanded when the query is run
+const sqliteBatchGetMustangsQuery = `
+  SELECT
+    mustang_id, name, version
+  FROM
+    mustangs
+  WHERE
+    mustang_id IN (%s)
+    AND deleted_at IS NULL
+  `
+
+// sqlitePatchMustangQuery updates only the columns named in a field mask on a single mustang row by ID,
+// the SET list is built from the mask when the query is run and a version of 0 skips the version check
+const sqlitePatchMustangQuery = `
+  UPDATE
+    mustangs
+  SET
+    %s,
+    version = version + 1,
+    updated_at = CURRENT_TIMESTAMP
+  WHERE
+    mustang_id = ?
+    AND deleted_at IS NULL
+    AND (? = 0 OR version = ?)
+  `
@@ -3,11 +3,14 @@ package db
 import (
 	"context"
 	"database/sql"
+	"strconv"
+	"unicode/utf8"
 
 	"github.com/caring/go-packages/pkg/errors"
-	_ "github.com/caring/go-packages/pkg/uuid"
-	// anonymous import so package exports are not exposed
+	"github.com/google/uuid"
+	// anonymous imports so package exports are not exposed
 	_ "github.com/go-sql-driver/mysql"
+	_ "modernc.org/sqlite"
 )
 
 type ctxKey struct{}
@@ -18,18 +21,39 @@ var txCtxKey = ctxKey{}
 // of statements that we will use to interface with
 // a backing store
 type Store struct {
-	db    *sql.DB
-	stmts map[string]*sql.Stmt
+	db      *sql.DB
+	stmts   map[string]*sql.Stmt
+	dialect *dialect
+
+	retry     retryPolicy
+	txRetries uint64
+
+	replicaDSNs []string
+	replicas    *replicaSet
+
+	changes *Broadcaster
+
+	Mustang  *mustangService
+	Category *categoryService
+	Product  *productService
 }
 
-// NewStore will give a pointer to a MySQL instance ready to run queries against.
-func NewStore(dataSourceName string) (*Store, error) {
-	unprepared := statements
+// Option configures optional behaviour of a Store
+type Option func(*Store)
 
-	db, err := sql.Open("mysql", dataSourceName)
+// NewStore will give a pointer to a database instance ready to run queries against. The scheme of
+// dataSourceName picks the database, sqlite://path opens a SQLite file and anything else is MySQL
+func NewStore(dataSourceName string, opts ...Option) (*Store, error) {
+	d, dsn := parseDSN(dataSourceName)
+	unprepared := d.statements
+
+	db, err := sql.Open(d.driverName, dsn)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
+	if d.maxOpenConns > 0 {
+		db.SetMaxOpenConns(d.maxOpenConns)
+	}
 
 	stmts, err := prepareStmts(db, unprepared)
 	if err != nil {
@@ -41,12 +65,52 @@ func NewStore(dataSourceName string) (*Store, error) {
 		return nil, errors.WithStack(err)
 	}
 
+	s := newStore(db, d, stmts, opts...)
+
+	if err = s.replicas.open(d.driverName, s.replicaDSNs, unprepared); err != nil {
+		s.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// newStore wires the table services of a store to a connection and its prepared statements
+func newStore(db *sql.DB, d *dialect, stmts map[string]*sql.Stmt, opts ...Option) *Store {
+	replicas := &replicaSet{}
+
 	s := Store{
-		db:    db,
-		stmts: stmts,
+		db:       db,
+		stmts:    stmts,
+		dialect:  d,
+		retry:    defaultRetryPolicy,
+		replicas: replicas,
+		Mustang: &mustangService{
+			db:             db,
+			stmts:          stmts,
+			dialect:        d,
+			replicas:       replicas,
+			idempotencyTTL: DefaultIdempotencyTTL,
+		},
+		Category: &categoryService{
+			db:       db,
+			stmts:    stmts,
+			replicas: replicas,
+		},
+		Product: &productService{
+			db:       db,
+			stmts:    stmts,
+			replicas: replicas,
+		},
 	}
 
-	return &s, nil
+	s.Mustang.withTx = s.WithTx
+
+	for _, opt := range opts {
+		opt(&s)
+	}
+
+	return &s
 }
 
 // prepareStmts will attempt to prepare each unprepared
@@ -64,32 +128,88 @@ func prepareStmts(db *sql.DB, unprepared map[string]string) (map[string]*sql.Stm
 	return prepared, nil
 }
 
-// Close will close the connection to the underlying database
+// Close will close the connection to the underlying database and any replicas
 func (s *Store) Close() error {
 	err := s.db.Close()
 	if err != nil {
 		return errors.WithStack(err)
 	}
-	return nil
+	return s.replicas.close()
 }
 
 // Ping will check the connection to the underlying database
 func (s *Store) Ping(ctx context.Context) error {
-	if err = s.db.PingContext(ctx); err != nil {
-    return err
-  }
-  return nil
+	if err := s.db.PingContext(ctx); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
 }
 
 // GetTx initializes a db transaction
 func (s *Store) GetTx() (*sql.Tx, error) {
-	tx, err := s.db.Begin()
+	return s.BeginTx(context.Background(), nil)
+}
+
+// BeginTx initializes a db transaction bound to ctx with the given options,
+// a nil opts uses the driver's default isolation level
+func (s *Store) BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error) {
+	tx, err := s.db.BeginTx(ctx, opts)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
 	return tx, nil
 }
 
+// WithTx runs fn inside of a transaction. The ctx passed to fn carries the tx so every
+// store method called with it, Tx variant or not, joins the transaction. The tx is committed
+// when fn returns nil and rolled back when fn returns an error or panics.
+// When ctx already carries a tx fn runs inside of a savepoint instead, an error or panic only
+// rolls back the work fn did and the outer transaction carries on. opts are ignored when nesting.
+// A transaction that fails with a deadlock or lock wait timeout is run again from the start,
+// so fn must be safe to repeat. The mustang changes made by fn are published to the store's
+// broadcaster once the transaction commits
+func (s *Store) WithTx(ctx context.Context, opts *sql.TxOptions, fn func(ctx context.Context) error) error {
+	if tx, txErr := FromCtx(ctx); txErr == nil {
+		return runSavepoint(ctx, tx, fn)
+	}
+
+	return s.retryTx(ctx, func() error {
+		return s.runTx(ctx, opts, fn)
+	})
+}
+
+// runTx runs fn inside of a single new transaction
+func (s *Store) runTx(ctx context.Context, opts *sql.TxOptions, fn func(ctx context.Context) error) (err error) {
+	tx, err := s.BeginTx(ctx, opts)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		}
+	}()
+
+	ctx, pending := withPendingChanges(ToCtx(ctx, tx))
+
+	if err = fn(ctx); err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil && !errors.Is(rbErr, sql.ErrTxDone) {
+			return errors.Wrap(err, "rollback failed: "+rbErr.Error())
+		}
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return errors.WithStack(err)
+	}
+
+	s.changes.Publish(pending.changes...)
+
+	return nil
+}
+
 // ToCtx stores a sql.Tx within a context
 func ToCtx(ctx context.Context, tx *sql.Tx) context.Context {
 	return context.WithValue(ctx, txCtxKey, tx)
@@ -104,3 +224,60 @@ func FromCtx(ctx context.Context) (*sql.Tx, error) {
 	}
 	return nil, errors.New("No *sql.Tx present in context")
 }
+
+// querier is satisfied by both *sql.DB and *sql.Tx
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// txStmt returns stmt bound to the tx in ctx when one is present. when useTx is set
+// a tx is required and its absence is an error, otherwise stmt is returned as is
+func txStmt(ctx context.Context, useTx bool, stmt *sql.Stmt) (*sql.Stmt, error) {
+	tx, err := FromCtx(ctx)
+	if err != nil {
+		if useTx {
+			return nil, err
+		}
+		return stmt, nil
+	}
+	return tx.StmtContext(ctx, stmt), nil
+}
+
+// txConn returns the tx in ctx when one is present for queries that can't be prepared
+// ahead of time. when useTx is set a tx is required and its absence is an error
+func txConn(ctx context.Context, useTx bool, db *sql.DB) (querier, error) {
+	tx, err := FromCtx(ctx)
+	if err != nil {
+		if useTx {
+			return nil, err
+		}
+		return db, nil
+	}
+	return tx, nil
+}
+
+// ParseUUID parses a string into a UUID, an empty string is
+// treated as the zero value UUID
+func ParseUUID(ID string) (uuid.UUID, error) {
+	if ID == "" {
+		return uuid.Nil, nil
+	}
+	uid, err := uuid.Parse(ID)
+	if err != nil {
+		return uuid.Nil, errors.Wrap(ErrInvalidID, err.Error())
+	}
+	return uid, nil
+}
+
+// MaxNameLength is the longest name, in characters, the name columns can store
+const MaxNameLength = 64
+
+// ValidateName returns ErrInvalidName for a name that is empty or too long to store
+func ValidateName(name string) error {
+	if name == "" || utf8.RuneCountInString(name) > MaxNameLength {
+		return errors.Wrap(ErrInvalidName, "a name must be 1 to "+strconv.Itoa(MaxNameLength)+" characters")
+	}
+	return nil
+}
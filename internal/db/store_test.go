@@ -1,8 +1,11 @@
 package db
 
 import (
+	"context"
+	"strings"
 	"testing"
 
+	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
 )
@@ -22,3 +25,109 @@ func TestParseUUID(t *testing.T) {
 		assert.Equal(t, uuid.Nil, result, "Expected string to be parsed to a 0 value UUID")
 	})
 }
+
+func TestValidateName(t *testing.T) {
+	// ensures names that fit the name columns are accepted, counting characters rather than bytes
+	for _, name := range []string{"F", strings.Repeat("é", MaxNameLength)} {
+		assert.NoError(t, ValidateName(name), "Expected the name to be valid")
+	}
+
+	// ensures empty names and names too long to store are rejected
+	for _, name := range []string{"", strings.Repeat("F", MaxNameLength+1)} {
+		assert.ErrorIs(t, ValidateName(name), ErrInvalidName, "Expected an invalid name error")
+	}
+}
+
+func TestStore_WithTx(t *testing.T) {
+	mustangID := uuid.MustParse("72bc87f3-4a9f-4d05-93fe-844d3cd94c65")
+	stmt := withOutbox(map[string]string{
+		"delete-mustang":      "UPDATE mustangs",
+		"get-mustang-version": "SELECT version",
+	})
+
+	// ensures the tx is committed when fn succeeds and non Tx methods join it
+	t.Run("Commits on success", func(t *testing.T) {
+		store, mock, err := NewTestDB(stmt)
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "test setup failed")
+		}
+
+		mock.ExpectBegin()
+		expectMustangSnapshot(mock, Mustang{ID: mustangID, Name: "Foobar", Version: 1}, false)
+		mock.ExpectExec("UPDATE mustangs").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		expectMustangEvent(mock, EventMustangDeleted, Mustang{ID: mustangID, Name: "Foobar", Version: 2}, true)
+		mock.ExpectCommit()
+
+		err = store.WithTx(context.Background(), nil, func(ctx context.Context) error {
+			_, err := store.Mustang.Delete(ctx, mustangID, 0)
+			return err
+		})
+		assert.NoError(t, err, "Expecting no error")
+
+		err = mock.ExpectationsWereMet()
+		assert.NoError(t, err, "Expecting all mock conditions to be met")
+	})
+
+	// ensures the tx is rolled back and the error returned when fn fails
+	t.Run("Rolls back on error", func(t *testing.T) {
+		store, mock, err := NewTestDB(stmt)
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "test setup failed")
+		}
+
+		mock.ExpectBegin()
+		mock.ExpectRollback()
+
+		err = store.WithTx(context.Background(), nil, func(ctx context.Context) error {
+			return assert.AnError
+		})
+		assert.ErrorIs(t, err, assert.AnError, "Expecting the error from fn")
+
+		err = mock.ExpectationsWereMet()
+		assert.NoError(t, err, "Expecting all mock conditions to be met")
+	})
+
+	// ensures the tx is rolled back and the panic continues when fn panics
+	t.Run("Rolls back on panic", func(t *testing.T) {
+		store, mock, err := NewTestDB(stmt)
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "test setup failed")
+		}
+
+		mock.ExpectBegin()
+		mock.ExpectRollback()
+
+		assert.Panics(t, func() {
+			store.WithTx(context.Background(), nil, func(ctx context.Context) error {
+				panic("boom")
+			})
+		}, "Expecting the panic to be re-raised")
+
+		err = mock.ExpectationsWereMet()
+		assert.NoError(t, err, "Expecting all mock conditions to be met")
+	})
+
+	// ensures a nested call runs in a savepoint of the outer tx instead of beginning another
+	t.Run("Nests in an ambient tx", func(t *testing.T) {
+		store, mock, err := NewTestDB(stmt)
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "test setup failed")
+		}
+
+		mock.ExpectBegin()
+		mock.ExpectExec("SAVEPOINT sp_1").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("RELEASE SAVEPOINT sp_1").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectCommit()
+
+		err = store.WithTx(context.Background(), nil, func(ctx context.Context) error {
+			return store.WithTx(ctx, nil, func(ctx context.Context) error {
+				return nil
+			})
+		})
+		assert.NoError(t, err, "Expecting no error")
+
+		err = mock.ExpectationsWereMet()
+		assert.NoError(t, err, "Expecting all mock conditions to be met")
+	})
+}
@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/caring/ford-mustang/internal/db"
+)
+
+// ActorMetadataKey is the request metadata key naming who is making the request,
+// it is recorded as the actor in the audit history of the records the request changes
+const ActorMetadataKey = "x-actor"
+
+// withActor stores the actor named in the incoming metadata of ctx for the store to record
+func withActor(ctx context.Context) context.Context {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ctx
+	}
+	if actors := md.Get(ActorMetadataKey); len(actors) > 0 {
+		return db.WithActor(ctx, actors[0])
+	}
+	return ctx
+}
+
+// UnaryActorInterceptor passes the actor from the request metadata to unary handlers
+func UnaryActorInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	return handler(withActor(ctx), req)
+}
+
+// contextStream overrides the context of a server stream with one the interceptors have added to
+type contextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+// Context returns the stream's overridden context
+func (s *contextStream) Context() context.Context {
+	return s.ctx
+}
+
+// StreamActorInterceptor passes the actor from the request metadata to stream handlers
+func StreamActorInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	return handler(srv, &contextStream{ServerStream: ss, ctx: withActor(ss.Context())})
+}
@@ -0,0 +1,33 @@
+package handlers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/caring/ford-mustang/internal/db"
+)
+
+func TestUnaryActorInterceptor(t *testing.T) {
+	var actor string
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		actor = db.ActorFromCtx(ctx)
+		return nil, nil
+	}
+
+	// ensures the actor in the metadata reaches the handler
+	t.Run("Actor in metadata", func(t *testing.T) {
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(ActorMetadataKey, "support@caring.com"))
+		UnaryActorInterceptor(ctx, nil, &grpc.UnaryServerInfo{}, handler)
+		assert.Equal(t, "support@caring.com", actor, "Expected the actor from the metadata")
+	})
+
+	// ensures requests without an actor are recorded as unknown
+	t.Run("No actor", func(t *testing.T) {
+		UnaryActorInterceptor(context.Background(), nil, &grpc.UnaryServerInfo{}, handler)
+		assert.Equal(t, db.UnknownActor, actor, "Expected the unknown actor")
+	})
+}
@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"context"
+
+	"github.com/caring/go-packages/pkg/errors"
+	"github.com/caring/go-packages/pkg/logging"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/caring/ford-mustang/internal/db"
+)
+
+// errorDomain is the domain attached to the ErrorInfo detail of translated errors
+const errorDomain = "ford-mustang"
+
+// internalMessage is the message unrecognized errors are returned with, their own message can
+// expose queries, hosts and other internals so it is only logged
+const internalMessage = "internal error"
+
+// errorMapping pairs a store sentinel error with the canonical grpc code
+// and machine readable reason returned to clients
+type errorMapping struct {
+	err    error
+	code   codes.Code
+	reason string
+}
+
+// errorMappings are checked in order, the first sentinel found in an
+// error's chain determines the code it is translated to
+var errorMappings = []errorMapping{
+	{db.ErrNotFound, codes.NotFound, "NOT_FOUND"},
+	{db.ErrNoRows, codes.NotFound, "NO_ROWS"},
+	{db.ErrNotCreated, codes.AlreadyExists, "NOT_CREATED"},
+	{db.ErrNoRowsAffected, codes.FailedPrecondition, "NO_ROWS_AFFECTED"},
+	{db.ErrInvalidID, codes.InvalidArgument, "INVALID_ID"},
+	{db.ErrInvalidName, codes.InvalidArgument, "INVALID_NAME"},
+	{db.ErrInvalidPageToken, codes.InvalidArgument, "INVALID_PAGE_TOKEN"},
+	{db.ErrBatchTooLarge, codes.InvalidArgument, "BATCH_TOO_LARGE"},
+	{db.ErrInvalidField, codes.InvalidArgument, "INVALID_FIELD"},
+	{db.ErrNotDeleted, codes.FailedPrecondition, "NOT_DELETED"},
+	{db.ErrVersionMismatch, codes.Aborted, "VERSION_MISMATCH"},
+	{db.ErrDuplicate, codes.AlreadyExists, "DUPLICATE"},
+	{db.ErrInvalidReference, codes.FailedPrecondition, "INVALID_REFERENCE"},
+	{db.ErrInvalidResumeToken, codes.InvalidArgument, "INVALID_RESUME_TOKEN"},
+	{db.ErrResumeTokenExpired, codes.OutOfRange, "RESUME_TOKEN_EXPIRED"},
+	{db.ErrWatchTooSlow, codes.ResourceExhausted, "WATCH_TOO_SLOW"},
+	{db.ErrWatchClosed, codes.Unavailable, "WATCH_CLOSED"},
+	{db.ErrInvalidIdempotencyKey, codes.InvalidArgument, "INVALID_IDEMPOTENCY_KEY"},
+	{db.ErrIdempotencyKeyReused, codes.InvalidArgument, "IDEMPOTENCY_KEY_REUSED"},
+	{db.ErrIdempotencyConflict, codes.Aborted, "IDEMPOTENCY_CONFLICT"},
+	{context.Canceled, codes.Canceled, "CANCELED"},
+	{context.DeadlineExceeded, codes.DeadlineExceeded, "DEADLINE_EXCEEDED"},
+}
+
+// ToGRPCError translates an error returned from the store into a grpc status error
+// with an ErrorInfo detail attached. Errors that already carry a grpc status are
+// returned untouched and unrecognized errors are returned as Internal with a generic message.
+func ToGRPCError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+
+	code, reason, msg := codes.Internal, "INTERNAL", internalMessage
+	for _, m := range errorMappings {
+		if errors.Is(err, m.err) {
+			code, reason, msg = m.code, m.reason, err.Error()
+			break
+		}
+	}
+
+	st := status.New(code, msg)
+	detailed, dErr := st.WithDetails(&errdetails.ErrorInfo{
+		Reason: reason,
+		Domain: errorDomain,
+	})
+	if dErr != nil {
+		return st.Err()
+	}
+
+	return detailed.Err()
+}
+
+// translateError translates err with ToGRPCError, logging the errors whose message is hidden from the client
+func translateError(logger *logging.Logger, method string, err error) error {
+	translated := ToGRPCError(err)
+	if _, ok := status.FromError(err); !ok && status.Code(translated) == codes.Internal {
+		logger.Error("Internal error:"+err.Error(), logging.String("method", method))
+	}
+	return translated
+}
+
+// UnaryErrorInterceptor translates the errors returned from unary handlers into grpc status errors
+func UnaryErrorInterceptor(logger *logging.Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		resp, err := handler(ctx, req)
+		return resp, translateError(logger, info.FullMethod, err)
+	}
+}
+
+// StreamErrorInterceptor translates the errors returned from stream handlers into grpc status errors
+func StreamErrorInterceptor(logger *logging.Logger) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return translateError(logger, info.FullMethod, handler(srv, ss))
+	}
+}
@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/caring/go-packages/pkg/errors"
+	"github.com/caring/go-packages/pkg/logging"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/caring/ford-mustang/internal/db"
+)
+
+func TestToGRPCError(t *testing.T) {
+	cases := []struct {
+		name   string
+		err    error
+		code   codes.Code
+		reason string
+	}{
+		{"Not found", errors.Wrap(db.ErrNotFound, "Error executing get mustang - 1"), codes.NotFound, "NOT_FOUND"},
+		{"No rows", errors.Wrap(db.ErrNoRows, "Error executing get mustang - 1"), codes.NotFound, "NO_ROWS"},
+		{"Not created", errors.Wrap(db.ErrNotCreated, "Error executing create mustang - 1"), codes.AlreadyExists, "NOT_CREATED"},
+		{"No rows affected", errors.Wrap(db.ErrNoRowsAffected, "Error executing update mustang - 1"), codes.FailedPrecondition, "NO_ROWS_AFFECTED"},
+		{"Invalid ID", errors.Wrap(db.ErrInvalidID, "invalid UUID length: 3"), codes.InvalidArgument, "INVALID_ID"},
+		{"Invalid name", errors.Wrap(db.ErrInvalidName, "a name must be 1 to 64 characters"), codes.InvalidArgument, "INVALID_NAME"},
+		{"Resume token expired", errors.Wrap(db.ErrResumeTokenExpired, "issued by another broadcaster"), codes.OutOfRange, "RESUME_TOKEN_EXPIRED"},
+		{"Watch closed", errors.Wrap(db.ErrWatchClosed, "shutting down"), codes.Unavailable, "WATCH_CLOSED"},
+		{"Unknown error", errors.New("connection refused"), codes.Internal, "INTERNAL"},
+	}
+
+	for _, c := range cases {
+		c := c
+		// ensures each store error is translated to the correct code and detail
+		t.Run(c.name, func(t *testing.T) {
+			st, ok := status.FromError(ToGRPCError(c.err))
+			if ok := assert.True(t, ok, "Expected a grpc status error"); !ok {
+				assert.FailNow(t, "error was not translated")
+			}
+
+			assert.Equal(t, c.code, st.Code(), "Expected code to be mapped correctly")
+			msg := c.err.Error()
+			if c.code == codes.Internal {
+				msg = internalMessage
+			}
+			assert.Equal(t, msg, st.Message(), "Expected message to be preserved unless it is internal")
+
+			details := st.Details()
+			if ok := assert.Len(t, details, 1, "Expected an error detail to be attached"); !ok {
+				assert.FailNow(t, "missing error details")
+			}
+			info, ok := details[0].(*errdetails.ErrorInfo)
+			assert.True(t, ok, "Expected detail to be an ErrorInfo")
+			assert.Equal(t, c.reason, info.Reason, "Expected reason to be mapped correctly")
+			assert.Equal(t, errorDomain, info.Domain, "Expected domain to be set")
+		})
+	}
+
+	// ensures existing status errors are passed through untouched
+	t.Run("Existing status error", func(t *testing.T) {
+		err := status.Error(codes.PermissionDenied, "nope")
+
+		assert.Equal(t, err, ToGRPCError(err), "Expected status error to be returned as is")
+	})
+
+	// ensures nil errors stay nil
+	t.Run("Nil error", func(t *testing.T) {
+		assert.NoError(t, ToGRPCError(nil), "Expected no error")
+	})
+}
+
+func TestUnaryErrorInterceptor(t *testing.T) {
+	logger, err := logging.NewLogger(&logging.Config{})
+	if ok := assert.NoError(t, err, "Expected no error"); !ok {
+		assert.FailNow(t, "test setup failed")
+	}
+	interceptor := UnaryErrorInterceptor(logger)
+	info := &grpc.UnaryServerInfo{FullMethod: "/ford-mustang.FordMustangService/GetMustang"}
+
+	// ensures store errors are translated
+	t.Run("Store error", func(t *testing.T) {
+		handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+			return nil, errors.Wrap(db.ErrNotFound, "Error executing get mustang - 1")
+		}
+
+		_, err := interceptor(context.Background(), nil, info, handler)
+
+		assert.Equal(t, codes.NotFound, status.Code(err), "Expected handler error to be translated")
+	})
+
+	// ensures the message of an unrecognized error does not reach the client
+	t.Run("Unknown error", func(t *testing.T) {
+		handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+			return nil, errors.New("dial tcp 10.0.0.12:3306: connection refused")
+		}
+
+		_, err := interceptor(context.Background(), nil, info, handler)
+
+		assert.Equal(t, codes.Internal, status.Code(err), "Expected handler error to be internal")
+		assert.Equal(t, "internal error", status.Convert(err).Message(), "Expected a generic message")
+	})
+}
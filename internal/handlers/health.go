@@ -0,0 +1,223 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/caring/go-packages/pkg/logging"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// Check reports whether a dependency of the service is available
+type Check func(ctx context.Context) error
+
+// Dependency statuses reported by the health endpoints
+const (
+	StatusUp   = "up"
+	StatusDown = "down"
+)
+
+// Reasons a dependency is reported down for. The endpoints share the API port so the check's own
+// error, which can name hosts and DSNs, is only logged
+const (
+	ReasonTimeout     = "timeout"
+	ReasonUnavailable = "unavailable"
+)
+
+// DependencyStatus is the outcome of the last check of a dependency
+type DependencyStatus struct {
+	Status    string    `json:"status"`
+	Reason    string    `json:"reason,omitempty"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// healthReport is the body of the /livez and /readyz responses
+type healthReport struct {
+	Status string                      `json:"status"`
+	Checks map[string]DependencyStatus `json:"checks"`
+}
+
+// HealthChecker periodically checks the dependencies of the service and reports the result through
+// the grpc.health.v1.Health service and the /livez and /readyz HTTP endpoints. The service is ready
+// while every dependency is up, it starts out not ready until the first round of checks completes
+type HealthChecker struct {
+	logger   *logging.Logger
+	server   *health.Server
+	services []string
+	checks   map[string]Check
+	timeout  time.Duration
+
+	mu      sync.RWMutex
+	results map[string]DependencyStatus
+	ready   bool
+	// draining is set once the service starts shutting down, it is never ready again
+	draining bool
+}
+
+// NewHealthChecker gives a health checker for the named gRPC services, each check is given timeout
+// to complete. The overall server status, the empty service name, is always reported. Failed checks
+// are logged to logger when their dependency goes down
+func NewHealthChecker(logger *logging.Logger, timeout time.Duration, services []string, checks map[string]Check) *HealthChecker {
+	h := &HealthChecker{
+		logger:   logger,
+		server:   health.NewServer(),
+		services: append([]string{""}, services...),
+		checks:   checks,
+		timeout:  timeout,
+		results:  map[string]DependencyStatus{},
+	}
+	h.setServing(false)
+	return h
+}
+
+// Server is the grpc.health.v1.Health implementation to register on the gRPC server
+func (h *HealthChecker) Server() healthpb.HealthServer {
+	return h.server
+}
+
+// Run checks the dependencies right away and then on every interval until ctx is done
+func (h *HealthChecker) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		h.CheckNow(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// CheckNow runs every check concurrently and updates the reported status with the results
+func (h *HealthChecker) CheckNow(ctx context.Context) {
+	results := make(map[string]DependencyStatus, len(h.checks))
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+
+	for name, check := range h.checks {
+		wg.Add(1)
+		go func(name string, check Check) {
+			defer wg.Done()
+
+			ctx, cancel := context.WithTimeout(ctx, h.timeout)
+			defer cancel()
+
+			result := DependencyStatus{Status: StatusUp}
+			if err := check(ctx); err != nil {
+				result = DependencyStatus{Status: StatusDown, Reason: ReasonUnavailable}
+				if errors.Is(err, context.DeadlineExceeded) {
+					result.Reason = ReasonTimeout
+				}
+				h.logFailure(name, err)
+			}
+			result.CheckedAt = time.Now().UTC()
+
+			mu.Lock()
+			results[name] = result
+			mu.Unlock()
+		}(name, check)
+	}
+	wg.Wait()
+
+	ready := true
+	for _, result := range results {
+		if result.Status != StatusUp {
+			ready = false
+		}
+	}
+
+	h.mu.Lock()
+	h.results = results
+	h.ready = ready && !h.draining
+	h.mu.Unlock()
+
+	h.setServing(ready)
+}
+
+// logFailure logs the error of a failed check when its dependency was up at the last check,
+// so an outage is logged once rather than on every interval
+func (h *HealthChecker) logFailure(name string, err error) {
+	h.mu.RLock()
+	last, checked := h.results[name]
+	h.mu.RUnlock()
+
+	if checked && last.Status == StatusDown {
+		return
+	}
+	h.logger.Warn("Health check failed:"+err.Error(), logging.String("dependency", name))
+}
+
+// Shutdown reports every service as not serving from now on regardless of its dependencies,
+// so load balancers stop routing new requests to the instance while it drains
+func (h *HealthChecker) Shutdown() {
+	h.mu.Lock()
+	h.draining = true
+	h.ready = false
+	h.mu.Unlock()
+
+	// later status updates are ignored once the health server is shut down
+	h.server.Shutdown()
+}
+
+// Ready reports whether every dependency was up at the last check and the service is not shutting down
+func (h *HealthChecker) Ready() bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.ready
+}
+
+// setServing sets the gRPC health status of every service
+func (h *HealthChecker) setServing(serving bool) {
+	status := healthpb.HealthCheckResponse_NOT_SERVING
+	if serving {
+		status = healthpb.HealthCheckResponse_SERVING
+	}
+	for _, service := range h.services {
+		h.server.SetServingStatus(service, status)
+	}
+}
+
+// Livez answers 200 for as long as the process is able to serve HTTP, dependencies are reported
+// but don't fail it so a dependency outage does not get the instance restarted
+func (h *HealthChecker) Livez(w http.ResponseWriter, r *http.Request) {
+	h.report(w, http.StatusOK, "alive")
+}
+
+// Readyz answers 200 while every dependency is up and 503 otherwise, so the instance is taken out
+// of rotation when it can't serve requests
+func (h *HealthChecker) Readyz(w http.ResponseWriter, r *http.Request) {
+	h.mu.RLock()
+	ready, draining := h.ready, h.draining
+	h.mu.RUnlock()
+
+	switch {
+	case ready:
+		h.report(w, http.StatusOK, "ready")
+	case draining:
+		h.report(w, http.StatusServiceUnavailable, "shutting down")
+	default:
+		h.report(w, http.StatusServiceUnavailable, "not ready")
+	}
+}
+
+// report writes the last check of every dependency as JSON
+func (h *HealthChecker) report(w http.ResponseWriter, code int, status string) {
+	h.mu.RLock()
+	body := healthReport{Status: status, Checks: h.results}
+	h.mu.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/caring/go-packages/pkg/logging"
+	"github.com/stretchr/testify/assert"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func TestHealthChecker(t *testing.T) {
+	ctx := context.Background()
+	logger, err := logging.NewLogger(&logging.Config{})
+	if ok := assert.NoError(t, err, "Expected no error"); !ok {
+		assert.FailNow(t, "test setup failed")
+	}
+	var dbErr error
+	checks := map[string]Check{
+		"database": func(ctx context.Context) error { return dbErr },
+	}
+
+	servingStatus := func(h *HealthChecker, service string) healthpb.HealthCheckResponse_ServingStatus {
+		resp, err := h.Server().Check(ctx, &healthpb.HealthCheckRequest{Service: service})
+		if !assert.NoError(t, err, "Expecting no health check error") {
+			return healthpb.HealthCheckResponse_UNKNOWN
+		}
+		return resp.Status
+	}
+
+	readyz := func(h *HealthChecker) (int, healthReport) {
+		w := httptest.NewRecorder()
+		h.Readyz(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		var body healthReport
+		assert.NoError(t, json.NewDecoder(w.Body).Decode(&body), "Expecting a JSON body")
+		return w.Code, body
+	}
+
+	// ensures the service is not ready before the first round of checks
+	t.Run("Not checked", func(t *testing.T) {
+		h := NewHealthChecker(logger, time.Second, []string{"ford-mustang.FordMustangService"}, checks)
+
+		assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, servingStatus(h, ""), "Expected not serving")
+		code, _ := readyz(h)
+		assert.Equal(t, http.StatusServiceUnavailable, code, "Expected not ready")
+	})
+
+	// ensures every service serves while the dependencies are up
+	t.Run("Dependencies up", func(t *testing.T) {
+		dbErr = nil
+		h := NewHealthChecker(logger, time.Second, []string{"ford-mustang.FordMustangService"}, checks)
+		h.CheckNow(ctx)
+
+		assert.Equal(t, healthpb.HealthCheckResponse_SERVING, servingStatus(h, ""), "Expected the server to be serving")
+		assert.Equal(t, healthpb.HealthCheckResponse_SERVING, servingStatus(h, "ford-mustang.FordMustangService"), "Expected the service to be serving")
+		code, body := readyz(h)
+		assert.Equal(t, http.StatusOK, code, "Expected ready")
+		assert.Equal(t, StatusUp, body.Checks["database"].Status, "Expected the database to be up")
+	})
+
+	// ensures a dead dependency takes the service out of rotation but leaves it alive
+	t.Run("Dependency down", func(t *testing.T) {
+		dbErr = errors.New("dial tcp 10.0.0.12:3306: connection refused")
+		h := NewHealthChecker(logger, time.Second, []string{"ford-mustang.FordMustangService"}, checks)
+		h.CheckNow(ctx)
+
+		assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, servingStatus(h, "ford-mustang.FordMustangService"), "Expected not serving")
+		code, body := readyz(h)
+		assert.Equal(t, http.StatusServiceUnavailable, code, "Expected not ready")
+		assert.Equal(t, StatusDown, body.Checks["database"].Status, "Expected the database to be down")
+		assert.Equal(t, ReasonUnavailable, body.Checks["database"].Reason, "Expected a fixed reason rather than the check error")
+
+		w := httptest.NewRecorder()
+		h.Livez(w, httptest.NewRequest(http.MethodGet, "/livez", nil))
+		assert.Equal(t, http.StatusOK, w.Code, "Expected the instance to stay alive")
+		assert.NotContains(t, w.Body.String(), "10.0.0.12", "Expected the check error to stay out of the response")
+	})
+
+	// ensures a service shutting down stays out of rotation even while its dependencies are up
+	t.Run("Shutdown", func(t *testing.T) {
+		dbErr = nil
+		h := NewHealthChecker(logger, time.Second, []string{"ford-mustang.FordMustangService"}, checks)
+		h.CheckNow(ctx)
+		h.Shutdown()
+		h.CheckNow(ctx)
+
+		assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, servingStatus(h, ""), "Expected not serving")
+		code, body := readyz(h)
+		assert.Equal(t, http.StatusServiceUnavailable, code, "Expected not ready")
+		assert.Equal(t, "shutting down", body.Status, "Expected the instance to be draining")
+	})
+}
@@ -0,0 +1,41 @@
+package handlers
+
+import (
+	"context"
+	"strconv"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/caring/ford-mustang/internal/db"
+)
+
+// ReadPrimaryMetadataKey is the request metadata key asking for the request's reads to go to the
+// primary rather than a replica, set it to true to read your own writes. REST callers send it as
+// the Grpc-Metadata-X-Read-Primary header
+const ReadPrimaryMetadataKey = "x-read-primary"
+
+// withReadPrimary marks ctx to read from the primary when its incoming metadata asks to,
+// values that aren't a bool are ignored and the read goes wherever it would have
+func withReadPrimary(ctx context.Context) context.Context {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ctx
+	}
+	if values := md.Get(ReadPrimaryMetadataKey); len(values) > 0 {
+		if primary, err := strconv.ParseBool(values[0]); err == nil && primary {
+			return db.WithPrimary(ctx)
+		}
+	}
+	return ctx
+}
+
+// UnaryReadPrimaryInterceptor sends the reads of unary handlers to the primary when the request metadata asks to
+func UnaryReadPrimaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	return handler(withReadPrimary(ctx), req)
+}
+
+// StreamReadPrimaryInterceptor sends the reads of stream handlers to the primary when the request metadata asks to
+func StreamReadPrimaryInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	return handler(srv, &contextStream{ServerStream: ss, ctx: withReadPrimary(ss.Context())})
+}
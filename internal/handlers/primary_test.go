@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/caring/ford-mustang/internal/db"
+)
+
+func TestUnaryReadPrimaryInterceptor(t *testing.T) {
+	var primary bool
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		primary = db.PrimaryFromCtx(ctx)
+		return nil, nil
+	}
+
+	// ensures asking for the primary in the metadata marks the handler's context
+	t.Run("Primary in metadata", func(t *testing.T) {
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(ReadPrimaryMetadataKey, "true"))
+		UnaryReadPrimaryInterceptor(ctx, nil, &grpc.UnaryServerInfo{}, handler)
+		assert.True(t, primary, "Expected reads to go to the primary")
+	})
+
+	// ensures false and values that aren't a bool leave the reads on the replicas
+	for _, value := range []string{"false", "yes please"} {
+		t.Run("Primary "+value, func(t *testing.T) {
+			ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(ReadPrimaryMetadataKey, value))
+			UnaryReadPrimaryInterceptor(ctx, nil, &grpc.UnaryServerInfo{}, handler)
+			assert.False(t, primary, "Expected reads to go to a replica")
+		})
+	}
+
+	// ensures requests without the key read from the replicas
+	t.Run("No metadata", func(t *testing.T) {
+		UnaryReadPrimaryInterceptor(context.Background(), nil, &grpc.UnaryServerInfo{}, handler)
+		assert.False(t, primary, "Expected reads to go to a replica")
+	})
+}
@@ -2,11 +2,29 @@
 if [ -f "$(command -v protoc)" ]; then
     VER=$(protoc --version)
     PBDIR="ford-mustang/pb/"
+    # google/api/annotations.proto and google/api/http.proto come from https://github.com/googleapis/googleapis,
+    # they are not committed. fetch them once with
+    #   git clone --depth 1 https://github.com/googleapis/googleapis ford-mustang/third_party/googleapis
+    # or set GOOGLEAPIS to an existing checkout
+    GOOGLEAPIS=${GOOGLEAPIS:-"ford-mustang/third_party/googleapis/"}
+    if [ ! -f "${GOOGLEAPIS}google/api/annotations.proto" ]; then
+        echo "Error: google/api/annotations.proto was not found in $GOOGLEAPIS."
+        echo "Run: git clone --depth 1 https://github.com/googleapis/googleapis ${GOOGLEAPIS%/}"
+        echo "or set GOOGLEAPIS to a googleapis checkout."
+        exit 1
+    fi
     echo "Using protoc version: $VER"
+    # the OpenAPI document needs protoc-gen-openapi from github.com/google/gnostic, it is written
+    # to openapi.yaml and embedded into the binary by openapi.go
     protoc \
       --proto_path=$PBDIR \
+      --proto_path=$GOOGLEAPIS \
       --go_out=plugins=grpc:$PBDIR \
-      --go_opt=paths=source_relative $PBDIR*.proto
+      --go_opt=paths=source_relative \
+      --grpc-gateway_out=$PBDIR \
+      --grpc-gateway_opt=paths=source_relative \
+      --openapi_out=$PBDIR \
+      --openapi_opt=title="Ford Mustang API",version=v1,naming=json $PBDIR*.proto
 else
     echo "Error: protoc was not found. Please check that it is installed."
-fi
\ No newline at end of file
+fi
@@ -0,0 +1,13 @@
+package pb
+
+import (
+	// embed is imported for the go:embed directive below
+	_ "embed"
+)
+
+// OpenAPI is the OpenAPI v3 document describing the REST gateway as YAML, gen_proto.sh
+// generates it from the google.api.http annotations in service.proto. The generated document is
+// committed next to this file, regenerate it whenever the annotations change
+//
+//go:embed openapi.yaml
+var OpenAPI []byte